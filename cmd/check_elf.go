@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+)
+
+var (
+	CheckELFPathFlag = &cli.PathFlag{
+		Name:      "path",
+		Usage:     "Path to 32-bit big-endian MIPS ELF file",
+		TakesFile: true,
+		Required:  true,
+	}
+	CheckELFOutFlag = &cli.PathFlag{
+		Name:  "out",
+		Usage: "Output path to write the JSON report to. None if empty.",
+	}
+	CheckELFWarningsFlag = &cli.BoolFlag{
+		Name:  "warnings",
+		Usage: "Also print warnings, like unconditional traps and unsupported syscalls, which only matter if the code is reached.",
+	}
+)
+
+func CheckELF(ctx *cli.Context) error {
+	elfPath := ctx.Path(CheckELFPathFlag.Name)
+	elfProgram, err := elf.Open(elfPath)
+	if err != nil {
+		return fmt.Errorf("failed to open ELF file %q: %w", elfPath, err)
+	}
+	defer elfProgram.Close()
+	report, err := mipsevm.CheckELF(elfProgram)
+	if err != nil {
+		return fmt.Errorf("failed to check ELF file: %w", err)
+	}
+	if outPath := ctx.Path(CheckELFOutFlag.Name); outPath != "" {
+		if err := writeJSON[*mipsevm.CompatReport](outPath, report, true); err != nil {
+			return fmt.Errorf("failed to output report: %w", err)
+		}
+	}
+	warnings := 0
+	for _, issue := range report.Issues {
+		if issue.Severity == mipsevm.SeverityWarning {
+			warnings += 1
+			if !ctx.Bool(CheckELFWarningsFlag.Name) {
+				continue
+			}
+		}
+		_, _ = fmt.Fprintln(os.Stdout, issue.String())
+	}
+	errCount := len(report.Errors())
+	_, _ = fmt.Fprintf(os.Stdout, "%d errors, %d warnings\n", errCount, warnings)
+	if errCount > 0 {
+		return errors.New("ELF file is not compatible with the VM")
+	}
+	return nil
+}
+
+var CheckELFCommand = &cli.Command{
+	Name:        "check-elf",
+	Usage:       "Check if an ELF file can be executed by the VM",
+	Description: "Statically check an ELF file for unsupported instructions, syscalls and program headers, attributed to their symbols.",
+	Action:      CheckELF,
+	Flags: []cli.Flag{
+		CheckELFPathFlag,
+		CheckELFOutFlag,
+		CheckELFWarningsFlag,
+	},
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+)
+
+var (
+	DecodeTypeFlag = &cli.StringFlag{
+		Name:  "type",
+		Usage: "type of the data: 'state' for a packed state witness, 'step-input' for MIPS step calldata, 'oracle-input' for pre-image oracle calldata, or 'auto' to detect the type.",
+		Value: "auto",
+	}
+	DecodeInputFlag = &cli.PathFlag{
+		Name:      "input",
+		Usage:     "path of a file with the hex-encoded data, '-' for stdin. The data may also be passed as argument instead.",
+		TakesFile: true,
+	}
+)
+
+// readDecodeInput reads the hex-encoded data from the input file, or from the first argument.
+func readDecodeInput(ctx *cli.Context) ([]byte, error) {
+	var text string
+	switch path := ctx.Path(DecodeInputFlag.Name); {
+	case path == "-":
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read stdin: %w", err)
+		}
+		text = string(data)
+	case path != "":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file %q: %w", path, err)
+		}
+		text = string(data)
+	case ctx.Args().Len() == 1:
+		text = ctx.Args().First()
+	default:
+		return nil, errors.New("expected either an input file, or the data as single argument")
+	}
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "0x") {
+		text = "0x" + text
+	}
+	data, err := hexutil.Decode(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex data: %w", err)
+	}
+	return data, nil
+}
+
+// detectDecodeType returns the type of the data, by its method selector or size.
+func detectDecodeType(data []byte) (string, error) {
+	switch {
+	case len(data) == mipsevm.StateWitnessSize:
+		return "state", nil
+	case len(data) >= 4 && bytes.Equal(data[:4], mipsevm.StepBytes4):
+		return "step-input", nil
+	case len(data) >= 4 && (bytes.Equal(data[:4], mipsevm.CheatBytes4) ||
+		bytes.Equal(data[:4], mipsevm.LoadKeccak256PreimagePartBytes4) ||
+		bytes.Equal(data[:4], mipsevm.LoadSha256PreimagePartBytes4) ||
+		bytes.Equal(data[:4], mipsevm.InitLargeKeccak256PreimageBytes4) ||
+		bytes.Equal(data[:4], mipsevm.AbsorbLargeKeccak256PreimageBytes4) ||
+		bytes.Equal(data[:4], mipsevm.FinalizeLargeKeccak256PreimageBytes4)):
+		return "oracle-input", nil
+	default:
+		return "", fmt.Errorf("cannot detect the type of %d bytes of data", len(data))
+	}
+}
+
+func Decode(ctx *cli.Context) error {
+	data, err := readDecodeInput(ctx)
+	if err != nil {
+		return err
+	}
+	typ := ctx.String(DecodeTypeFlag.Name)
+	if typ == "auto" {
+		if typ, err = detectDecodeType(data); err != nil {
+			return err
+		}
+	}
+	var out any
+	switch typ {
+	case "state":
+		out, err = mipsevm.DecodeStateWitness(data)
+	case "step-input":
+		out, err = mipsevm.DecodeStepInput(data)
+	case "oracle-input":
+		out, err = mipsevm.DecodePreimageOracleInput(data)
+	default:
+		return fmt.Errorf("unknown type %q", typ)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", typ, err)
+	}
+	enc, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode to JSON: %w", err)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "%s\n", enc)
+	return nil
+}
+
+var DecodeCommand = &cli.Command{
+	Name:        "decode",
+	Usage:       "Decode a state witness or step calldata",
+	Description: "Decode a hex-encoded state witness, MIPS step calldata or pre-image oracle calldata, and print it as JSON. The data is read from the input file, or passed as argument.",
+	ArgsUsage:   "[hex data]",
+	Action:      Decode,
+	Flags: []cli.Flag{
+		DecodeTypeFlag,
+		DecodeInputFlag,
+	},
+}
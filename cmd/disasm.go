@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"bufio"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+)
+
+var (
+	DisasmStateFlag = &cli.PathFlag{
+		Name:      "state",
+		Usage:     "path of JSON state to disassemble memory of",
+		TakesFile: true,
+	}
+	DisasmELFFlag = &cli.PathFlag{
+		Name:      "elf",
+		Usage:     "path of 32-bit big-endian MIPS ELF file to disassemble the executable sections of",
+		TakesFile: true,
+	}
+	DisasmMetaFlag = &cli.PathFlag{
+		Name:  "meta",
+		Usage: "path to metadata file for symbol lookup when disassembling a state. Ignored for ELF files, which carry their own symbols.",
+		Value: "meta.json",
+	}
+	DisasmSymbolFlag = &cli.StringFlag{
+		Name:  "symbol",
+		Usage: "only disassemble the given symbol",
+	}
+	DisasmFromFlag = &cli.StringFlag{
+		Name:  "from",
+		Usage: "address to start disassembling state memory from. Defaults to the PC of the state.",
+	}
+	DisasmCountFlag = &cli.UintFlag{
+		Name:  "count",
+		Usage: "number of instructions to disassemble from state memory",
+		Value: 64,
+	}
+	DisasmOutFlag = &cli.PathFlag{
+		Name:  "out",
+		Usage: "output path to write the listing to. Stdout if left empty.",
+	}
+)
+
+// disasmRange writes a symbolized listing of the instructions in [start, end) to w.
+func disasmRange(w io.Writer, meta *mipsevm.Metadata, start uint32, end uint32, getInsn func(addr uint32) uint32) error {
+	lastSym := ""
+	for addr := start; addr < end && addr >= start; addr += 4 {
+		if name, _, ok := meta.LookupSymbolOffset(addr); ok && name != lastSym {
+			if _, err := fmt.Fprintf(w, "\n%s:\n", name); err != nil {
+				return err
+			}
+			lastSym = name
+		}
+		insn := getInsn(addr)
+		if _, err := fmt.Fprintf(w, "  %08x:  %08x  %s\n", addr, insn, mipsevm.Disassemble(addr, insn, meta)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func symbolRange(meta *mipsevm.Metadata, name string) (start uint32, end uint32, err error) {
+	for _, s := range meta.Symbols {
+		if s.Name == name {
+			return s.Start, s.Start + s.Size, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("symbol %q not found", name)
+}
+
+func disasmELF(w io.Writer, elfPath string, symbol string) error {
+	elfProgram, err := elf.Open(elfPath)
+	if err != nil {
+		return fmt.Errorf("failed to open ELF file %q: %w", elfPath, err)
+	}
+	defer elfProgram.Close()
+	if elfProgram.Machine != elf.EM_MIPS {
+		return fmt.Errorf("ELF is not big-endian MIPS R3000, but got %q", elfProgram.Machine.String())
+	}
+	meta, err := mipsevm.MakeMetadata(elfProgram)
+	if err != nil {
+		return fmt.Errorf("failed to compute program metadata: %w", err)
+	}
+	symStart, symEnd := uint32(0), ^uint32(0)
+	if symbol != "" {
+		symStart, symEnd, err = symbolRange(meta, symbol)
+		if err != nil {
+			return err
+		}
+	}
+	for _, sec := range elfProgram.Sections {
+		if sec.Type != elf.SHT_PROGBITS || sec.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		start, end := uint32(sec.Addr), uint32(sec.Addr+sec.Size)
+		if symStart > start {
+			start = symStart
+		}
+		if symEnd < end {
+			end = symEnd
+		}
+		if start >= end {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return fmt.Errorf("failed to read section %q: %w", sec.Name, err)
+		}
+		if _, err := fmt.Fprintf(w, "section %s:\n", sec.Name); err != nil {
+			return err
+		}
+		getInsn := func(addr uint32) uint32 {
+			offset := addr - uint32(sec.Addr)
+			if int(offset)+4 > len(data) {
+				return 0
+			}
+			return binary.BigEndian.Uint32(data[offset : offset+4])
+		}
+		if err := disasmRange(w, meta, start&^3, end, getInsn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func disasmState(w io.Writer, statePath string, metaPath string, symbol string, from string, count uint32) error {
+	state, err := loadJSON[mipsevm.State](statePath)
+	if err != nil {
+		return err
+	}
+	meta := &mipsevm.Metadata{Symbols: nil}
+	if metaPath != "" {
+		if meta, err = loadJSON[mipsevm.Metadata](metaPath); err != nil {
+			return fmt.Errorf("failed to load metadata: %w", err)
+		}
+	}
+	var start, end uint32
+	if symbol != "" {
+		if start, end, err = symbolRange(meta, symbol); err != nil {
+			return err
+		}
+	} else {
+		start = state.PC
+		if from != "" {
+			v, err := strconv.ParseUint(from, 0, 32)
+			if err != nil {
+				return fmt.Errorf("failed to parse start address: %w", err)
+			}
+			start = uint32(v)
+		}
+		end = start + count*4
+	}
+	return disasmRange(w, meta, start&^3, end, state.Memory.GetMemory)
+}
+
+func Disasm(ctx *cli.Context) error {
+	elfPath := ctx.Path(DisasmELFFlag.Name)
+	statePath := ctx.Path(DisasmStateFlag.Name)
+	if (elfPath == "") == (statePath == "") {
+		return errors.New("expected exactly one of --elf or --state")
+	}
+
+	out := io.Writer(os.Stdout)
+	if outPath := ctx.Path(DisasmOutFlag.Name); outPath != "" {
+		f, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to open output file: %w", err)
+		}
+		defer f.Close()
+		out = f
+	}
+	bw := bufio.NewWriter(out)
+
+	var err error
+	if elfPath != "" {
+		err = disasmELF(bw, elfPath, ctx.String(DisasmSymbolFlag.Name))
+	} else {
+		err = disasmState(bw, statePath, ctx.Path(DisasmMetaFlag.Name), ctx.String(DisasmSymbolFlag.Name),
+			ctx.String(DisasmFromFlag.Name), uint32(ctx.Uint(DisasmCountFlag.Name)))
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+var DisasmCommand = &cli.Command{
+	Name:        "disasm",
+	Usage:       "Disassemble MIPS instructions of an ELF file or VM state",
+	Description: "Disassemble MIPS instructions of an ELF file or VM state into a symbolized listing. Instructions the VM does not support are marked.",
+	Action:      Disasm,
+	Flags: []cli.Flag{
+		DisasmStateFlag,
+		DisasmELFFlag,
+		DisasmMetaFlag,
+		DisasmSymbolFlag,
+		DisasmFromFlag,
+		DisasmCountFlag,
+		DisasmOutFlag,
+	},
+}
@@ -2,8 +2,13 @@ package cmd
 
 import (
 	"debug/elf"
+	"encoding/hex"
 	"fmt"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/urfave/cli/v2"
 
 	"github.com/ethereum-optimism/cannon/mipsevm"
@@ -16,10 +21,14 @@ var (
 		TakesFile: true,
 		Required:  true,
 	}
+	LoadELFProfileFlag = &cli.StringFlag{
+		Name:  "profile",
+		Usage: "Loader profile: 'go' for Go programs, or 'libc' for statically linked C, Rust and TinyGo programs, with a brk-based heap and libc auxv entries on the stack.",
+		Value: "go",
+	}
 	LoadELFPatchFlag = &cli.StringSliceFlag{
 		Name:     "patch",
-		Usage:    "Type of patching to do",
-		Value:    cli.NewStringSlice("go", "stack"),
+		Usage:    "Type of patching to do: go, stack, brk. Defaults to go,stack for the go profile, and brk,stack for the libc profile.",
 		Required: false,
 	}
 	LoadELFOutFlag = &cli.PathFlag{
@@ -28,6 +37,42 @@ var (
 		Value:    "state.json",
 		Required: false,
 	}
+	LoadELFPatchSetFlag = &cli.StringSliceFlag{
+		Name:  "patch-set",
+		Usage: "Patch sets to apply with the go patch: names of built-in patch sets (go, prometheus), or paths to JSON/YAML patch set files.",
+		Value: cli.NewStringSlice(mipsevm.GoPatchSet.Name, mipsevm.PrometheusPatchSet.Name),
+	}
+	LoadELFCheckFlag = &cli.BoolFlag{
+		Name:  "check",
+		Usage: "Check the ELF file for instructions, syscalls and program headers the VM does not support, and refuse to load it if any errors are found.",
+	}
+	LoadELFArgvFlag = &cli.StringSliceFlag{
+		Name:  "argv",
+		Usage: "Program arguments to place on the initial stack, including the program name. Repeat the flag for each argument. Only used with the stack patch.",
+		Value: cli.NewStringSlice(mipsevm.DefaultStackConfig().Args...),
+	}
+	LoadELFEnvFlag = &cli.StringSliceFlag{
+		Name:  "env",
+		Usage: "Environment variables to place on the initial stack, formatted as KEY=VALUE. Only used with the stack patch.",
+	}
+	LoadELFAuxvFlag = &cli.StringSliceFlag{
+		Name:  "auxv",
+		Usage: "Additional auxiliary vector entries to place on the initial stack, formatted as TYPE=VALUE. Only used with the stack patch.",
+	}
+	LoadELFStackTopFlag = &cli.StringFlag{
+		Name:  "stack-top",
+		Usage: "Exclusive end address of the stack memory. Only used with the stack patch.",
+		Value: fmt.Sprintf("0x%08x", mipsevm.DefaultStackConfig().Top),
+	}
+	LoadELFStackSizeFlag = &cli.StringFlag{
+		Name:  "stack-size",
+		Usage: "Size of the stack memory in bytes, including the initial stack data. Only used with the stack patch.",
+		Value: fmt.Sprintf("0x%x", mipsevm.DefaultStackConfig().Size),
+	}
+	LoadELFRandomFlag = &cli.StringFlag{
+		Name:  "random",
+		Usage: "16 bytes, hex encoded, that AT_RANDOM points to. Defaults to a fixed value. Only used with the stack patch.",
+	}
 	LoadELFMetaFlag = &cli.PathFlag{
 		Name:     "meta",
 		Usage:    "Write metadata file, for symbol lookup during program execution. None if empty.",
@@ -36,6 +81,53 @@ var (
 	}
 )
 
+func parseUint32(v string) (uint32, error) {
+	n, err := strconv.ParseUint(v, 0, 32)
+	if err != nil {
+		return 0, err
+	}
+	return uint32(n), nil
+}
+
+func stackConfig(ctx *cli.Context) (*mipsevm.StackConfig, error) {
+	cfg := mipsevm.DefaultStackConfig()
+	cfg.Args = ctx.StringSlice(LoadELFArgvFlag.Name)
+	cfg.Env = ctx.StringSlice(LoadELFEnvFlag.Name)
+	for _, v := range ctx.StringSlice(LoadELFAuxvFlag.Name) {
+		typ, value, ok := strings.Cut(v, "=")
+		if !ok {
+			return nil, fmt.Errorf("expected auxv entry formatted as TYPE=VALUE, but got %q", v)
+		}
+		var entry mipsevm.AuxvEntry
+		var err error
+		if entry.Type, err = parseUint32(typ); err != nil {
+			return nil, fmt.Errorf("invalid auxv entry type %q: %w", typ, err)
+		}
+		if entry.Value, err = parseUint32(value); err != nil {
+			return nil, fmt.Errorf("invalid auxv entry value %q: %w", value, err)
+		}
+		cfg.Auxv = append(cfg.Auxv, entry)
+	}
+	var err error
+	if cfg.Top, err = parseUint32(ctx.String(LoadELFStackTopFlag.Name)); err != nil {
+		return nil, fmt.Errorf("invalid stack top: %w", err)
+	}
+	if cfg.Size, err = parseUint32(ctx.String(LoadELFStackSizeFlag.Name)); err != nil {
+		return nil, fmt.Errorf("invalid stack size: %w", err)
+	}
+	if v := ctx.String(LoadELFRandomFlag.Name); v != "" {
+		random, err := hex.DecodeString(strings.TrimPrefix(v, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid AT_RANDOM bytes: %w", err)
+		}
+		if len(random) != len(cfg.Random) {
+			return nil, fmt.Errorf("expected %d AT_RANDOM bytes, but got %d", len(cfg.Random), len(random))
+		}
+		copy(cfg.Random[:], random)
+	}
+	return cfg, nil
+}
+
 func LoadELF(ctx *cli.Context) error {
 	elfPath := ctx.Path(LoadELFPathFlag.Name)
 	elfProgram, err := elf.Open(elfPath)
@@ -45,16 +137,63 @@ func LoadELF(ctx *cli.Context) error {
 	if elfProgram.Machine != elf.EM_MIPS {
 		return fmt.Errorf("ELF is not big-endian MIPS R3000, but got %q", elfProgram.Machine.String())
 	}
-	state, err := mipsevm.LoadELF(elfProgram)
+	var checks []mipsevm.ELFCheck
+	if ctx.Bool(LoadELFCheckFlag.Name) {
+		checks = append(checks, mipsevm.CheckCompat)
+	}
+	state, err := mipsevm.LoadELF(elfProgram, checks...)
 	if err != nil {
 		return fmt.Errorf("failed to load ELF data into VM state: %w", err)
 	}
-	for _, typ := range ctx.StringSlice(LoadELFPatchFlag.Name) {
+	profile := ctx.String(LoadELFProfileFlag.Name)
+	var patchTypes []string
+	switch profile {
+	case "go":
+		patchTypes = []string{"go", "stack"}
+	case "libc":
+		patchTypes = []string{"brk", "stack"}
+	default:
+		return fmt.Errorf("unrecognized loader profile: %q", profile)
+	}
+	if ctx.IsSet(LoadELFPatchFlag.Name) {
+		patchTypes = ctx.StringSlice(LoadELFPatchFlag.Name)
+	}
+	l := Logger(os.Stderr, log.LvlInfo)
+	var patches []*mipsevm.PatchReport
+	for _, typ := range patchTypes {
 		switch typ {
 		case "stack":
-			err = mipsevm.PatchStack(state)
+			var cfg *mipsevm.StackConfig
+			if cfg, err = stackConfig(ctx); err != nil {
+				break
+			}
+			if profile == "libc" {
+				var auxv []mipsevm.AuxvEntry
+				if auxv, err = mipsevm.LibcAuxv(elfProgram); err != nil {
+					break
+				}
+				cfg.Auxv = append(auxv, cfg.Auxv...)
+			}
+			err = mipsevm.PatchStack(state, cfg)
+		case "brk":
+			err = mipsevm.PatchBrk(elfProgram, state)
 		case "go":
-			err = mipsevm.PatchGo(elfProgram, state)
+			for _, name := range ctx.StringSlice(LoadELFPatchSetFlag.Name) {
+				var ps *mipsevm.PatchSet
+				if ps, err = mipsevm.LoadPatchSet(name); err != nil {
+					break
+				}
+				var report *mipsevm.PatchReport
+				if report, err = mipsevm.ApplyPatchSet(elfProgram, state, ps); err != nil {
+					err = fmt.Errorf("patch set %s: %w", ps.Name, err)
+					break
+				}
+				l.Info("applied patch set", "name", ps.Name, "applied", len(report.Applied), "missing", len(report.Missing))
+				for _, sym := range report.Missing {
+					l.Warn("patch symbol not found", "patch_set", ps.Name, "symbol", sym)
+				}
+				patches = append(patches, report)
+			}
 		default:
 			return fmt.Errorf("unrecognized form of patching: %q", typ)
 		}
@@ -66,6 +205,7 @@ func LoadELF(ctx *cli.Context) error {
 	if err != nil {
 		return fmt.Errorf("failed to compute program metadata: %w", err)
 	}
+	meta.Patches = patches
 	if err := writeJSON[*mipsevm.Metadata](ctx.Path(LoadELFMetaFlag.Name), meta, false); err != nil {
 		return fmt.Errorf("failed to output metadata: %w", err)
 	}
@@ -79,7 +219,16 @@ var LoadELFCommand = &cli.Command{
 	Action:      LoadELF,
 	Flags: []cli.Flag{
 		LoadELFPathFlag,
+		LoadELFProfileFlag,
 		LoadELFPatchFlag,
+		LoadELFPatchSetFlag,
+		LoadELFCheckFlag,
+		LoadELFArgvFlag,
+		LoadELFEnvFlag,
+		LoadELFAuxvFlag,
+		LoadELFStackTopFlag,
+		LoadELFStackSizeFlag,
+		LoadELFRandomFlag,
 		LoadELFOutFlag,
 		LoadELFMetaFlag,
 	},
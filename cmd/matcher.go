@@ -13,6 +13,8 @@ type StepMatcher func(st *mipsevm.State) bool
 type StepMatcherFlag struct {
 	repr    string
 	matcher StepMatcher
+	// next returns the first matching step at or after the given step, or false if there is none
+	next func(step uint64) (uint64, bool)
 }
 
 func MustStepMatcherFlag(pattern string) *StepMatcherFlag {
@@ -29,10 +31,16 @@ func (m *StepMatcherFlag) Set(value string) error {
 		m.matcher = func(st *mipsevm.State) bool {
 			return false
 		}
+		m.next = func(step uint64) (uint64, bool) {
+			return 0, false
+		}
 	} else if value == "always" {
 		m.matcher = func(st *mipsevm.State) bool {
 			return true
 		}
+		m.next = func(step uint64) (uint64, bool) {
+			return step, true
+		}
 	} else if strings.HasPrefix(value, "=") {
 		when, err := strconv.ParseUint(value[1:], 0, 64)
 		if err != nil {
@@ -41,14 +49,27 @@ func (m *StepMatcherFlag) Set(value string) error {
 		m.matcher = func(st *mipsevm.State) bool {
 			return st.Step == when
 		}
+		m.next = func(step uint64) (uint64, bool) {
+			return when, step <= when
+		}
 	} else if strings.HasPrefix(value, "%") {
 		when, err := strconv.ParseUint(value[1:], 0, 64)
 		if err != nil {
 			return fmt.Errorf("failed to parse step interval number: %w", err)
 		}
+		if when == 0 {
+			return fmt.Errorf("step interval must not be zero")
+		}
 		m.matcher = func(st *mipsevm.State) bool {
 			return st.Step%when == 0
 		}
+		m.next = func(step uint64) (uint64, bool) {
+			if rem := step % when; rem != 0 {
+				next := step + (when - rem)
+				return next, next > step // false on overflow
+			}
+			return step, true
+		}
 	} else {
 		return fmt.Errorf("unrecognized step matcher: %q", value)
 	}
@@ -67,3 +88,11 @@ func (m *StepMatcherFlag) Matcher() StepMatcher {
 	}
 	return m.matcher
 }
+
+// NextMatch returns the first step at or after the given step that matches, or false if no step matches.
+func (m *StepMatcherFlag) NextMatch(step uint64) (uint64, bool) {
+	if m.next == nil { // Set(value) is not called for omitted inputs, default to never matching.
+		return 0, false
+	}
+	return m.next(step)
+}
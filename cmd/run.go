@@ -1,7 +1,10 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"time"
@@ -44,6 +47,18 @@ var (
 		Value:    "proof-%d.json",
 		Required: false,
 	}
+	RunBundleAtFlag = &cli.GenericFlag{
+		Name:     "bundle-at",
+		Usage:    "step pattern to output a self-contained proof bundle at, which can be checked with the verify command: " + patternHelp,
+		Value:    new(StepMatcherFlag),
+		Required: false,
+	}
+	RunBundleFmtFlag = &cli.StringFlag{
+		Name:     "bundle-fmt",
+		Usage:    "format for proof bundle output file names. Bundles are written to stdout if empty.",
+		Value:    "bundle-%d.json",
+		Required: false,
+	}
 	RunSnapshotAtFlag = &cli.GenericFlag{
 		Name:     "snapshot-at",
 		Usage:    "step pattern to output snapshots at: " + patternHelp,
@@ -56,6 +71,12 @@ var (
 		Value:    "state-%d.json",
 		Required: false,
 	}
+	RunSnapshotStoreFlag = &cli.PathFlag{
+		Name:      "snapshot-store",
+		Usage:     "directory of the content-addressed page store. If set, snapshots are written as manifests that reference the pages in the store, storing each page once. The input may be a full state, or a snapshot manifest of which the pages are loaded from the store on demand.",
+		TakesFile: true,
+		Required:  false,
+	}
 	RunStopAtFlag = &cli.GenericFlag{
 		Name:     "stop-at",
 		Usage:    "step pattern to stop at: " + patternHelp,
@@ -74,6 +95,34 @@ var (
 		Value:    MustStepMatcherFlag("%1000"),
 		Required: false,
 	}
+	RunPreimageServerFlag = &cli.StringFlag{
+		Name:     "preimage-server",
+		Usage:    "address of a long-lived pre-image server to connect to, 'unix://<path>' or 'tcp://<host>:<port>', instead of starting a pre-image server process.",
+		Required: false,
+	}
+	RunPreimageTimeoutFlag = &cli.DurationFlag{
+		Name:     "preimage-timeout",
+		Usage:    "timeout of each pre-image request and hint to the pre-image server. No timeout if zero.",
+		Value:    5 * time.Minute,
+		Required: false,
+	}
+	RunPreimageVerifyFlag = &cli.BoolFlag{
+		Name:     "preimage-verify",
+		Usage:    "verify every pre-image against its key before the VM reads it, and fail at the step that reads an invalid pre-image.",
+		Value:    true,
+		Required: false,
+	}
+	RunPreimageManifestFlag = &cli.PathFlag{
+		Name:      "preimage-manifest",
+		Usage:     "path of a JSON bootstrap manifest, declaring the pre-images of the local keys, to verify the local pre-images against. Local pre-images are not verified if empty.",
+		TakesFile: true,
+		Required:  false,
+	}
+	RunPreimageMaxSizeFlag = &cli.Uint64Flag{
+		Name:     "preimage-max-size",
+		Usage:    "maximum size of a verified pre-image in bytes. The VM limit if zero.",
+		Required: false,
+	}
 )
 
 type Proof struct {
@@ -82,8 +131,13 @@ type Proof struct {
 	Pre  common.Hash `json:"pre"`
 	Post common.Hash `json:"post"`
 
-	StepInput   hexutil.Bytes `json:"step-input"`
+	StepInput hexutil.Bytes `json:"step-input"`
+	// OracleInput is the single oracle call that prepares the pre-image part of the step, if the step reads a pre-image.
+	// It is always set, even if the call does not fit in a transaction.
 	OracleInput hexutil.Bytes `json:"oracle-input"`
+	// OracleCalls are the ordered oracle calls that prepare the pre-image part of the step instead,
+	// if the pre-image is too large to prepare with the single oracle input call.
+	OracleCalls []hexutil.Bytes `json:"oracle-calls,omitempty"`
 }
 
 type rawHint string
@@ -102,9 +156,21 @@ type ProcessPreimageOracle struct {
 	pCl *preimage.OracleClient
 	hCl *preimage.HintWriter
 	cmd *exec.Cmd
+	// address of the pre-image server to connect to, instead of starting a server process
+	addr string
+	// connections to the pre-image server at addr
+	conns []io.Closer
+	// server ends of the channels, which are closed once the server process has its own copies
+	serverChannels []preimage.FileChannel
+	// timeout of each pre-image request and hint, none if zero
+	timeout time.Duration
+
+	// closed when the server process exited, after which waitErr is set
+	exited  chan struct{}
+	waitErr error
 }
 
-func NewProcessPreimageOracle(name string, args []string) (*ProcessPreimageOracle, error) {
+func NewProcessPreimageOracle(name string, args []string, timeout time.Duration) (*ProcessPreimageOracle, error) {
 	if name == "" {
 		return &ProcessPreimageOracle{}, nil
 	}
@@ -128,72 +194,252 @@ func NewProcessPreimageOracle(name string, args []string) (*ProcessPreimageOracl
 		pOracleRW.Writer(),
 	}
 	out := &ProcessPreimageOracle{
-		pCl: preimage.NewOracleClient(pClientRW),
-		hCl: preimage.NewHintWriter(hClientRW),
-		cmd: cmd,
+		pCl:            preimage.NewOracleClient(pClientRW),
+		hCl:            preimage.NewHintWriter(hClientRW),
+		cmd:            cmd,
+		serverChannels: []preimage.FileChannel{hOracleRW, pOracleRW},
+		timeout:        timeout,
 	}
 	return out, nil
 }
 
+// NewNetPreimageOracle creates an oracle that connects to the long-lived pre-image server at the given address,
+// like "unix:///path/to/socket" or "tcp://127.0.0.1:1234", when started.
+func NewNetPreimageOracle(addr string, timeout time.Duration) (*ProcessPreimageOracle, error) {
+	if _, _, err := preimage.ParseNetAddr(addr); err != nil {
+		return nil, err
+	}
+	return &ProcessPreimageOracle{addr: addr, timeout: timeout}, nil
+}
+
+// newPreimageOracle creates an oracle that connects to the pre-image server at the address if not empty,
+// or that runs the pre-image server command otherwise.
+func newPreimageOracle(addr string, args []string, timeout time.Duration) (*ProcessPreimageOracle, error) {
+	if addr == "" {
+		return NewProcessPreimageOracle(args[0], args[1:], timeout)
+	}
+	if args[0] != "" {
+		return nil, errors.New("cannot both connect to a pre-image server and start a pre-image server process")
+	}
+	return NewNetPreimageOracle(addr, timeout)
+}
+
+// preimageServerError is the panic value of a ProcessPreimageOracle that failed to communicate with the pre-image server,
+// or of a VerifyingPreimageOracle that got an invalid pre-image,
+// since the VM has no way to return an error from the oracle. Guard recovers it into an error.
+type preimageServerError struct {
+	err error
+}
+
+func (e *preimageServerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *preimageServerError) Unwrap() error {
+	return e.err
+}
+
+// fail aborts the current step with the error, and the exit code of the server if it exited.
+func (p *ProcessPreimageOracle) fail(err error) {
+	if p.cmd != nil {
+		// the server closing the channels is usually how it exiting is noticed: give it a moment to be reaped
+		select {
+		case <-p.exited:
+			err = fmt.Errorf("pre-image server exited with code %d: %w", p.cmd.ProcessState.ExitCode(), err)
+		case <-time.After(time.Second):
+		}
+	}
+	panic(&preimageServerError{err: err})
+}
+
+func (p *ProcessPreimageOracle) context() (context.Context, context.CancelFunc) {
+	if p.timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), p.timeout)
+}
+
 func (p *ProcessPreimageOracle) Hint(v []byte) {
 	if p.hCl == nil { // no hint processor
 		return
 	}
-	p.hCl.Hint(rawHint(v))
+	ctx, cancel := p.context()
+	defer cancel()
+	status, err := p.hCl.HintStatusContext(ctx, rawHint(v))
+	if err != nil {
+		p.fail(fmt.Errorf("failed to send hint: %w", err))
+	}
+	// the program always gets HintOK in the VM (see the fdHintRead syscall in mipsevm),
+	// so fail fast here instead of on a missing pre-image later
+	if status != preimage.HintOK {
+		p.fail(fmt.Errorf("pre-image server could not process hint %q: %s", v, status))
+	}
 }
 
 func (p *ProcessPreimageOracle) GetPreimage(k [32]byte) []byte {
 	if p.pCl == nil {
 		panic("no pre-image retriever available")
 	}
-	return p.pCl.Get(rawKey(k))
+	ctx, cancel := p.context()
+	defer cancel()
+	dat, err := p.pCl.GetContext(ctx, rawKey(k))
+	if err != nil {
+		p.fail(fmt.Errorf("failed to get pre-image %x: %w", k, err))
+	}
+	return dat
 }
 
 func (p *ProcessPreimageOracle) Start() error {
+	if p.addr != "" {
+		ctx, cancel := p.context()
+		defer cancel()
+		hCh, pCh, err := preimage.DialChannels(ctx, p.addr)
+		if err != nil {
+			return err
+		}
+		p.hCl = preimage.NewHintWriter(hCh)
+		p.pCl = preimage.NewOracleClient(pCh)
+		p.conns = []io.Closer{hCh, pCh}
+		return nil
+	}
 	if p.cmd == nil {
 		return nil
 	}
-	return p.cmd.Start()
+	p.cmd.WaitDelay = time.Second * 10
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+	// close our copies of the server ends, so requests fail instead of blocking forever once the server exits
+	for _, ch := range p.serverChannels {
+		_ = ch.Close()
+	}
+	p.exited = make(chan struct{})
+	go func() {
+		p.waitErr = p.cmd.Wait()
+		close(p.exited)
+	}()
+	return nil
 }
 
 func (p *ProcessPreimageOracle) Close() error {
+	if p.conns != nil {
+		// the server ends the session when the connections are closed
+		return errors.Join(p.conns[0].Close(), p.conns[1].Close())
+	}
 	if p.cmd == nil {
 		return nil
 	}
 	_ = p.cmd.Process.Signal(os.Interrupt)
-	p.cmd.WaitDelay = time.Second * 10
-	return p.cmd.Wait()
+	<-p.exited
+	return p.waitErr
 }
 
-type StepFn func(proof bool) (*mipsevm.StepWitness, error)
-
-func Guard(proc *os.ProcessState, fn StepFn) StepFn {
-	return func(proof bool) (*mipsevm.StepWitness, error) {
-		wit, err := fn(proof)
-		if err != nil {
-			if proc.Exited() {
-				return nil, fmt.Errorf("pre-image server exited with code %d, resulting in err %v", proc.ExitCode(), err)
-			} else {
-				return nil, err
+// guard runs fn, and recovers a failure of the pre-image server, or of loading a memory page, during it into an error.
+func (p *ProcessPreimageOracle) guard(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			switch rerr := r.(type) {
+			case *preimageServerError:
+				err = rerr
+			case *mipsevm.PageLoadError:
+				err = rerr
+			default:
+				panic(r)
 			}
 		}
-		return wit, nil
+	}()
+	return fn()
+}
+
+type StepFn func(proof bool) (*mipsevm.StepWitness, error)
+
+// Guard wraps the step function, to return a failure of the pre-image server, or of loading a memory page,
+// during the step as an error.
+func Guard(po *ProcessPreimageOracle, fn StepFn) StepFn {
+	return func(proof bool) (wit *mipsevm.StepWitness, err error) {
+		err = po.guard(func() error {
+			wit, err = fn(proof)
+			return err
+		})
+		return wit, err
 	}
 }
 
 var _ mipsevm.PreimageOracle = (*ProcessPreimageOracle)(nil)
 
-func Run(ctx *cli.Context) error {
-	state, err := loadJSON[mipsevm.State](ctx.Path(RunInputFlag.Name))
-	if err != nil {
-		return err
+// VerifyingPreimageOracle checks every pre-image of the oracle before the VM reads it,
+// and aborts the step that reads an invalid pre-image. Guard recovers the failure into an error.
+type VerifyingPreimageOracle struct {
+	mipsevm.PreimageOracle
+	verifier *preimage.Verifier
+	// state of the VM, to report the step that reads the invalid pre-image
+	state *mipsevm.State
+}
+
+func NewVerifyingPreimageOracle(po mipsevm.PreimageOracle, verifier *preimage.Verifier, state *mipsevm.State) *VerifyingPreimageOracle {
+	return &VerifyingPreimageOracle{PreimageOracle: po, verifier: verifier, state: state}
+}
+
+func (p *VerifyingPreimageOracle) GetPreimage(k [32]byte) []byte {
+	dat := p.PreimageOracle.GetPreimage(k)
+	if err := p.verifier.Verify(k, dat); err != nil {
+		panic(&preimageServerError{err: fmt.Errorf("invalid pre-image at step %d: %w", p.state.Step, err)})
 	}
+	return dat
+}
 
-	l := Logger(os.Stderr, log.LvlInfo)
-	outLog := &mipsevm.LoggingWriter{Name: "program std-out", Log: l}
-	errLog := &mipsevm.LoggingWriter{Name: "program std-err", Log: l}
+var _ mipsevm.PreimageOracle = (*VerifyingPreimageOracle)(nil)
+
+// BootstrapManifest declares the pre-images of the local keys of a program, i.e. its bootstrap inputs.
+type BootstrapManifest struct {
+	Local map[uint64]hexutil.Bytes `json:"local"`
+}
 
-	// split CLI args after first '--'
+// newPreimageVerifier creates the pre-image verifier from the pre-image verification flags,
+// or returns nil if verification is disabled.
+func newPreimageVerifier(ctx *cli.Context) (*preimage.Verifier, error) {
+	if !ctx.Bool(RunPreimageVerifyFlag.Name) {
+		return nil, nil
+	}
+	v := &preimage.Verifier{MaxSize: ctx.Uint64(RunPreimageMaxSizeFlag.Name)}
+	if path := ctx.Path(RunPreimageManifestFlag.Name); path != "" {
+		manifest, err := loadJSON[BootstrapManifest](path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load bootstrap manifest: %w", err)
+		}
+		v.Local = make(map[preimage.LocalIndexKey][]byte, len(manifest.Local))
+		for k, dat := range manifest.Local {
+			v.Local[preimage.LocalIndexKey(k)] = dat
+		}
+	}
+	return v, nil
+}
+
+// verifyingOracle wraps the oracle to verify the pre-images that the VM reads, if the verifier is not nil.
+func verifyingOracle(po mipsevm.PreimageOracle, verifier *preimage.Verifier, state *mipsevm.State) mipsevm.PreimageOracle {
+	if verifier == nil {
+		return po
+	}
+	return NewVerifyingPreimageOracle(po, verifier, state)
+}
+
+// describeInsn formats the instruction at the given PC, for debugging purposes.
+func describeInsn(state *mipsevm.State, pc uint32, meta *mipsevm.Metadata) (desc string) {
+	defer func() {
+		// the instruction may be on the page that failed to load
+		if r := recover(); r != nil {
+			if _, ok := r.(*mipsevm.PageLoadError); !ok {
+				panic(r)
+			}
+			desc = fmt.Sprintf("unavailable instruction in %s", meta.LookupSymbol(pc))
+		}
+	}()
+	return fmt.Sprintf("%q in %s", mipsevm.Disassemble(pc, state.Memory.GetMemory(pc), meta), meta.LookupSymbol(pc))
+}
+
+// preimageServerArgs returns the pre-image server command and arguments, found after the first '--' CLI arg.
+// The command is empty if there are none.
+func preimageServerArgs(ctx *cli.Context) []string {
 	args := ctx.Args().Slice()
 	for i, arg := range args {
 		if arg == "--" {
@@ -204,10 +450,73 @@ func Run(ctx *cli.Context) error {
 	if len(args) == 0 {
 		args = []string{""}
 	}
+	return args
+}
+
+func loadMetadata(metaPath string, l log.Logger) (*mipsevm.Metadata, error) {
+	if metaPath == "" {
+		l.Info("no metadata file specified, defaulting to empty metadata")
+		return &mipsevm.Metadata{Symbols: nil}, nil // provide empty metadata by default
+	}
+	meta, err := loadJSON[mipsevm.Metadata](metaPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+	return meta, nil
+}
 
-	po, err := NewProcessPreimageOracle(args[0], args[1:])
+// proofStep executes a single step of the VM with proof generation, and returns the proof data and witness of the step.
+func proofStep(state *mipsevm.State, stepFn StepFn, l log.Logger) (*Proof, *mipsevm.StepWitness, error) {
+	step := state.Step
+	preStateHash := crypto.Keccak256Hash(state.EncodeWitness())
+	witness, err := stepFn(true)
 	if err != nil {
-		return fmt.Errorf("failed to create pre-image oracle process: %w", err)
+		return nil, nil, err
+	}
+	postStateHash := crypto.Keccak256Hash(state.EncodeWitness())
+	proof := &Proof{
+		Step:      step,
+		Pre:       preStateHash,
+		Post:      postStateHash,
+		StepInput: witness.EncodeStepInput(),
+	}
+	if witness.HasPreimage() {
+		input, err := witness.EncodePreimageOracleInput()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to encode pre-image oracle input: %w", err)
+		}
+		proof.OracleInput = input
+		calls, err := witness.PlanPreimageOracleInput(mipsevm.DefaultPreimageLoadLimits)
+		if err != nil {
+			// still usable with larger limits, or a contract that can load the pre-image in chunks
+			l.Warn("pre-image does not fit in a transaction, writing the single oracle input", "step", step, "err", err)
+		} else if len(calls) > 1 {
+			for _, call := range calls {
+				proof.OracleCalls = append(proof.OracleCalls, call)
+			}
+		}
+	}
+	return proof, witness, nil
+}
+
+func Run(ctx *cli.Context) error {
+	store, err := openPageStore(ctx.Path(RunSnapshotStoreFlag.Name))
+	if err != nil {
+		return err
+	}
+	state, err := loadState(ctx.Path(RunInputFlag.Name), store)
+	if err != nil {
+		return err
+	}
+
+	l := Logger(os.Stderr, log.LvlInfo)
+	outLog := &mipsevm.LoggingWriter{Name: "program std-out", Log: l}
+	errLog := &mipsevm.LoggingWriter{Name: "program std-err", Log: l}
+
+	args := preimageServerArgs(ctx)
+	po, err := newPreimageOracle(ctx.String(RunPreimageServerFlag.Name), args, ctx.Duration(RunPreimageTimeoutFlag.Name))
+	if err != nil {
+		return fmt.Errorf("failed to create pre-image oracle: %w", err)
 	}
 	if err := po.Start(); err != nil {
 		return fmt.Errorf("failed to start pre-image oracle server: %w", err)
@@ -218,41 +527,91 @@ func Run(ctx *cli.Context) error {
 		}
 	}()
 
-	stopAt := ctx.Generic(RunStopAtFlag.Name).(*StepMatcherFlag).Matcher()
-	proofAt := ctx.Generic(RunProofAtFlag.Name).(*StepMatcherFlag).Matcher()
-	snapshotAt := ctx.Generic(RunSnapshotAtFlag.Name).(*StepMatcherFlag).Matcher()
-	infoAt := ctx.Generic(RunInfoAtFlag.Name).(*StepMatcherFlag).Matcher()
-
-	var meta *mipsevm.Metadata
-	if metaPath := ctx.Path(RunMetaFlag.Name); metaPath == "" {
-		l.Info("no metadata file specified, defaulting to empty metadata")
-		meta = &mipsevm.Metadata{Symbols: nil} // provide empty metadata by default
-	} else {
-		if m, err := loadJSON[mipsevm.Metadata](metaPath); err != nil {
-			return fmt.Errorf("failed to load metadata: %w", err)
-		} else {
-			meta = m
+	stopAtFlag := ctx.Generic(RunStopAtFlag.Name).(*StepMatcherFlag)
+	proofAtFlag := ctx.Generic(RunProofAtFlag.Name).(*StepMatcherFlag)
+	bundleAtFlag := ctx.Generic(RunBundleAtFlag.Name).(*StepMatcherFlag)
+	snapshotAtFlag := ctx.Generic(RunSnapshotAtFlag.Name).(*StepMatcherFlag)
+	infoAtFlag := ctx.Generic(RunInfoAtFlag.Name).(*StepMatcherFlag)
+	stopAt := stopAtFlag.Matcher()
+	proofAt := proofAtFlag.Matcher()
+	bundleAt := bundleAtFlag.Matcher()
+	snapshotAt := snapshotAtFlag.Matcher()
+	infoAt := infoAtFlag.Matcher()
+	// nextEvent returns the next step at or after the given step that any of the matchers match
+	nextEvent := func(step uint64) uint64 {
+		out := ^uint64(0)
+		for _, m := range []*StepMatcherFlag{stopAtFlag, proofAtFlag, bundleAtFlag, snapshotAtFlag, infoAtFlag} {
+			if next, ok := m.NextMatch(step); ok && next < out {
+				out = next
+			}
 		}
+		return out
 	}
 
-	us := mipsevm.NewInstrumentedState(state, po, outLog, errLog)
+	meta, err := loadMetadata(ctx.Path(RunMetaFlag.Name), l)
+	if err != nil {
+		return err
+	}
+	verifier, err := newPreimageVerifier(ctx)
+	if err != nil {
+		return err
+	}
+
+	us := mipsevm.NewInstrumentedState(state, verifyingOracle(po, verifier, state), outLog, errLog)
 	proofFmt := ctx.String(RunProofFmtFlag.Name)
+	bundleFmt := ctx.String(RunBundleFmtFlag.Name)
 	snapshotFmt := ctx.String(RunSnapshotFmtFlag.Name)
 
-	stepFn := us.Step
-	if po.cmd != nil {
-		stepFn = Guard(po.cmd.ProcessState, stepFn)
+	stepFn := Guard(po, us.Step)
+	// the input may be a snapshot of a run: the pre-image server did not see the hints before it
+	if err := po.guard(func() error {
+		us.ReplayHint()
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to replay hint of input state: %w", err)
+	}
+
+	// don't loop forever when we get stuck because of an unexpected bad program
+	var stuck mipsevm.StopCondition
+	if start, end, err := symbolRange(meta, "runtime.notesleep"); err == nil {
+		stuck = mipsevm.StopAtPC(start, end)
 	}
 
 	for !state.Exited {
 		step := state.Step
+		pc := state.PC
+
+		// fast-forward to the next step that any of the matchers is interested in
+		if next := nextEvent(step); next > step {
+			var reason mipsevm.StopReason
+			err := po.guard(func() (err error) {
+				_, reason, err = us.RunUntil(next-step, stuck)
+				return err
+			})
+			if err != nil {
+				step, pc = state.Step, state.PC
+				return fmt.Errorf("failed at step %d (PC: %08x, insn: %s): %w", step, pc, describeInsn(state, pc, meta), err)
+			}
+			if reason == mipsevm.StoppedCondition {
+				return fmt.Errorf("got stuck in Go sleep at step %d", state.Step)
+			}
+			continue
+		}
 
 		name := meta.LookupSymbol(state.PC)
 		if infoAt(state) {
+			var insn uint32
+			if err := po.guard(func() error {
+				insn = state.Memory.GetMemory(state.PC)
+				return nil
+			}); err != nil {
+				return fmt.Errorf("failed at step %d (PC: %08x): %w", step, pc, err)
+			}
 			l.Info("processing",
 				"step", step,
 				"pc", mipsevm.HexU32(state.PC),
-				"insn", mipsevm.HexU32(state.Memory.GetMemory(state.PC)),
+				"insn", mipsevm.HexU32(insn),
+				"disasm", mipsevm.Disassemble(state.PC, insn, meta),
 				"name", name,
 			)
 		}
@@ -265,37 +624,35 @@ func Run(ctx *cli.Context) error {
 		}
 
 		if snapshotAt(state) {
-			if err := writeJSON[*mipsevm.State](fmt.Sprintf(snapshotFmt, step), state, false); err != nil {
+			if err := writeSnapshot(fmt.Sprintf(snapshotFmt, step), state, store); err != nil {
 				return fmt.Errorf("failed to write state snapshot: %w", err)
 			}
 		}
 
-		if proofAt(state) {
-			preStateHash := crypto.Keccak256Hash(state.EncodeWitness())
-			witness, err := stepFn(true)
+		if proofAt(state) || bundleAt(state) {
+			writeProof, writeBundle := proofAt(state), bundleAt(state)
+			proof, witness, err := proofStep(state, stepFn, l)
 			if err != nil {
-				return fmt.Errorf("failed at proof-gen step %d (PC: %08x): %w", step, state.PC, err)
+				return fmt.Errorf("failed at proof-gen step %d (PC: %08x, insn: %s): %w", step, pc, describeInsn(state, pc, meta), err)
 			}
-			postStateHash := crypto.Keccak256Hash(state.EncodeWitness())
-			proof := &Proof{
-				Step:      step,
-				Pre:       preStateHash,
-				Post:      postStateHash,
-				StepInput: witness.EncodeStepInput(),
+			if writeProof {
+				if err := writeJSON[*Proof](fmt.Sprintf(proofFmt, step), proof, true); err != nil {
+					return fmt.Errorf("failed to write proof data: %w", err)
+				}
 			}
-			if witness.HasPreimage() {
-				proof.OracleInput, err = witness.EncodePreimageOracleInput()
+			if writeBundle {
+				bundle, err := mipsevm.NewStepBundle(witness, state, meta)
 				if err != nil {
-					return fmt.Errorf("failed to encode pre-image oracle input: %w", err)
+					return fmt.Errorf("failed to bundle proof of step %d: %w", step, err)
+				}
+				if err := writeJSON[*mipsevm.StepBundle](fmt.Sprintf(bundleFmt, step), bundle, true); err != nil {
+					return fmt.Errorf("failed to write proof bundle: %w", err)
 				}
-			}
-			if err := writeJSON[*Proof](fmt.Sprintf(proofFmt, step), proof, true); err != nil {
-				return fmt.Errorf("failed to write proof data: %w", err)
 			}
 		} else {
 			_, err = stepFn(false)
 			if err != nil {
-				return fmt.Errorf("failed at step %d (PC: %08x): %w", step, state.PC, err)
+				return fmt.Errorf("failed at step %d (PC: %08x, insn: %s): %w", step, pc, describeInsn(state, pc, meta), err)
 			}
 		}
 	}
@@ -316,10 +673,18 @@ var RunCommand = &cli.Command{
 		RunOutputFlag,
 		RunProofAtFlag,
 		RunProofFmtFlag,
+		RunBundleAtFlag,
+		RunBundleFmtFlag,
 		RunSnapshotAtFlag,
 		RunSnapshotFmtFlag,
+		RunSnapshotStoreFlag,
 		RunStopAtFlag,
 		RunMetaFlag,
 		RunInfoAtFlag,
+		RunPreimageServerFlag,
+		RunPreimageTimeoutFlag,
+		RunPreimageVerifyFlag,
+		RunPreimageManifestFlag,
+		RunPreimageMaxSizeFlag,
 	},
 }
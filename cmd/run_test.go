@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+func TestProofStepLargePreimage(t *testing.T) {
+	data := make([]byte, mipsevm.DefaultPreimageLoadLimits.MaxCalldata)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	proof := func(key [32]byte) (*Proof, []string) {
+		var warnings []string
+		l := log.New()
+		l.SetHandler(log.FuncHandler(func(r *log.Record) error {
+			if r.Lvl == log.LvlWarn {
+				warnings = append(warnings, r.Msg)
+			}
+			return nil
+		}))
+		state := &mipsevm.State{Memory: mipsevm.NewMemory()}
+		stepFn := func(proof bool) (*mipsevm.StepWitness, error) {
+			return &mipsevm.StepWitness{
+				PreimageKey:    key,
+				PreimageValue:  append(binary.BigEndian.AppendUint64(nil, uint64(len(data))), data...),
+				PreimageOffset: 8,
+			}, nil
+		}
+		p, _, err := proofStep(state, stepFn, l)
+		require.NoError(t, err)
+		return p, warnings
+	}
+
+	t.Run("keccak256", func(t *testing.T) {
+		p, warnings := proof(preimage.Keccak256Key(crypto.Keccak256Hash(data)).PreimageKey())
+		require.Empty(t, warnings)
+		require.Greater(t, len(p.OracleCalls), 2, "loaded in chunks")
+		require.NotEmpty(t, p.OracleInput, "single call is still written")
+	})
+
+	t.Run("sha256", func(t *testing.T) {
+		p, warnings := proof(preimage.Sha256Key(preimage.Sha256(data)).PreimageKey())
+		require.Len(t, warnings, 1, "cannot be loaded in chunks")
+		require.Empty(t, p.OracleCalls)
+		res, err := mipsevm.DecodePreimageOracleInput(p.OracleInput)
+		require.NoError(t, err)
+		require.Equal(t, "loadSha256PreimagePart", res.Method)
+	})
+}
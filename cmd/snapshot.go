@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+)
+
+var (
+	SnapshotStoreFlag = &cli.PathFlag{
+		Name:      "store",
+		Usage:     "directory of the content-addressed page store that the snapshot manifests reference.",
+		TakesFile: true,
+		Required:  true,
+	}
+	SnapshotDiffAFlag = &cli.PathFlag{
+		Name:      "a",
+		Usage:     "path of the first snapshot manifest.",
+		TakesFile: true,
+		Required:  true,
+	}
+	SnapshotDiffBFlag = &cli.PathFlag{
+		Name:      "b",
+		Usage:     "path of the second snapshot manifest.",
+		TakesFile: true,
+		Required:  true,
+	}
+	SnapshotExportInputFlag = &cli.PathFlag{
+		Name:      "input",
+		Usage:     "path of the snapshot manifest.",
+		TakesFile: true,
+		Required:  true,
+	}
+	SnapshotExportOutputFlag = &cli.PathFlag{
+		Name:      "output",
+		Usage:     "path of output JSON state. Stdout if left empty.",
+		TakesFile: true,
+		Value:     "out.json",
+	}
+	SnapshotImportInputFlag = &cli.PathFlag{
+		Name:      "input",
+		Usage:     "path of input JSON state.",
+		TakesFile: true,
+		Required:  true,
+	}
+	SnapshotImportOutputFlag = &cli.PathFlag{
+		Name:      "output",
+		Usage:     "path of output snapshot manifest.",
+		TakesFile: true,
+		Required:  true,
+	}
+)
+
+// openPageStore opens the page store in the given directory, or returns nil if the directory is empty.
+func openPageStore(dir string) (mipsevm.PageStore, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	store, err := mipsevm.NewDirPageStore(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open page store: %w", err)
+	}
+	return store, nil
+}
+
+// loadState loads a JSON state, or a snapshot manifest, of which the pages are loaded from the store on first access.
+// The two are told apart by their memory pages: a state has page data, and a manifest has page roots.
+func loadState(path string, store mipsevm.PageStore) (*mipsevm.State, error) {
+	format, err := loadJSON[struct {
+		Memory []struct {
+			Root json.RawMessage `json:"root"`
+		} `json:"memory"`
+	}](path)
+	if err != nil {
+		return nil, err
+	}
+	if len(format.Memory) == 0 || format.Memory[0].Root == nil {
+		return loadJSON[mipsevm.State](path)
+	}
+	if store == nil {
+		return nil, fmt.Errorf("%q is a snapshot manifest, but there is no page store to load its pages from", path)
+	}
+	snap, err := loadJSON[mipsevm.Snapshot](path)
+	if err != nil {
+		return nil, err
+	}
+	state, err := snap.LoadLazy(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load snapshot %q: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeSnapshot writes a JSON state, or a snapshot manifest with pages into the store if the store is not nil.
+func writeSnapshot(path string, state *mipsevm.State, store mipsevm.PageStore) error {
+	if store == nil {
+		return writeJSON[*mipsevm.State](path, state, false)
+	}
+	snap, err := mipsevm.NewSnapshot(state, store)
+	if err != nil {
+		return fmt.Errorf("failed to store snapshot pages: %w", err)
+	}
+	return writeJSON[*mipsevm.Snapshot](path, snap, false)
+}
+
+func SnapshotDiff(ctx *cli.Context) error {
+	a, err := loadJSON[mipsevm.Snapshot](ctx.Path(SnapshotDiffAFlag.Name))
+	if err != nil {
+		return err
+	}
+	b, err := loadJSON[mipsevm.Snapshot](ctx.Path(SnapshotDiffBFlag.Name))
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "step: %d -> %d\n", a.Step, b.Step)
+	_, _ = fmt.Fprintf(os.Stdout, "pc: %08x -> %08x\n", a.PC, b.PC)
+	for i := range a.Registers {
+		if a.Registers[i] != b.Registers[i] {
+			_, _ = fmt.Fprintf(os.Stdout, "r%d: %08x -> %08x\n", i, a.Registers[i], b.Registers[i])
+		}
+	}
+	diff := mipsevm.DiffSnapshots(a, b)
+	for _, d := range diff {
+		addr := d.Index << mipsevm.PageAddrSize
+		switch {
+		case d.From == (common.Hash{}):
+			_, _ = fmt.Fprintf(os.Stdout, "page %08x: allocated %s\n", addr, d.To)
+		case d.To == (common.Hash{}):
+			_, _ = fmt.Fprintf(os.Stdout, "page %08x: removed %s\n", addr, d.From)
+		default:
+			_, _ = fmt.Fprintf(os.Stdout, "page %08x: %s -> %s\n", addr, d.From, d.To)
+		}
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "%d of %d pages differ\n", len(diff), len(b.Memory))
+	return nil
+}
+
+func SnapshotExport(ctx *cli.Context) error {
+	store, err := openPageStore(ctx.Path(SnapshotStoreFlag.Name))
+	if err != nil {
+		return err
+	}
+	state, err := loadState(ctx.Path(SnapshotExportInputFlag.Name), store)
+	if err != nil {
+		return err
+	}
+	return writeJSON[*mipsevm.State](ctx.Path(SnapshotExportOutputFlag.Name), state, true)
+}
+
+func SnapshotImport(ctx *cli.Context) error {
+	store, err := openPageStore(ctx.Path(SnapshotStoreFlag.Name))
+	if err != nil {
+		return err
+	}
+	state, err := loadJSON[mipsevm.State](ctx.Path(SnapshotImportInputFlag.Name))
+	if err != nil {
+		return err
+	}
+	return writeSnapshot(ctx.Path(SnapshotImportOutputFlag.Name), state, store)
+}
+
+var SnapshotCommand = &cli.Command{
+	Name:        "snapshot",
+	Usage:       "Inspect snapshot manifests of a page store",
+	Description: "Inspect snapshot manifests, as written by 'run --snapshot-store', which reference pages in a content-addressed page store.",
+	Subcommands: []*cli.Command{
+		{
+			Name:        "diff",
+			Usage:       "Show the differences between two snapshot manifests",
+			Description: "Show the differences in step, PC, registers and memory pages between two snapshot manifests. The pages themselves are not read.",
+			Action:      SnapshotDiff,
+			Flags: []cli.Flag{
+				SnapshotDiffAFlag,
+				SnapshotDiffBFlag,
+			},
+		},
+		{
+			Name:        "export",
+			Usage:       "Convert a snapshot manifest into a JSON state",
+			Description: "Convert a snapshot manifest into a JSON state, with the memory pages loaded from the page store.",
+			Action:      SnapshotExport,
+			Flags: []cli.Flag{
+				SnapshotStoreFlag,
+				SnapshotExportInputFlag,
+				SnapshotExportOutputFlag,
+			},
+		},
+		{
+			Name:        "import",
+			Usage:       "Convert a JSON state into a snapshot manifest",
+			Description: "Convert a JSON state into a snapshot manifest, storing the memory pages in the page store. The snapshot can then be run with pages loaded on demand.",
+			Action:      SnapshotImport,
+			Flags: []cli.Flag{
+				SnapshotStoreFlag,
+				SnapshotImportInputFlag,
+				SnapshotImportOutputFlag,
+			},
+		},
+	},
+}
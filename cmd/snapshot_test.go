@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+)
+
+func TestRunSnapshotInput(t *testing.T) {
+	dir := t.TempDir()
+	store := filepath.Join(dir, "store")
+	state := &mipsevm.State{PC: 0x1000, NextPC: 0x1004, Memory: mipsevm.NewMemory()}
+	for i, insn := range []uint32{
+		0x24100007, // li $s0, 7
+		0x24021096, // li $v0, 4246 (exit_group)
+		0x02002025, // move $a0, $s0
+		0x0000000c, // syscall
+	} {
+		state.Memory.SetMemory(0x1000+uint32(i)*4, insn)
+	}
+	state.Memory.SetMemory(0x8000_0000, 0x1234) // a page that the program does not access
+	input := filepath.Join(dir, "state.json")
+	require.NoError(t, writeJSON[*mipsevm.State](input, state, false))
+
+	run := func(input string, out string, snapshotAt string) error {
+		return runCommand(t, "run",
+			"--input", input,
+			"--output", out,
+			"--snapshot-at", snapshotAt,
+			"--snapshot-fmt", filepath.Join(dir, "snap-%d.json"),
+			"--snapshot-store", store,
+			"--meta", "")
+	}
+
+	// a full state, with snapshots written into the store
+	full := filepath.Join(dir, "out-full.json")
+	require.NoError(t, run(input, full, "=2"))
+	fullOut, err := loadJSON[mipsevm.State](full)
+	require.NoError(t, err)
+	require.True(t, fullOut.Exited)
+	require.Equal(t, uint8(7), fullOut.ExitCode)
+
+	// a snapshot manifest, of which the pages are loaded from the store
+	manifest := filepath.Join(dir, "snap-2.json")
+	snap, err := loadJSON[mipsevm.Snapshot](manifest)
+	require.NoError(t, err)
+	require.Len(t, snap.Memory, 2)
+	snapOut := filepath.Join(dir, "out-snap.json")
+	require.NoError(t, run(manifest, snapOut, "never"))
+	out, err := loadJSON[mipsevm.State](snapOut)
+	require.NoError(t, err)
+	require.Equal(t, fullOut.EncodeWitness(), out.EncodeWitness())
+
+	// a manifest cannot be loaded without its pages
+	require.ErrorContains(t, runCommand(t, "run", "--input", manifest, "--output", "", "--meta", ""), "no page store")
+
+	// a page that is corrupted after the manifest is loaded fails the run, instead of crashing it
+	corrupt := make([]byte, mipsevm.PageSize)
+	corrupt[0] = 1
+	require.NoError(t, filepath.WalkDir(store, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			err = os.WriteFile(path, corrupt, 0644)
+		}
+		return err
+	}))
+	var perr *mipsevm.PageLoadError
+	require.ErrorAs(t, run(manifest, "", "never"), &perr)
+	require.ErrorContains(t, perr, "loaded page has root")
+
+	require.NoError(t, os.RemoveAll(store))
+	require.ErrorIs(t, run(manifest, "", "never"), mipsevm.ErrPageNotFound)
+}
@@ -0,0 +1,388 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+var (
+	TraceSnapshotsFlag = &cli.StringSliceFlag{
+		Name:     "snapshots",
+		Usage:    "Paths of JSON state snapshots, e.g. from 'run --snapshot-at'. Each pair of consecutive snapshots, ordered by step, forms a trace segment. Glob patterns are expanded.",
+		Required: true,
+	}
+	TraceSnapshotStoreFlag = &cli.PathFlag{
+		Name:      "snapshot-store",
+		Usage:     "directory of the content-addressed page store, to load the pages of snapshot manifests from. Snapshots may be full states or manifests.",
+		TakesFile: true,
+	}
+	TraceWorkersFlag = &cli.IntFlag{
+		Name:  "workers",
+		Usage: "Number of segments to trace in parallel. Each worker runs its own pre-image server.",
+		Value: runtime.NumCPU(),
+	}
+	TraceAtFlag = &cli.GenericFlag{
+		Name:  "trace-at",
+		Usage: "step pattern to include the state hash in the trace at: " + patternHelp,
+		Value: MustStepMatcherFlag("always"),
+	}
+	TraceFmtFlag = &cli.StringFlag{
+		Name:  "trace-fmt",
+		Usage: "format for segment trace output file names, formatted with the start step of the segment.",
+		Value: "trace-%d.json",
+	}
+	TraceProofAtFlag = &cli.GenericFlag{
+		Name:  "proof-at",
+		Usage: "step pattern to output proof at: " + patternHelp,
+		Value: new(StepMatcherFlag),
+	}
+	TraceProofFmtFlag = &cli.StringFlag{
+		Name:  "proof-fmt",
+		Usage: "format for proof data output file names.",
+		Value: "proof-%d.json",
+	}
+	TracePreimageServerFlag = &cli.StringFlag{
+		Name:  "preimage-server",
+		Usage: "address of a long-lived pre-image server to connect to, 'unix://<path>' or 'tcp://<host>:<port>', instead of starting a pre-image server process per worker. Each worker opens its own session.",
+	}
+	TracePreimageTimeoutFlag = &cli.DurationFlag{
+		Name:  "preimage-timeout",
+		Usage: "timeout of each pre-image request and hint to the pre-image servers. No timeout if zero.",
+		Value: 5 * time.Minute,
+	}
+	TracePreimageVerifyFlag = &cli.BoolFlag{
+		Name:  "preimage-verify",
+		Usage: "verify every pre-image against its key before the VM reads it, and fail at the step that reads an invalid pre-image.",
+		Value: true,
+	}
+	TracePreimageManifestFlag = &cli.PathFlag{
+		Name:      "preimage-manifest",
+		Usage:     "path of a JSON bootstrap manifest, declaring the pre-images of the local keys, to verify the local pre-images against. Local pre-images are not verified if empty.",
+		TakesFile: true,
+	}
+	TracePreimageMaxSizeFlag = &cli.Uint64Flag{
+		Name:  "preimage-max-size",
+		Usage: "maximum size of a verified pre-image in bytes. The VM limit if zero.",
+	}
+	TraceMetaFlag = &cli.PathFlag{
+		Name:  "meta",
+		Usage: "path to metadata file for symbol lookup, to detect programs that got stuck. None if empty.",
+		Value: "meta.json",
+	}
+)
+
+// TraceEntry is the state hash after the given number of steps.
+type TraceEntry struct {
+	Step uint64      `json:"step"`
+	Hash common.Hash `json:"hash"`
+}
+
+// Segment is the trace of the execution between two snapshots.
+type Segment struct {
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+
+	Pre  common.Hash `json:"pre"`
+	Post common.Hash `json:"post"`
+
+	Trace []TraceEntry `json:"trace"`
+}
+
+type snapshot struct {
+	path string
+	step uint64
+}
+
+// listSnapshots expands the snapshot paths, and orders the snapshots by step.
+func listSnapshots(patterns []string) ([]snapshot, error) {
+	var out []snapshot
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(paths) == 0 { // not a pattern, or no matches: fail on loading the path instead
+			paths = []string{pattern}
+		}
+		for _, p := range paths {
+			// decode just the step, without building the memory
+			header, err := loadJSON[struct {
+				Step uint64 `json:"step"`
+			}](p)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, snapshot{path: p, step: header.Step})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].step < out[j].step
+	})
+	for i := 1; i < len(out); i++ {
+		if out[i].step == out[i-1].step {
+			return nil, fmt.Errorf("snapshots %q and %q are both at step %d", out[i-1].path, out[i].path, out[i].step)
+		}
+	}
+	if len(out) < 2 {
+		return nil, fmt.Errorf("need at least 2 snapshots to trace a segment, but got %d", len(out))
+	}
+	return out, nil
+}
+
+// errTraceStopped is returned when a segment is not traced to the end, because another segment failed.
+var errTraceStopped = errors.New("stopped, another segment failed")
+
+type tracer struct {
+	log   log.Logger
+	meta  *mipsevm.Metadata
+	store mipsevm.PageStore
+
+	traceAt    *StepMatcherFlag
+	proofAt    *StepMatcherFlag
+	proofFmt   string
+	serverArgs []string
+	serverAddr string
+	timeout    time.Duration
+	verifier   *preimage.Verifier
+
+	// set when any worker fails, to stop the other workers early
+	failed atomic.Bool
+}
+
+// traceSegment runs the VM from the start snapshot up to the step of the end snapshot.
+func (t *tracer) traceSegment(po *ProcessPreimageOracle, start snapshot, end uint64) (*Segment, error) {
+	state, err := loadState(start.path, t.store)
+	if err != nil {
+		return nil, err
+	}
+	if state.Step != start.step {
+		return nil, fmt.Errorf("snapshot %q is at step %d, expected step %d", start.path, state.Step, start.step)
+	}
+	l := t.log.New("segment", start.step)
+	outLog := &mipsevm.LoggingWriter{Name: "program std-out", Log: l}
+	errLog := &mipsevm.LoggingWriter{Name: "program std-err", Log: l}
+	us := mipsevm.NewInstrumentedState(state, verifyingOracle(po, t.verifier, state), outLog, errLog)
+	stepFn := Guard(po, us.Step)
+	// the pre-image server of the worker did not see the hints before the snapshot
+	if err := po.guard(func() error {
+		us.ReplayHint()
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to replay hint of snapshot: %w", err)
+	}
+
+	// don't loop forever when we get stuck because of an unexpected bad program
+	var stuck mipsevm.StopCondition
+	if pcStart, pcEnd, err := symbolRange(t.meta, "runtime.notesleep"); err == nil {
+		stuck = mipsevm.StopAtPC(pcStart, pcEnd)
+	}
+	// stop early if another worker failed
+	cond := func(st *mipsevm.State) bool {
+		return t.failed.Load() || (stuck != nil && stuck(st))
+	}
+
+	traceAt := t.traceAt.Matcher()
+	proofAt := t.proofAt.Matcher()
+	seg := &Segment{
+		Start: start.step,
+		End:   end,
+		Pre:   crypto.Keccak256Hash(state.EncodeWitness()),
+	}
+	for state.Step < end {
+		step, pc := state.Step, state.PC
+		if state.Exited {
+			return nil, fmt.Errorf("program exited at step %d, before the end of the segment", step)
+		}
+		if t.failed.Load() {
+			return nil, errTraceStopped
+		}
+		if stuck != nil && stuck(state) {
+			return nil, fmt.Errorf("got stuck in Go sleep at step %d", step)
+		}
+		if traceAt(state) {
+			seg.Trace = append(seg.Trace, TraceEntry{Step: step, Hash: crypto.Keccak256Hash(state.EncodeWitness())})
+		}
+		if proofAt(state) {
+			proof, _, err := proofStep(state, stepFn, l)
+			if err != nil {
+				return nil, fmt.Errorf("failed at proof-gen step %d (PC: %08x, insn: %s): %w", step, pc, describeInsn(state, pc, t.meta), err)
+			}
+			if err := writeJSON[*Proof](fmt.Sprintf(t.proofFmt, step), proof, false); err != nil {
+				return nil, fmt.Errorf("failed to write proof data: %w", err)
+			}
+			continue
+		}
+		// fast-forward to the next step that is traced or proven, or the end of the segment
+		next := end
+		for _, m := range []*StepMatcherFlag{t.traceAt, t.proofAt} {
+			if n, ok := m.NextMatch(step + 1); ok && n < next {
+				next = n
+			}
+		}
+		if err := po.guard(func() error {
+			_, _, err := us.RunUntil(next-step, cond)
+			return err
+		}); err != nil {
+			step, pc = state.Step, state.PC
+			return nil, fmt.Errorf("failed at step %d (PC: %08x, insn: %s): %w", step, pc, describeInsn(state, pc, t.meta), err)
+		}
+	}
+	seg.Post = crypto.Keccak256Hash(state.EncodeWitness())
+	return seg, nil
+}
+
+func (t *tracer) worker(snapshots []snapshot, work <-chan int, results []*Segment) error {
+	po, err := newPreimageOracle(t.serverAddr, t.serverArgs, t.timeout)
+	if err != nil {
+		return fmt.Errorf("failed to create pre-image oracle: %w", err)
+	}
+	if err := po.Start(); err != nil {
+		return fmt.Errorf("failed to start pre-image oracle server: %w", err)
+	}
+	defer func() {
+		if err := po.Close(); err != nil {
+			t.log.Error("failed to close pre-image server", "err", err)
+		}
+	}()
+	for i := range work {
+		if t.failed.Load() {
+			return nil
+		}
+		start, end := snapshots[i], snapshots[i+1].step
+		t.log.Info("tracing segment", "start", start.step, "end", end)
+		begin := time.Now()
+		seg, err := t.traceSegment(po, start, end)
+		if errors.Is(err, errTraceStopped) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("segment %d-%d: %w", start.step, end, err)
+		}
+		t.log.Info("traced segment", "start", start.step, "end", end, "duration", time.Since(begin))
+		results[i] = seg
+	}
+	return nil
+}
+
+func Trace(ctx *cli.Context) error {
+	l := Logger(os.Stderr, log.LvlInfo)
+	snapshots, err := listSnapshots(ctx.StringSlice(TraceSnapshotsFlag.Name))
+	if err != nil {
+		return fmt.Errorf("failed to list snapshots: %w", err)
+	}
+	meta := &mipsevm.Metadata{Symbols: nil}
+	if metaPath := ctx.Path(TraceMetaFlag.Name); metaPath != "" {
+		if meta, err = loadMetadata(metaPath, l); err != nil {
+			return err
+		}
+	}
+	store, err := openPageStore(ctx.Path(TraceSnapshotStoreFlag.Name))
+	if err != nil {
+		return err
+	}
+	verifier, err := newPreimageVerifier(ctx)
+	if err != nil {
+		return err
+	}
+	t := &tracer{
+		log:        l,
+		meta:       meta,
+		store:      store,
+		traceAt:    ctx.Generic(TraceAtFlag.Name).(*StepMatcherFlag),
+		proofAt:    ctx.Generic(TraceProofAtFlag.Name).(*StepMatcherFlag),
+		proofFmt:   ctx.String(TraceProofFmtFlag.Name),
+		serverArgs: preimageServerArgs(ctx),
+		serverAddr: ctx.String(TracePreimageServerFlag.Name),
+		timeout:    ctx.Duration(TracePreimageTimeoutFlag.Name),
+		verifier:   verifier,
+	}
+
+	segments := len(snapshots) - 1
+	workers := ctx.Int(TraceWorkersFlag.Name)
+	if workers < 1 {
+		return fmt.Errorf("need at least 1 worker, but got %d", workers)
+	}
+	if workers > segments {
+		workers = segments
+	}
+	work := make(chan int, segments)
+	for i := 0; i < segments; i++ {
+		work <- i
+	}
+	close(work)
+	results := make([]*Segment, segments)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			if err := t.worker(snapshots, work, results); err != nil {
+				errs[i] = err
+				t.failed.Store(true)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// the last snapshot does not start a segment, check it separately
+	last, err := loadState(snapshots[segments].path, t.store)
+	if err != nil {
+		return err
+	}
+	lastHash := crypto.Keccak256Hash(last.EncodeWitness())
+	for i, seg := range results {
+		next := lastHash
+		if i+1 < len(results) {
+			next = results[i+1].Pre
+		}
+		if seg.Post != next {
+			return fmt.Errorf("segment %d-%d ends with state %s, but snapshot at step %d has state %s",
+				seg.Start, seg.End, seg.Post, seg.End, next)
+		}
+		if err := writeJSON[*Segment](fmt.Sprintf(ctx.String(TraceFmtFlag.Name), seg.Start), seg, false); err != nil {
+			return fmt.Errorf("failed to write segment trace: %w", err)
+		}
+	}
+	l.Info("traced all segments", "segments", segments, "start", snapshots[0].step, "end", snapshots[segments].step)
+	return nil
+}
+
+var TraceCommand = &cli.Command{
+	Name:        "trace",
+	Usage:       "Generate the state-hash trace between snapshots in parallel.",
+	Description: "Generate the state-hash trace between snapshots in parallel, optionally with proofs. Each segment between two consecutive snapshots is run by a worker with its own pre-image server, and the end state of each segment is checked against the snapshot that starts the next segment. Each segment first repeats the last hint of its start snapshot to the pre-image server. A pre-image server command can be specified after '--'.",
+	Action:      Trace,
+	Flags: []cli.Flag{
+		TraceSnapshotsFlag,
+		TraceSnapshotStoreFlag,
+		TraceWorkersFlag,
+		TraceAtFlag,
+		TraceFmtFlag,
+		TraceProofAtFlag,
+		TraceProofFmtFlag,
+		TracePreimageServerFlag,
+		TracePreimageTimeoutFlag,
+		TracePreimageVerifyFlag,
+		TracePreimageManifestFlag,
+		TracePreimageMaxSizeFlag,
+		TraceMetaFlag,
+	},
+}
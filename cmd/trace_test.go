@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// runCommand runs the cannon command with the given arguments.
+func runCommand(t *testing.T, args ...string) error {
+	app := cli.NewApp()
+	app.Name = "cannon"
+	app.Commands = []*cli.Command{RunCommand, TraceCommand, SnapshotCommand}
+	// urfave/cli sets the step matchers in place, and keeps them between runs: restore them for the next run
+	for _, cmd := range app.Commands {
+		for _, f := range cmd.Flags {
+			if gf, ok := f.(*cli.GenericFlag); ok {
+				if m, ok := gf.Value.(*StepMatcherFlag); ok {
+					defer func(m *StepMatcherFlag, v StepMatcherFlag) { *m = v }(m, *m)
+				}
+			}
+		}
+	}
+	return app.Run(append([]string{"cannon"}, args...))
+}
+
+var hintTestPreimage = []byte("hinted pre-image")
+
+// hintTestState is a program that hints "fetch", and then reads the first 4 bytes of the keccak256 pre-image
+// that the hint prepares, after setting the pre-image key.
+func hintTestState(t *testing.T) *mipsevm.State {
+	program := []uint32{
+		0x24020fa4, // li $v0, 4004 (write)
+		0x24040004, // li $a0, 4 (hint write)
+		0x3c050000, // lui $a1, 0
+		0x34a52000, // ori $a1, $a1, 0x2000
+		0x24060009, // li $a2, 9
+		0x0000000c, // syscall
+		0x24020fa3, // li $v0, 4003 (read)
+		0x24040005, // li $a0, 5 (pre-image read)
+		0x34053000, // ori $a1, $zero, 0x3000
+		0x24060004, // li $a2, 4
+		0x0000000c, // syscall
+		0x24021096, // li $v0, 4246 (exit_group)
+		0x24040000, // li $a0, 0
+		0x0000000c, // syscall
+	}
+	state := &mipsevm.State{PC: 0x1000, NextPC: 0x1004, Memory: mipsevm.NewMemory()}
+	for i, insn := range program {
+		state.Memory.SetMemory(0x1000+uint32(i)*4, insn)
+	}
+	hint := binary.BigEndian.AppendUint32(nil, 5)
+	hint = append(hint, "fetch"...)
+	require.NoError(t, state.Memory.SetMemoryRange(0x2000, bytes.NewReader(hint)))
+	state.PreimageKey = preimage.Keccak256Key(preimage.Keccak256(hintTestPreimage)).PreimageKey()
+	return state
+}
+
+type hintTestOracle struct{}
+
+func (hintTestOracle) Hint(v []byte) {}
+
+func (hintTestOracle) GetPreimage(k [32]byte) []byte {
+	return hintTestPreimage
+}
+
+// startHintTestServer serves sessions of which the pre-image is only available after the "fetch" hint,
+// like a host that fetches the pre-images of a hint from a remote source.
+func startHintTestServer(t *testing.T) string {
+	sock := filepath.Join(t.TempDir(), "preimage.sock")
+	l, err := preimage.Listen("unix://" + sock)
+	require.NoError(t, err)
+	ss := &preimage.SessionServer{
+		NewServer: func(hintCh, preimageCh io.ReadWriteCloser) *preimage.Server {
+			var mu sync.Mutex
+			hinted := false
+			s := preimage.NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if !hinted {
+					return nil, errors.New("pre-image was not hinted")
+				}
+				return hintTestPreimage, nil
+			})
+			s.HandleHint("fetch", func(ctx context.Context, hint string) error {
+				mu.Lock()
+				defer mu.Unlock()
+				hinted = true
+				return nil
+			})
+			return s
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = ss.Serve(ctx, l)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return "unix://" + sock
+}
+
+func TestTraceReplayHint(t *testing.T) {
+	dir := t.TempDir()
+	state := hintTestState(t)
+	us := mipsevm.NewInstrumentedState(state, hintTestOracle{}, io.Discard, io.Discard)
+	// the second segment starts between the hint and the pre-image read that depends on it
+	for _, step := range []uint64{0, 8, 12} {
+		_, _, err := us.RunUntil(step-state.Step, nil)
+		require.NoError(t, err)
+		require.NoError(t, writeJSON[*mipsevm.State](filepath.Join(dir, fmt.Sprintf("snap-%d.json", step)), state, false))
+	}
+	require.Equal(t, []byte("fetch"), []byte(state.PrevHint))
+
+	// trace just the second segment, with a fresh pre-image server session
+	addr := startHintTestServer(t)
+	trace := func() error {
+		return runCommand(t, "trace",
+			"--snapshots", filepath.Join(dir, "snap-8.json"),
+			"--snapshots", filepath.Join(dir, "snap-12.json"),
+			"--preimage-server", addr,
+			"--trace-fmt", filepath.Join(dir, "trace-%d.json"),
+			"--meta", "")
+	}
+	require.NoError(t, trace())
+	seg, err := loadJSON[Segment](filepath.Join(dir, "trace-8.json"))
+	require.NoError(t, err)
+	require.Equal(t, uint64(12), seg.End)
+
+	// without the replayed hint, the pre-image server of the segment is not prepared
+	snap, err := loadJSON[mipsevm.State](filepath.Join(dir, "snap-8.json"))
+	require.NoError(t, err)
+	snap.PrevHint = nil
+	require.NoError(t, writeJSON[*mipsevm.State](filepath.Join(dir, "snap-8.json"), snap, false))
+	require.ErrorContains(t, trace(), "failed to get pre-image")
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/ethereum-optimism/cannon/mipsevm"
+)
+
+var (
+	VerifyBundleFlag = &cli.PathFlag{
+		Name:      "bundle",
+		Usage:     "path of the proof bundle, as written by 'run --bundle-at'.",
+		TakesFile: true,
+		Required:  true,
+	}
+)
+
+func Verify(ctx *cli.Context) error {
+	bundle, err := loadJSON[mipsevm.StepBundle](ctx.Path(VerifyBundleFlag.Name))
+	if err != nil {
+		return err
+	}
+	info := &bundle.Info
+	_, _ = fmt.Fprintf(os.Stdout, "step %d: pc %s (%s) insn %s %q\n", bundle.Step, info.PC, info.Symbol, info.Insn, info.Disasm)
+	for i, acc := range bundle.MemAccess {
+		symbol := ""
+		if i < len(info.MemSymbols) {
+			symbol = info.MemSymbols[i]
+		}
+		_, _ = fmt.Fprintf(os.Stdout, "memory access %d at %s %s\n", i, acc.Addr, symbol)
+	}
+	if bundle.PreimageKey != nil {
+		_, _ = fmt.Fprintf(os.Stdout, "pre-image %s at offset %d\n", *bundle.PreimageKey, bundle.PreimageOffset)
+	}
+	if err := mipsevm.VerifyStepBundle(bundle); err != nil {
+		return fmt.Errorf("invalid proof bundle: %w", err)
+	}
+	_, _ = fmt.Fprintf(os.Stdout, "valid: %s -> %s\n", bundle.Pre, bundle.Post)
+	return nil
+}
+
+var VerifyCommand = &cli.Command{
+	Name:        "verify",
+	Usage:       "Verify a proof bundle offline",
+	Description: "Verify a proof bundle offline, by executing the step on the proven memory, without access to the program, the state or a pre-image server.",
+	Action:      Verify,
+	Flags: []cli.Flag{
+		VerifyBundleFlag,
+	},
+}
@@ -42,6 +42,11 @@ contract MIPS {
   // total State size: 32+32+6*4+1+1+8+32*4 = 226 bytes
 
   uint32 constant public BRK_START = 0x40000000;
+  uint32 constant public HEAP_START = 0x20000000;
+
+  // Kernel words: memory words outside of the user address space, that hold syscall state.
+  uint32 constant BRK_ADDR = 0xFFffFFf0; // current program break, zero for the legacy fixed BRK_START
+  uint32 constant TLS_ADDR = 0xFFffFFf4; // thread pointer, set by set_thread_area, read by rdhwr $29
 
   uint32 constant FD_STDIN = 0;
   uint32 constant FD_STDOUT = 1;
@@ -51,6 +56,9 @@ contract MIPS {
   uint32 constant FD_PREIMAGE_READ = 5;
   uint32 constant FD_PREIMAGE_WRITE = 6;
 
+  // maximum iovcnt of writev: each iovec needs its own memory proof, to read the iov_len word
+  uint32 constant MAX_IOVCNT = 16;
+
   uint32 constant EBADF = 0x9;
   uint32 constant EINVAL = 0x16;
 
@@ -107,8 +115,8 @@ contract MIPS {
     uint32 a1 = state.registers[5];
     uint32 a2 = state.registers[6];
 
-    if (syscall_no == 4090) {
-      // mmap
+    if (syscall_no == 4090 || syscall_no == 4210) {
+      // mmap / mmap2 (anonymous mappings only, the offset unit difference does not matter)
       uint32 sz = a1;
       if (sz&4095 != 0) { // adjust size to align with page size
         sz += 4096 - (sz&4095);
@@ -121,12 +129,26 @@ contract MIPS {
       }
     } else if (syscall_no == 4045) {
       // brk
-      v0 = BRK_START;
+      uint32 brk = readMem(BRK_ADDR, 1);
+      if (brk == 0) {
+        v0 = BRK_START; // legacy fixed program break, for programs without brk-based heap
+      } else {
+        // the heap can grow, up to the mmap heap, but not shrink
+        if (a0 > brk && a0 <= HEAP_START) {
+          brk = a0;
+          writeMem(BRK_ADDR, 1, brk);
+        }
+        v0 = brk;
+      }
+    } else if (syscall_no == 4283) {
+      // set_thread_area
+      readMem(TLS_ADDR, 1); // verify proof 1 is correct, before writing with it
+      writeMem(TLS_ADDR, 1, a0);
     } else if (syscall_no == 4120) {
       // clone (not supported)
       v0 = 1;
-    } else if (syscall_no == 4246) {
-      // exit group
+    } else if (syscall_no == 4246 || syscall_no == 4001) {
+      // exit group / exit (there is only a single thread)
       state.exited = true;
       state.exitCode = uint8(a0);
       return outputState();
@@ -185,6 +207,22 @@ contract MIPS {
         v0 = 0xFFffFFff;
         v1 = EBADF;
       }
+    } else if (syscall_no == 4146) { // writev
+      // args: a0 = fd, a1 = iov, a2 = iovcnt
+      // returns: v0 = written, v1 = err code
+      if (a0 != FD_STDOUT && a0 != FD_STDERR && a0 != FD_HINT_WRITE) {
+        v0 = 0xFFffFFff;
+        v1 = EBADF;
+      } else if (a1&3 != 0 || a2 > MAX_IOVCNT) {
+        v0 = 0xFFffFFff;
+        v1 = EINVAL;
+      } else {
+        // tell program we have written everything: the sum of the iov_len of each iovec,
+        // with the next proof for each, since the iov_len words all differ
+        for (uint32 i = 0; i < a2; i++) {
+          v0 += readMem(a1 + i*8 + 4, uint8(1 + i));
+        }
+      }
     } else if (syscall_no == 4055) { // fcntl
       // args: a0 = fd, a1 = cmd
       if (a1 == 3) { // F_GETFL: get file descriptor flags
@@ -311,10 +349,12 @@ contract MIPS {
   function proofOffset(uint8 proofIndex) internal returns (uint256 offset) {
     // A proof of 32 bit memory, with 32-byte leaf values, is (32-5)=27 bytes32 entries.
     // And the leaf value itself needs to be encoded as well. And proof.offset == 390
+    // Proof 0 is the instruction proof, followed by the proofs of the memory accesses of the step,
+    // in the order of the StepWitness of the Go VM (mipsevm/witness.go), which this contract must access memory in.
     offset = 390 + (uint256(proofIndex) * (28*32));
-    uint256 s = 0;
-    assembly { s := calldatasize() }
-    require(s >= (offset + 28*32), "check that there is enough calldata");
+    uint256 proofLen = 0;
+    assembly { proofLen := calldataload(358) } // 390-32=358 proof data length, preceding the proof data
+    require(proofLen >= (uint256(proofIndex) + 1) * (28*32), "missing memory proof");
     return offset;
   }
 
@@ -470,6 +510,11 @@ contract MIPS {
       return handleBranch(opcode, insn, rtReg, rs);
     }
 
+    // rdhwr $rt, $29: read the thread pointer, like the kernel emulates it
+    if (opcode == 0x1f && (insn & 0x3f) == 0x3b && ((insn >> 11) & 0x1F) == 29) {
+      return handleRd(rtReg, readMem(TLS_ADDR, 1), true);
+    }
+
     uint32 storeAddr = 0xFF_FF_FF_FF;
     // memory fetch (all I-type)
     // we do the load for stores also
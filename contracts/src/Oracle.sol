@@ -8,6 +8,23 @@ contract Oracle {
     mapping (bytes32 => mapping(uint256 => bytes32)) preimageParts;
     mapping (bytes32 => mapping(uint256 => bool)) preimagePartOk;
 
+    // LargePreimage is a keccak256 pre-image that is too large to load in a single transaction.
+    // It is absorbed in chunks into a keccak256 sponge, which is kept in storage between the transactions,
+    // while the bytes of the part at the offset are checked against the part that is claimed upfront.
+    struct LargePreimage {
+        uint256 partOffset;
+        bytes32 part;
+        uint256 size; // claimed size of the pre-image, excluding the length prefix
+        uint256 absorbed; // number of pre-image bytes absorbed so far
+        uint64[25] state; // keccak256 sponge state
+    }
+
+    // large pre-images that are being loaded, by sender and claimed key
+    mapping (address => mapping(bytes32 => LargePreimage)) largePreimages;
+
+    // number of bytes that the keccak256 sponge absorbs per permutation
+    uint256 constant KECCAK_RATE = 136;
+
     function readPreimage(bytes32 key, uint256 offset) external view returns (bytes32 dat, uint256 datLen) {
         require(preimagePartOk[key][offset], "preimage must exist");
         datLen = 32;
@@ -53,4 +70,198 @@ contract Oracle {
         preimageParts[key][partOffset] = part;
         preimageLengths[key] = size;
     }
+
+    // loadSha256PreimagePart prepares the pre-image to be read by sha256 key,
+    // starting at the given offset, up to 32 bytes (clipped at preimage length, if out of data).
+    function loadSha256PreimagePart(uint256 partOffset, bytes calldata preimage) external {
+        uint256 size;
+        bytes32 key;
+        bytes32 part;
+        assembly {
+            size := calldataload(0x44) // len(sig) + len(partOffset) + len(preimage offset) = 4 + 32 + 32 = 0x64
+            if iszero(lt(partOffset, add(size, 8))) { // revert if part offset >= size+8 (i.e. parts must be within bounds)
+                revert(0, 0)
+            }
+            let ptr := 0x80 // we leave solidity slots 0x40 and 0x60 untouched, and everything after as scratch-memory.
+            mstore(ptr, shl(192, size)) // put size as big-endian uint64 at start of pre-image
+            ptr := add(ptr, 8)
+            calldatacopy(ptr, preimage.offset, size) // copy preimage payload into memory so we can hash and read it.
+            // Note that it includes the 8-byte big-endian uint64 length prefix.
+            // this will be zero-padded at the end, since memory at end is clean.
+            part := mload(add(sub(ptr, 8), partOffset))
+            // compute preimage sha256 hash with the precompile at address 0x02, into scratch space
+            if iszero(staticcall(gas(), 0x02, ptr, size, 0x00, 0x20)) {
+                revert(0, 0)
+            }
+            let h := mload(0x00)
+            key := or(and(h, not(shl(248, 0xFF))), shl(248, 3)) // mask out prefix byte, replace with type 3 byte
+        }
+        preimagePartOk[key][partOffset] = true;
+        preimageParts[key][partOffset] = part;
+        preimageLengths[key] = size;
+    }
+
+    // initLargeKeccak256Preimage starts loading a large pre-image by keccak256 key, in chunks,
+    // to prepare the part at the given offset, which is claimed upfront and checked as the chunks are absorbed.
+    // Loading the same key again restarts it.
+    function initLargeKeccak256Preimage(uint256 partOffset, bytes32 key, bytes32 part, uint256 size) external {
+        require(uint8(key[0]) == 2, "not a keccak256 key");
+        require(size <= type(uint64).max, "pre-image size does not fit in length prefix");
+        require(partOffset < size + 8, "part offset out of bounds");
+        // the length prefix and the zero padding after the pre-image can be checked right away
+        bytes8 prefix = bytes8(uint64(size));
+        for (uint256 i = 0; i < 32; i++) {
+            uint256 j = partOffset + i;
+            if (j < 8) {
+                require(part[i] == prefix[j], "part does not match length prefix");
+            } else if (j >= size + 8) {
+                require(part[i] == bytes1(0), "part does not match zero padding");
+            }
+        }
+        LargePreimage storage p = largePreimages[msg.sender][key];
+        p.partOffset = partOffset;
+        p.part = part;
+        p.size = size;
+        p.absorbed = 0;
+        delete p.state;
+    }
+
+    // absorbLargeKeccak256Preimage absorbs the next chunk of a large pre-image.
+    // The chunk must be a multiple of the keccak256 rate of 136 bytes.
+    function absorbLargeKeccak256Preimage(bytes32 key, bytes calldata chunk) external {
+        LargePreimage storage p = largePreimages[msg.sender][key];
+        require(chunk.length % KECCAK_RATE == 0, "chunk is not a multiple of the keccak256 rate");
+        require(p.absorbed + chunk.length <= p.size, "chunk exceeds pre-image size");
+        checkLargePreimagePart(p, chunk);
+        uint64[25] memory s = p.state;
+        for (uint256 off = 0; off < chunk.length; off += KECCAK_RATE) {
+            absorbCalldataBlock(s, chunk, off);
+        }
+        p.state = s;
+        p.absorbed += chunk.length;
+    }
+
+    // finalizeLargeKeccak256Preimage absorbs the remaining data of a large pre-image, of any length,
+    // and prepares the claimed part to be read, if the pre-image matches the key.
+    function finalizeLargeKeccak256Preimage(bytes32 key, bytes calldata tail) external {
+        LargePreimage storage p = largePreimages[msg.sender][key];
+        require(p.absorbed + tail.length == p.size, "tail does not complete pre-image");
+        checkLargePreimagePart(p, tail);
+        uint64[25] memory s = p.state;
+        uint256 off = 0;
+        for (; off + KECCAK_RATE <= tail.length; off += KECCAK_RATE) {
+            absorbCalldataBlock(s, tail, off);
+        }
+        // pad the rest of the data to a full block, with the original keccak padding rule
+        bytes memory blk = new bytes(KECCAK_RATE);
+        for (uint256 i = off; i < tail.length; i++) {
+            blk[i - off] = tail[i];
+        }
+        blk[tail.length - off] ^= 0x01;
+        blk[KECCAK_RATE - 1] ^= 0x80;
+        absorbMemoryBlock(s, blk);
+        // squeeze the hash: the first 4 lanes, little-endian
+        uint256 h = (uint256(swapBytes(s[0])) << 192) | (uint256(swapBytes(s[1])) << 128) |
+            (uint256(swapBytes(s[2])) << 64) | uint256(swapBytes(s[3]));
+        h = (h & ~(uint256(0xFF) << 248)) | (uint256(2) << 248); // mask out prefix byte, replace with type 2 byte
+        require(bytes32(h) == key, "pre-image does not match key");
+
+        preimagePartOk[key][p.partOffset] = true;
+        preimageParts[key][p.partOffset] = p.part;
+        preimageLengths[key] = p.size;
+        delete largePreimages[msg.sender][key];
+    }
+
+    // checkLargePreimagePart checks the bytes of the claimed part that overlap with the next data to absorb.
+    function checkLargePreimagePart(LargePreimage storage p, bytes calldata data) internal view {
+        uint256 partOffset = p.partOffset;
+        bytes32 part = p.part;
+        uint256 start = p.absorbed + 8; // offset of the data in the length-prefixed pre-image
+        uint256 lo = start > partOffset ? start : partOffset;
+        uint256 hi = start + data.length;
+        if (hi > partOffset + 32) {
+            hi = partOffset + 32;
+        }
+        for (uint256 j = lo; j < hi; j++) {
+            require(data[j - start] == part[j - partOffset], "data does not match claimed part");
+        }
+    }
+
+    // absorbCalldataBlock absorbs the block of data at the given offset into the keccak256 sponge.
+    function absorbCalldataBlock(uint64[25] memory s, bytes calldata data, uint256 off) internal pure {
+        for (uint256 i = 0; i < KECCAK_RATE / 8; i++) {
+            uint64 lane;
+            assembly {
+                lane := shr(192, calldataload(add(data.offset, add(off, mul(i, 8)))))
+            }
+            s[i] ^= swapBytes(lane);
+        }
+        keccakF(s);
+    }
+
+    // absorbMemoryBlock absorbs the block of data into the keccak256 sponge.
+    function absorbMemoryBlock(uint64[25] memory s, bytes memory blk) internal pure {
+        for (uint256 i = 0; i < KECCAK_RATE / 8; i++) {
+            uint64 lane;
+            assembly {
+                lane := shr(192, mload(add(add(blk, 0x20), mul(i, 8))))
+            }
+            s[i] ^= swapBytes(lane);
+        }
+        keccakF(s);
+    }
+
+    // swapBytes converts between big-endian and little-endian 64-bit lanes.
+    function swapBytes(uint64 v) internal pure returns (uint64) {
+        v = ((v & 0xFF00FF00FF00FF00) >> 8) | ((v & 0x00FF00FF00FF00FF) << 8);
+        v = ((v & 0xFFFF0000FFFF0000) >> 16) | ((v & 0x0000FFFF0000FFFF) << 16);
+        return (v >> 32) | (v << 32);
+    }
+
+    function rotl(uint64 v, uint256 n) internal pure returns (uint64) {
+        return (v << n) | (v >> (64 - n)); // shifting by 64 results in zero
+    }
+
+    // keccakF is the keccak-f[1600] permutation of the sponge state.
+    function keccakF(uint64[25] memory a) internal pure {
+        uint64[24] memory rc = [
+            uint64(0x0000000000000001), 0x0000000000008082, 0x800000000000808A, 0x8000000080008000,
+            0x000000000000808B, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+            0x000000000000008A, 0x0000000000000088, 0x0000000080008009, 0x000000008000000A,
+            0x000000008000808B, 0x800000000000008B, 0x8000000000008089, 0x8000000000008003,
+            0x8000000000008002, 0x8000000000000080, 0x000000000000800A, 0x800000008000000A,
+            0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008
+        ];
+        uint8[25] memory rot = [
+            uint8(0), 1, 62, 28, 27, 36, 44, 6, 55, 20, 3, 10, 43, 25, 39, 41, 45, 15, 21, 8, 18, 2, 61, 56, 14
+        ];
+        uint64[5] memory c;
+        uint64[25] memory b;
+        for (uint256 round = 0; round < 24; round++) {
+            // theta
+            for (uint256 x = 0; x < 5; x++) {
+                c[x] = a[x] ^ a[x + 5] ^ a[x + 10] ^ a[x + 15] ^ a[x + 20];
+            }
+            for (uint256 x = 0; x < 5; x++) {
+                uint64 d = c[(x + 4) % 5] ^ rotl(c[(x + 1) % 5], 1);
+                for (uint256 y = 0; y < 25; y += 5) {
+                    a[y + x] ^= d;
+                }
+            }
+            // rho and pi
+            for (uint256 x = 0; x < 5; x++) {
+                for (uint256 y = 0; y < 5; y++) {
+                    b[y + 5 * ((2 * x + 3 * y) % 5)] = rotl(a[x + 5 * y], rot[x + 5 * y]);
+                }
+            }
+            // chi
+            for (uint256 x = 0; x < 5; x++) {
+                for (uint256 y = 0; y < 25; y += 5) {
+                    a[y + x] = b[y + x] ^ (~b[y + (x + 1) % 5] & b[y + (x + 2) % 5]);
+                }
+            }
+            // iota
+            a[0] ^= rc[round];
+        }
+    }
 }
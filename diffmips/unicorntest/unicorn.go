@@ -25,9 +25,11 @@ type UnicornState struct {
 	stdOut io.Writer
 	stdErr io.Writer
 
-	lastMemAccess   uint32
 	memProofEnabled bool
-	memProof        [28 * 32]byte
+	// addresses of the memory words accessed by the current step, in order, with repeated accesses of a word merged
+	memAccess []uint32
+	// memory proof of each memory access, taken right before the access
+	memProofs [][28 * 32]byte
 
 	preimageOracle PreimageOracle
 
@@ -80,14 +82,16 @@ func NewUnicornState(mu uc.Unicorn, state *mipsevm.State, po PreimageOracle, std
 		return
 	}
 
+	// the memory proofs are taken in the order of mipsevm.StepWitness
 	trackMemAccess := func(effAddr uint32) {
-		if m.memProofEnabled && m.lastMemAccess != effAddr {
-			if m.lastMemAccess != ^uint32(0) {
-				panic(fmt.Errorf("unexpected different mem access at %08x, already have access at %08x buffered", effAddr, m.lastMemAccess))
-			}
-			m.lastMemAccess = effAddr
-			m.memProof = m.state.Memory.MerkleProof(effAddr)
+		if !m.memProofEnabled {
+			return
 		}
+		if n := len(m.memAccess); n > 0 && m.memAccess[n-1] == effAddr {
+			return // the proof of the previous access still applies
+		}
+		m.memAccess = append(m.memAccess, effAddr)
+		m.memProofs = append(m.memProofs, m.state.Memory.MerkleProof(effAddr))
 	}
 
 	var err error
@@ -188,7 +192,7 @@ func NewUnicornState(mu uc.Unicorn, state *mipsevm.State, po PreimageOracle, std
 				st.LastHint = append(st.LastHint, hintData...)
 				for len(st.LastHint) >= 4 { // process while there is enough data to check if there are any hints
 					hintLen := binary.BigEndian.Uint32(st.LastHint[:4])
-					if hintLen >= uint32(len(st.LastHint[4:])) {
+					if hintLen <= uint32(len(st.LastHint[4:])) {
 						hint := st.LastHint[4 : 4+hintLen] // without the length prefix
 						st.LastHint = st.LastHint[4+hintLen:]
 						po.Hint(hint)
@@ -316,7 +320,8 @@ func (m *UnicornState) Step(proof bool) (wit *mipsevm.StepWitness, err error) {
 	}()
 
 	m.memProofEnabled = proof
-	m.lastMemAccess = ^uint32(0)
+	m.memAccess = m.memAccess[:0]
+	m.memProofs = m.memProofs[:0]
 	m.lastPreimageOffset = ^uint32(0)
 
 	if proof {
@@ -376,7 +381,10 @@ func (m *UnicornState) Step(proof bool) (wit *mipsevm.StepWitness, err error) {
 	}
 
 	if proof {
-		wit.MemProof = append(wit.MemProof, m.memProof[:]...)
+		for _, p := range m.memProofs {
+			wit.MemProof = append(wit.MemProof, p[:]...)
+		}
+		wit.MemAccess = append([]uint32(nil), m.memAccess...)
 		if m.lastPreimageOffset != ^uint32(0) {
 			wit.PreimageOffset = m.lastPreimageOffset
 			wit.PreimageKey = m.lastPreimageKey
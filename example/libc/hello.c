@@ -0,0 +1,16 @@
+// A statically linked C program, to run with the libc loader profile:
+// malloc grows the brk heap, and stdio writes to stdout with writev.
+#include <stdio.h>
+#include <stdlib.h>
+#include <string.h>
+
+int main(void) {
+	char *name = malloc(16);
+	if (name == NULL) {
+		return 1;
+	}
+	strcpy(name, "libc");
+	printf("hello %s!\n", name);
+	free(name);
+	return 0;
+}
@@ -7,6 +7,7 @@ require (
 	github.com/ethereum/go-ethereum v1.11.5
 	github.com/stretchr/testify v1.8.2
 	github.com/urfave/cli/v2 v2.25.3
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -60,7 +61,6 @@ require (
 	golang.org/x/text v0.9.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/natefinch/npipe.v2 v2.0.0-20160621034901-c1b8fa8bdcce // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 replace github.com/ethereum-optimism/cannon/preimage v0.0.0 => ./preimage
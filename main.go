@@ -17,6 +17,12 @@ func main() {
 	app.Commands = []*cli.Command{
 		cmd.LoadELFCommand,
 		cmd.RunCommand,
+		cmd.DisasmCommand,
+		cmd.CheckELFCommand,
+		cmd.TraceCommand,
+		cmd.SnapshotCommand,
+		cmd.VerifyCommand,
+		cmd.DecodeCommand,
 	}
 	err := app.Run(os.Args)
 	if err != nil {
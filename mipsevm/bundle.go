@@ -0,0 +1,267 @@
+package mipsevm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// StepInfo describes the instruction that a step executes. It is informational only, and not verified.
+type StepInfo struct {
+	PC     HexU32 `json:"pc"`
+	NextPC HexU32 `json:"nextPC"`
+	Insn   HexU32 `json:"insn"`
+	Disasm string `json:"disasm"`
+	Symbol string `json:"symbol"`
+	// Syscall is the syscall number, if the instruction is a syscall
+	Syscall uint32 `json:"syscall,omitempty"`
+	// MemSymbols are the symbols that cover the memory accesses, empty for memory that is not covered by a symbol
+	MemSymbols []string `json:"memSymbols,omitempty"`
+}
+
+// MemAccessProof is the memory proof of a memory access, taken right before the access.
+type MemAccessProof struct {
+	Addr  HexU32        `json:"addr"`
+	Proof hexutil.Bytes `json:"proof"`
+}
+
+// StepBundle is a self-contained proof of a single step,
+// that can be verified with VerifyStepBundle without access to the program, the state or a pre-image server.
+type StepBundle struct {
+	Step uint64 `json:"step"`
+
+	Pre  common.Hash `json:"pre"`
+	Post common.Hash `json:"post"`
+
+	// State is the packed witness of the pre-state
+	State hexutil.Bytes `json:"state"`
+	// InsnProof is the memory proof of the instruction at the PC
+	InsnProof hexutil.Bytes `json:"insnProof"`
+	// MemAccess are the memory proofs of the memory accesses of the step, in order of access
+	MemAccess []MemAccessProof `json:"memAccess,omitempty"`
+
+	PreimageKey    *common.Hash  `json:"preimageKey,omitempty"`
+	PreimageOffset uint32        `json:"preimageOffset,omitempty"`
+	PreimageValue  hexutil.Bytes `json:"preimageValue,omitempty"` // including the 8-byte length prefix
+
+	Info StepInfo `json:"info"`
+}
+
+// NewStepBundle bundles the witness of a step with the resulting post-state.
+// The metadata is used to describe the step, and may be empty.
+func NewStepBundle(wit *StepWitness, post *State, meta *Metadata) (*StepBundle, error) {
+	pre, err := DecodeStateWitness(wit.State)
+	if err != nil {
+		return nil, err
+	}
+	if len(wit.MemProof) != (1+len(wit.MemAccess))*28*32 {
+		return nil, fmt.Errorf("expected instruction proof and %d memory proofs, but got %d bytes", len(wit.MemAccess), len(wit.MemProof))
+	}
+	out := &StepBundle{
+		Step:      pre.Step,
+		Pre:       crypto.Keccak256Hash(wit.State),
+		Post:      crypto.Keccak256Hash(post.EncodeWitness()),
+		State:     wit.State,
+		InsnProof: wit.MemProof[:28*32],
+	}
+	insn := proofWord(out.InsnProof, uint32(pre.PC))
+	out.Info = StepInfo{
+		PC:     pre.PC,
+		NextPC: pre.NextPC,
+		Insn:   HexU32(insn),
+		Disasm: Disassemble(uint32(pre.PC), insn, meta),
+		Symbol: meta.LookupSymbol(uint32(pre.PC)),
+	}
+	if insn == 0x0000000c {
+		out.Info.Syscall = uint32(pre.Registers[2])
+	}
+	for i, addr := range wit.MemAccess {
+		out.MemAccess = append(out.MemAccess, MemAccessProof{
+			Addr:  HexU32(addr),
+			Proof: wit.MemProof[(i+1)*28*32 : (i+2)*28*32],
+		})
+		name, _, _ := meta.LookupSymbolOffset(addr)
+		out.Info.MemSymbols = append(out.Info.MemSymbols, name)
+	}
+	if wit.HasPreimage() {
+		key := common.Hash(wit.PreimageKey)
+		out.PreimageKey = &key
+		out.PreimageOffset = wit.PreimageOffset
+		out.PreimageValue = wit.PreimageValue
+	}
+	return out, nil
+}
+
+// proofWord returns the memory word at the given address from the leaf of the memory proof.
+func proofWord(proof []byte, addr uint32) uint32 {
+	i := addr & 31 &^ 3
+	return binary.BigEndian.Uint32(proof[i : i+4])
+}
+
+// proofRoot returns the memory root that the memory proof of the given address commits to.
+func proofRoot(proof []byte, addr uint32) [32]byte {
+	node := *(*[32]byte)(proof[:32])
+	path := addr >> 5
+	for i := 32; i < len(proof); i += 32 {
+		sib := *(*[32]byte)(proof[i : i+32])
+		if path&1 != 0 {
+			node = HashPair(sib, node)
+		} else {
+			node = HashPair(node, sib)
+		}
+		path >>= 1
+	}
+	return node
+}
+
+// leafRoot returns the memory root that the memory proof of the given address commits to,
+// with the leaf of the proof replaced by the leaf in memory.
+func leafRoot(proof []byte, addr uint32, mem *Memory) [32]byte {
+	proof = append([]byte{}, proof...)
+	leafAddr := addr &^ 31
+	for i := uint32(0); i < 32; i += 4 {
+		binary.BigEndian.PutUint32(proof[i:i+4], mem.GetMemory(leafAddr+i))
+	}
+	return proofRoot(proof, addr)
+}
+
+// bundleOracle serves the single pre-image of a bundle.
+type bundleOracle struct {
+	key   [32]byte
+	value []byte // including the 8-byte length prefix
+}
+
+func (o *bundleOracle) Hint(v []byte) {}
+
+func (o *bundleOracle) GetPreimage(k [32]byte) []byte {
+	if o.value == nil || k != o.key {
+		panic(fmt.Errorf("pre-image %x is not part of the bundle", k))
+	}
+	return o.value[8:]
+}
+
+// verifyPreimage checks that the pre-image value matches the key, if the key type allows it.
+func verifyPreimage(key [32]byte, value []byte) error {
+	if len(value) < 8 {
+		return errors.New("pre-image value is missing the length prefix")
+	}
+	if n := binary.BigEndian.Uint64(value[:8]); n != uint64(len(value)-8) {
+		return fmt.Errorf("pre-image length prefix is %d, but got %d bytes", n, len(value)-8)
+	}
+	// local pre-images are part of the bootstrap data of the program, and cannot be verified by key
+	return preimage.VerifyPreimage(key, value[8:])
+}
+
+// VerifyStepBundle checks that the step of the bundle results in its post-state.
+// The step is executed on the proven memory only, and the post-state memory root is computed from the memory proof.
+func VerifyStepBundle(b *StepBundle) (err error) {
+	if h := crypto.Keccak256Hash(b.State); h != b.Pre {
+		return fmt.Errorf("state witness hashes to %s, expected pre-state %s", h, b.Pre)
+	}
+	wit, err := DecodeStateWitness(b.State)
+	if err != nil {
+		return err
+	}
+	st, memRoot := wit.State(), [32]byte(wit.MemRoot)
+	if st.Step != b.Step {
+		return fmt.Errorf("state witness is at step %d, expected step %d", st.Step, b.Step)
+	}
+	if len(b.InsnProof) != 28*32 {
+		return fmt.Errorf("expected instruction proof of %d bytes, but got %d", 28*32, len(b.InsnProof))
+	}
+	if proofRoot(b.InsnProof, st.PC) != memRoot {
+		return fmt.Errorf("instruction proof at %08x does not match memory root", st.PC)
+	}
+	for i, acc := range b.MemAccess {
+		if len(acc.Proof) != 28*32 {
+			return fmt.Errorf("expected memory proof %d of %d bytes, but got %d", i, 28*32, len(acc.Proof))
+		}
+	}
+	oracle := &bundleOracle{}
+	if b.PreimageKey != nil {
+		if err := verifyPreimage(*b.PreimageKey, b.PreimageValue); err != nil {
+			return err
+		}
+		oracle.key, oracle.value = *b.PreimageKey, b.PreimageValue
+	}
+
+	// Only the proven memory is available: the instruction leaf, and the leaves of the memory accesses.
+	// The leaf of a memory access is loaded right before the access, unless an earlier access already loaded it.
+	st.Memory = NewMemory()
+	loaded := make(map[uint32]bool)
+	setLeaf := func(proof []byte, addr uint32) {
+		leafAddr := addr &^ 31
+		if loaded[leafAddr] {
+			return
+		}
+		loaded[leafAddr] = true
+		for i := uint32(0); i < 32; i += 4 {
+			st.Memory.SetMemory(leafAddr+i, binary.BigEndian.Uint32(proof[i:i+4]))
+		}
+	}
+	setLeaf(b.InsnProof, st.PC)
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("step panicked: %v", r)
+		}
+	}()
+	us := NewInstrumentedState(st, oracle, io.Discard, io.Discard)
+	// Each memory proof must match the memory root as changed by the earlier accesses of the step,
+	// which is computed from the proof of the previous access, with its leaf as changed in memory.
+	root := memRoot
+	var accessErr error
+	us.memAccessHook = func(addr uint32) {
+		i := len(us.memAccess)
+		if accessErr != nil {
+			return
+		}
+		if i >= len(b.MemAccess) {
+			accessErr = fmt.Errorf("step accessed memory at %08x, but the bundle has only %d memory proofs", addr, len(b.MemAccess))
+			return
+		}
+		acc := b.MemAccess[i]
+		if uint32(acc.Addr) != addr {
+			accessErr = fmt.Errorf("step accessed memory at %08x, but memory proof %d is for %08x", addr, i, uint32(acc.Addr))
+			return
+		}
+		if i > 0 {
+			prev := b.MemAccess[i-1]
+			root = leafRoot(prev.Proof, uint32(prev.Addr), st.Memory)
+		}
+		setLeaf(acc.Proof, addr)
+		if leafRoot(acc.Proof, addr, st.Memory) != root {
+			accessErr = fmt.Errorf("memory proof %d at %08x does not match memory root", i, addr)
+		}
+	}
+	if _, err := us.Step(false); err != nil {
+		return fmt.Errorf("failed to execute step: %w", err)
+	}
+	if accessErr != nil {
+		return accessErr
+	}
+	if len(us.memAccess) != len(b.MemAccess) {
+		return fmt.Errorf("step accessed %d memory words, but the bundle has %d memory proofs", len(us.memAccess), len(b.MemAccess))
+	}
+	if b.PreimageKey != nil && us.lastPreimageOffset == ^uint32(0) {
+		return errors.New("step did not read the pre-image of the bundle")
+	}
+
+	// Only the leaf of the last memory access may have changed since its proof, the other memory is unchanged.
+	postRoot := root
+	if n := len(b.MemAccess); n > 0 {
+		last := b.MemAccess[n-1]
+		postRoot = leafRoot(last.Proof, uint32(last.Addr), st.Memory)
+	}
+	if h := crypto.Keccak256Hash(st.encodeWitness(postRoot)); h != b.Post {
+		return fmt.Errorf("step results in post-state %s, expected %s", h, b.Post)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package mipsevm
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+func TestStepBundle(t *testing.T) {
+	data := []byte("hello world")
+	key := preimage.Keccak256Key(crypto.Keccak256Hash(data)).PreimageKey()
+	oracle := &testOracle{
+		hint: func(v []byte) {},
+		getPreimage: func(k [32]byte) []byte {
+			require.Equal(t, key, k)
+			return data
+		},
+	}
+	state := loadProgram(t,
+		0x24082000, // li $t0, 0x2000
+		0xad080004, // sw $t0, 4($t0)
+		0x8d090004, // lw $t1, 4($t0)
+		0x24020fa3, // li $v0, 4003 (read)
+		0x24040005, // li $a0, 5 (pre-image read fd)
+		0x24053000, // li $a1, 0x3000
+		0x24060004, // li $a2, 4
+		0x0000000c, // syscall
+	)
+	state.PreimageKey = key
+	us := NewInstrumentedState(state, oracle, io.Discard, io.Discard)
+	var bundles []*StepBundle
+	for i := 0; i < 8; i++ {
+		wit, err := us.Step(true)
+		require.NoError(t, err)
+		b, err := NewStepBundle(wit, state, &Metadata{})
+		require.NoError(t, err)
+		require.NoError(t, VerifyStepBundle(b), "step %d", i)
+
+		dat, err := json.Marshal(b)
+		require.NoError(t, err)
+		var res StepBundle
+		require.NoError(t, json.Unmarshal(dat, &res))
+		require.NoError(t, VerifyStepBundle(&res), "step %d from JSON", i)
+		bundles = append(bundles, b)
+	}
+	require.Empty(t, bundles[0].MemAccess, "no memory access")
+	require.Len(t, bundles[1].MemAccess, 1, "load and store of the same word share a proof")
+	require.Equal(t, HexU32(0x2004), bundles[1].MemAccess[0].Addr)
+	require.Equal(t, "sw      $t0, 4($t0)", bundles[1].Info.Disasm)
+	require.Equal(t, uint32(sysRead), bundles[7].Info.Syscall)
+	require.Equal(t, common.Hash(key), *bundles[7].PreimageKey)
+
+	tamper := func(b *StepBundle, fn func(c *StepBundle)) *StepBundle {
+		c := *b
+		c.State = append([]byte{}, b.State...)
+		c.MemAccess = nil
+		for _, acc := range b.MemAccess {
+			c.MemAccess = append(c.MemAccess, MemAccessProof{Addr: acc.Addr, Proof: append([]byte{}, acc.Proof...)})
+		}
+		c.PreimageValue = append([]byte{}, b.PreimageValue...)
+		fn(&c)
+		return &c
+	}
+	t.Run("wrong post-state", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[1], func(c *StepBundle) { c.Post[0] ^= 1 }))
+		require.ErrorContains(t, err, "step results in post-state")
+	})
+	t.Run("wrong memory", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[2], func(c *StepBundle) { c.MemAccess[0].Proof[4] ^= 1 }))
+		require.ErrorContains(t, err, "memory proof 0 at 00002004 does not match memory root")
+	})
+	t.Run("missing memory proof", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[2], func(c *StepBundle) { c.MemAccess = nil }))
+		require.ErrorContains(t, err, "bundle has only 0 memory proofs")
+	})
+	t.Run("extra memory proof", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[2], func(c *StepBundle) { c.MemAccess = append(c.MemAccess, c.MemAccess[0]) }))
+		require.ErrorContains(t, err, "step accessed 1 memory words, but the bundle has 2 memory proofs")
+	})
+	t.Run("wrong memory address", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[2], func(c *StepBundle) { c.MemAccess[0].Addr += 4 }))
+		require.ErrorContains(t, err, "memory proof 0 is for 00002008")
+	})
+	t.Run("wrong pre-image", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[7], func(c *StepBundle) { c.PreimageValue[8] ^= 1 }))
+		require.ErrorContains(t, err, "pre-image does not match")
+	})
+	t.Run("missing pre-image", func(t *testing.T) {
+		err := VerifyStepBundle(tamper(bundles[7], func(c *StepBundle) { c.PreimageKey = nil }))
+		require.ErrorContains(t, err, "not part of the bundle")
+	})
+}
@@ -0,0 +1,277 @@
+package mipsevm
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// supportedSyscalls are the syscalls implemented by handleSyscall.
+var supportedSyscalls = map[uint32]string{
+	sysExit:          "exit",
+	sysRead:          "read",
+	sysWrite:         "write",
+	sysBrk:           "brk",
+	sysFcntl:         "fcntl",
+	sysMmap:          "mmap",
+	sysClone:         "clone",
+	sysWritev:        "writev",
+	sysMmap2:         "mmap2",
+	sysExitGroup:     "exit_group",
+	sysSetThreadArea: "set_thread_area",
+}
+
+// tolerableInstructions are unsupported instructions that are common in programs that run fine in the VM,
+// since they are never reached in normal execution. These are reported as warnings instead of errors.
+var tolerableInstructions = map[uint32]string{
+	0x00000034: "unconditional trap (teq $zero, $zero), the program crashes if it is reached",
+}
+
+const ptMipsAbiFlags = elf.ProgType(0x70000003)
+
+// expectedProgTypes are the program header types that LoadELF knows how to handle.
+var expectedProgTypes = map[elf.ProgType]bool{
+	elf.PT_NULL:         true,
+	elf.PT_LOAD:         true,
+	elf.PT_NOTE:         true,
+	elf.PT_PHDR:         true,
+	elf.PT_GNU_STACK:    true,
+	elf.PT_GNU_EH_FRAME: true,
+	elf.PT_GNU_PROPERTY: true,
+	elf.PT_TLS:          true, // initialized by the libc, with set_thread_area
+	ptMipsAbiFlags:      true,
+	elf.PT_MIPS_REGINFO: true,
+	elf.PT_MIPS_RTPROC:  true,
+	elf.PT_MIPS_OPTIONS: true,
+}
+
+// Severity classifies a CompatIssue.
+type Severity string
+
+const (
+	// SeverityError is used for issues that make the program fail, or behave differently, when executed.
+	SeverityError Severity = "error"
+	// SeverityWarning is used for issues that only matter if the code path is reached,
+	// and is expected to fail outside of the VM too, or that are emulated as no-op.
+	SeverityWarning Severity = "warning"
+)
+
+// CompatIssue describes a part of an ELF file that the VM cannot execute as-is.
+type CompatIssue struct {
+	Severity Severity `json:"severity"`
+	Kind     string   `json:"kind"` // one of "header", "program-header", "instruction", "syscall"
+	Addr     uint32   `json:"addr,omitempty"`
+	Insn     uint32   `json:"insn,omitempty"`
+	Symbol   string   `json:"symbol,omitempty"`
+	Reason   string   `json:"reason"`
+}
+
+func (c *CompatIssue) String() string {
+	switch c.Kind {
+	case "instruction", "syscall":
+		return fmt.Sprintf("%s: %s at %08x (%08x) in %s: %s", c.Severity, c.Kind, c.Addr, c.Insn, c.Symbol, c.Reason)
+	default:
+		return fmt.Sprintf("%s: %s: %s", c.Severity, c.Kind, c.Reason)
+	}
+}
+
+// CompatReport lists all compatibility issues found by CheckELF.
+type CompatReport struct {
+	Issues []CompatIssue `json:"issues"`
+}
+
+func (r *CompatReport) add(issue CompatIssue) {
+	r.Issues = append(r.Issues, issue)
+}
+
+// Errors returns the issues with SeverityError
+func (r *CompatReport) Errors() (out []CompatIssue) {
+	for _, issue := range r.Issues {
+		if issue.Severity == SeverityError {
+			out = append(out, issue)
+		}
+	}
+	return out
+}
+
+// Err returns an error summarizing the issues with SeverityError, or nil if there are none.
+func (r *CompatReport) Err() error {
+	errs := r.Errors()
+	if len(errs) == 0 {
+		return nil
+	}
+	var sb strings.Builder
+	_, _ = fmt.Fprintf(&sb, "found %d incompatibilities", len(errs))
+	for i := range errs {
+		if i == 5 {
+			_, _ = fmt.Fprintf(&sb, "; and %d more", len(errs)-i)
+			break
+		}
+		sb.WriteString("; ")
+		sb.WriteString(errs[i].String())
+	}
+	return errors.New(sb.String())
+}
+
+// CheckELF statically checks if the ELF program can be executed by the VM:
+// the ELF header and program headers must describe a static 32-bit big-endian MIPS executable,
+// and the executable code must not contain any instructions the VM does not support.
+// Syscalls with a constant syscall number that are not handled by the VM are reported as warnings.
+func CheckELF(f *elf.File) (*CompatReport, error) {
+	report := &CompatReport{}
+	if f.Class != elf.ELFCLASS32 {
+		report.add(CompatIssue{Severity: SeverityError, Kind: "header", Reason: fmt.Sprintf("expected 32-bit ELF, got %s", f.Class)})
+	}
+	if f.Data != elf.ELFDATA2MSB {
+		report.add(CompatIssue{Severity: SeverityError, Kind: "header", Reason: fmt.Sprintf("expected big-endian ELF, got %s", f.Data)})
+	}
+	if f.Machine != elf.EM_MIPS {
+		report.add(CompatIssue{Severity: SeverityError, Kind: "header", Reason: fmt.Sprintf("expected MIPS ELF, got %s", f.Machine)})
+	}
+	if f.Type != elf.ET_EXEC {
+		report.add(CompatIssue{Severity: SeverityError, Kind: "header", Reason: fmt.Sprintf("expected static executable, got %s", f.Type)})
+	}
+
+	for i, prog := range f.Progs {
+		if !expectedProgTypes[prog.Type] {
+			reason := fmt.Sprintf("unexpected program header %d of type %s", i, prog.Type)
+			switch prog.Type {
+			case elf.PT_INTERP, elf.PT_DYNAMIC:
+				reason += ", the program must be statically linked"
+			}
+			report.add(CompatIssue{Severity: SeverityError, Kind: "program-header", Reason: reason})
+		}
+		if prog.Vaddr+prog.Memsz >= uint64(1<<32) {
+			report.add(CompatIssue{Severity: SeverityError, Kind: "program-header",
+				Reason: fmt.Sprintf("program header %d out of 32-bit mem range: %x - %x", i, prog.Vaddr, prog.Vaddr+prog.Memsz)})
+		}
+		if prog.Filesz > prog.Memsz {
+			report.add(CompatIssue{Severity: SeverityError, Kind: "program-header",
+				Reason: fmt.Sprintf("program header %d has larger file size (%d) than mem size (%d)", i, prog.Filesz, prog.Memsz)})
+		}
+	}
+
+	meta, err := MakeMetadata(f)
+	if err != nil && !errors.Is(err, elf.ErrNoSymbols) {
+		return nil, err
+	}
+	if meta == nil {
+		meta = &Metadata{}
+	}
+
+	code, err := executableCode(f)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range code {
+		checkCode(report, meta, c.addr, c.data)
+	}
+	return report, nil
+}
+
+type codeRange struct {
+	addr uint32
+	data []byte
+}
+
+// executableCode returns the executable sections, or the executable segments if there are no section headers.
+func executableCode(f *elf.File) ([]codeRange, error) {
+	var out []codeRange
+	for _, sec := range f.Sections {
+		if sec.Type != elf.SHT_PROGBITS || sec.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		data, err := sec.Data()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read section %q: %w", sec.Name, err)
+		}
+		out = append(out, codeRange{addr: uint32(sec.Addr), data: data})
+	}
+	if len(out) > 0 {
+		return out, nil
+	}
+	for i, prog := range f.Progs {
+		if prog.Type != elf.PT_LOAD || prog.Flags&elf.PF_X == 0 {
+			continue
+		}
+		data := make([]byte, prog.Filesz)
+		if _, err := prog.ReadAt(data, 0); err != nil {
+			return nil, fmt.Errorf("failed to read program segment %d: %w", i, err)
+		}
+		out = append(out, codeRange{addr: uint32(prog.Vaddr), data: data})
+	}
+	return out, nil
+}
+
+func checkCode(report *CompatReport, meta *Metadata, addr uint32, data []byte) {
+	for i := 0; i+4 <= len(data); i += 4 {
+		pc := addr + uint32(i)
+		insn := binary.BigEndian.Uint32(data[i : i+4])
+		ins, err := DecodeInstruction(insn)
+		if err != nil {
+			issue := CompatIssue{Severity: SeverityError, Kind: "instruction", Addr: pc, Insn: insn, Symbol: meta.LookupSymbol(pc), Reason: err.Error()}
+			var unsupportedErr *UnsupportedInstructionError
+			if errors.As(err, &unsupportedErr) {
+				issue.Reason = unsupportedErr.Reason
+			}
+			if reason, ok := tolerableInstructions[insn]; ok {
+				issue.Severity = SeverityWarning
+				issue.Reason = reason
+			}
+			report.add(issue)
+			continue
+		}
+		if ins.Mnemonic != "syscall" {
+			continue
+		}
+		num, ok := syscallNumber(data[:i])
+		if !ok {
+			continue
+		}
+		if _, ok := supportedSyscalls[num]; !ok {
+			report.add(CompatIssue{Severity: SeverityWarning, Kind: "syscall", Addr: pc, Insn: insn, Symbol: meta.LookupSymbol(pc),
+				Reason: fmt.Sprintf("syscall %d is not supported, and is emulated as no-op returning 0", num)})
+		}
+	}
+}
+
+// syscallNumber looks back at the instructions leading up to a syscall instruction,
+// to find the constant that is loaded into $v0 as syscall number, if any.
+func syscallNumber(preceding []byte) (uint32, bool) {
+	const v0 = 2
+	for i := 0; i < 8 && len(preceding) >= 4; i++ {
+		insn := binary.BigEndian.Uint32(preceding[len(preceding)-4:])
+		preceding = preceding[:len(preceding)-4]
+		opcode := insn >> 26
+		rs := (insn >> 21) & 0x1F
+		rt := (insn >> 16) & 0x1F
+		switch {
+		case (opcode == 0x09 || opcode == 0x0d) && rs == 0 && rt == v0: // addiu/ori $v0, $zero, imm
+			if opcode == 0x09 {
+				return SE(insn&0xFFFF, 16), true
+			}
+			return insn & 0xFFFF, true
+		case opcode == 0x02 || opcode == 0x03 || (opcode == 0 && (insn&0x3F == 0x08 || insn&0x3F == 0x09)) || opcode == 0x1 || (opcode >= 4 && opcode < 8):
+			return 0, false // control flow, the syscall number may come from elsewhere
+		case opcode == 0 && (insn>>11)&0x1F == v0 && insn != 0: // other writes to $v0
+			return 0, false
+		case opcode != 0 && opcode < 0x28 && rt == v0: // I-type writes to $v0
+			return 0, false
+		}
+	}
+	return 0, false
+}
+
+// ELFCheck is an optional validation step of LoadELF, to reject programs before loading them.
+type ELFCheck func(f *elf.File) error
+
+// CheckCompat is an ELFCheck that rejects programs that CheckELF reports errors for.
+func CheckCompat(f *elf.File) error {
+	report, err := CheckELF(f)
+	if err != nil {
+		return fmt.Errorf("failed to check ELF compatibility: %w", err)
+	}
+	return report.Err()
+}
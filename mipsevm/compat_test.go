@@ -0,0 +1,46 @@
+package mipsevm
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCode(t *testing.T) {
+	meta := &Metadata{Symbols: []Symbol{
+		{Name: "main.main", Start: 0x1000, Size: 0x20},
+		{Name: "main.exit", Start: 0x1020, Size: 0x20},
+	}}
+	insns := []uint32{
+		0x27bdffe8, // addiu $sp, $sp, -24
+		0x46000000, // FPU
+		0x24020fa4, // li $v0, 4004 (write)
+		0x0000000c, // syscall
+		0x24020fbe, // li $v0, 4030 (unsupported)
+		0x00000000, // nop
+		0x0000000c, // syscall
+		0x00000034, // teq $zero, $zero
+		0x00401021, // move $v0, $v0
+		0x0000000c, // syscall, unknown syscall number
+	}
+	data := make([]byte, len(insns)*4)
+	for i, insn := range insns {
+		binary.BigEndian.PutUint32(data[i*4:], insn)
+	}
+	report := &CompatReport{}
+	checkCode(report, meta, 0x1000, data)
+
+	require.Len(t, report.Issues, 3)
+	require.Equal(t, CompatIssue{Severity: SeverityError, Kind: "instruction", Addr: 0x1004, Insn: 0x46000000, Symbol: "main.main", Reason: "FPU (COP1)"}, report.Issues[0])
+	require.Equal(t, SeverityWarning, report.Issues[1].Severity)
+	require.Equal(t, "syscall", report.Issues[1].Kind)
+	require.Equal(t, uint32(0x1018), report.Issues[1].Addr)
+	require.Contains(t, report.Issues[1].Reason, "syscall 4030")
+	require.Equal(t, SeverityWarning, report.Issues[2].Severity)
+	require.Equal(t, uint32(0x101c), report.Issues[2].Addr)
+
+	require.Len(t, report.Errors(), 1)
+	require.ErrorContains(t, report.Err(), "FPU (COP1)")
+	require.NoError(t, (&CompatReport{Issues: report.Issues[1:]}).Err(), "warnings are not errors")
+}
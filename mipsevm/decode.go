@@ -0,0 +1,283 @@
+package mipsevm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// StateWitness is the decoded form of a packed state witness, as encoded by State.EncodeWitness.
+type StateWitness struct {
+	MemRoot common.Hash `json:"memRoot"`
+
+	PreimageKey    common.Hash `json:"preimageKey"`
+	PreimageOffset uint32      `json:"preimageOffset"`
+
+	PC     HexU32 `json:"pc"`
+	NextPC HexU32 `json:"nextPC"`
+	LO     HexU32 `json:"lo"`
+	HI     HexU32 `json:"hi"`
+	Heap   HexU32 `json:"heap"`
+
+	ExitCode uint8 `json:"exit"`
+	Exited   bool  `json:"exited"`
+
+	Step uint64 `json:"step"`
+
+	Registers [32]HexU32 `json:"registers"`
+}
+
+// DecodeStateWitness decodes a packed state witness.
+func DecodeStateWitness(wit []byte) (*StateWitness, error) {
+	if len(wit) != StateWitnessSize {
+		return nil, fmt.Errorf("expected state witness of %d bytes, but got %d", StateWitnessSize, len(wit))
+	}
+	out := &StateWitness{}
+	copy(out.MemRoot[:], wit[:32])
+	copy(out.PreimageKey[:], wit[32:64])
+	wit = wit[64:]
+	u32 := func() uint32 {
+		v := binary.BigEndian.Uint32(wit[:4])
+		wit = wit[4:]
+		return v
+	}
+	out.PreimageOffset = u32()
+	out.PC = HexU32(u32())
+	out.NextPC = HexU32(u32())
+	out.LO = HexU32(u32())
+	out.HI = HexU32(u32())
+	out.Heap = HexU32(u32())
+	out.ExitCode = wit[0]
+	switch wit[1] {
+	case 0:
+	case 1:
+		out.Exited = true
+	default:
+		return nil, fmt.Errorf("invalid exited flag %d", wit[1])
+	}
+	out.Step = binary.BigEndian.Uint64(wit[2:10])
+	wit = wit[10:]
+	for i := range out.Registers {
+		out.Registers[i] = HexU32(u32())
+	}
+	return out, nil
+}
+
+// State returns the state of the witness, without memory.
+func (w *StateWitness) State() *State {
+	st := &State{
+		PreimageKey:    w.PreimageKey,
+		PreimageOffset: w.PreimageOffset,
+		PC:             uint32(w.PC),
+		NextPC:         uint32(w.NextPC),
+		LO:             uint32(w.LO),
+		HI:             uint32(w.HI),
+		Heap:           uint32(w.Heap),
+		ExitCode:       w.ExitCode,
+		Exited:         w.Exited,
+		Step:           w.Step,
+	}
+	for i, r := range w.Registers {
+		st.Registers[i] = uint32(r)
+	}
+	return st
+}
+
+// abiArgs reads ABI-encoded arguments, following a 4-byte method selector.
+type abiArgs []byte
+
+// word returns the 32-byte word at the given byte offset.
+func (a abiArgs) word(offset uint64) ([]byte, error) {
+	if offset+32 > uint64(len(a)) || offset+32 < offset {
+		return nil, fmt.Errorf("word at offset %d is out of bounds of %d bytes of calldata", offset, len(a))
+	}
+	return a[offset : offset+32], nil
+}
+
+// uint returns the 32-byte word at the given byte offset as an integer, which must fit in 64 bits.
+func (a abiArgs) uint(offset uint64) (uint64, error) {
+	w, err := a.word(offset)
+	if err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(w[:24], make([]byte, 24)) {
+		return 0, fmt.Errorf("word at offset %d does not fit in 64 bits", offset)
+	}
+	return binary.BigEndian.Uint64(w[24:]), nil
+}
+
+// arg returns the static argument with the given index.
+func (a abiArgs) arg(i uint64) ([]byte, error) {
+	return a.word(i * 32)
+}
+
+// argUint returns the static integer argument with the given index.
+func (a abiArgs) argUint(i uint64) (uint64, error) {
+	return a.uint(i * 32)
+}
+
+// argBytes returns the dynamic bytes argument with the given index.
+// The data is not required to be padded to 32 bytes, like the calldata that EncodeStepInput produces.
+func (a abiArgs) argBytes(i uint64) ([]byte, error) {
+	offset, err := a.argUint(i)
+	if err != nil {
+		return nil, err
+	}
+	size, err := a.uint(offset)
+	if err != nil {
+		return nil, err
+	}
+	start := offset + 32
+	if start+size > uint64(len(a)) || start+size < start {
+		return nil, fmt.Errorf("bytes argument %d of %d bytes is out of bounds of %d bytes of calldata", i, size, len(a))
+	}
+	return a[start : start+size], nil
+}
+
+// StepInput is the decoded form of the calldata of a MIPS step, as encoded by StepWitness.EncodeStepInput.
+type StepInput struct {
+	StateHash common.Hash   `json:"stateHash"`
+	State     *StateWitness `json:"state"`
+	// InsnProof is the memory proof of the instruction
+	InsnProof hexutil.Bytes `json:"insnProof"`
+	// MemProofs are the memory proofs of the memory accesses, in order of access
+	MemProofs []hexutil.Bytes `json:"memProofs,omitempty"`
+}
+
+// DecodeStepInput decodes the calldata of a MIPS step.
+func DecodeStepInput(input []byte) (*StepInput, error) {
+	if len(input) < 4 || !bytes.Equal(input[:4], StepBytes4) {
+		return nil, errors.New("calldata is not a MIPS step")
+	}
+	args := abiArgs(input[4:])
+	stateHash, err := args.arg(0)
+	if err != nil {
+		return nil, err
+	}
+	stateData, err := args.argBytes(1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state data: %w", err)
+	}
+	proofData, err := args.argBytes(2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proof data: %w", err)
+	}
+	out := &StepInput{StateHash: common.BytesToHash(stateHash)}
+	if h := crypto.Keccak256Hash(stateData); h != out.StateHash {
+		return nil, fmt.Errorf("state data hashes to %s, but calldata commits to %s", h, out.StateHash)
+	}
+	if out.State, err = DecodeStateWitness(stateData); err != nil {
+		return nil, err
+	}
+	if len(proofData)%(28*32) != 0 || len(proofData) == 0 {
+		return nil, fmt.Errorf("expected instruction proof and memory proofs of %d bytes each, but got %d bytes", 28*32, len(proofData))
+	}
+	out.InsnProof = proofData[:28*32]
+	for i := 28 * 32; i < len(proofData); i += 28 * 32 {
+		out.MemProofs = append(out.MemProofs, proofData[i:i+28*32])
+	}
+	return out, nil
+}
+
+// PreimageOracleInput is the decoded form of the calldata that prepares a pre-image part in the oracle contract,
+// as encoded by StepWitness.EncodePreimageOracleInput, or one of the calls of StepWitness.PlanPreimageOracleInput.
+type PreimageOracleInput struct {
+	Method string `json:"method"`
+	// PartOffset is the offset of the part, except for the absorb and finalize calls of large pre-images
+	PartOffset uint32      `json:"partOffset"`
+	Key        common.Hash `json:"key"`
+	// Part is the pre-image part that is cheated in for local pre-images, or claimed for large pre-images
+	Part hexutil.Bytes `json:"part,omitempty"`
+	// Size is the size of the pre-image, excluding the length prefix, if known by the call
+	Size uint64 `json:"size"`
+	// Preimage is the full pre-image, for keccak256 and sha256 pre-images
+	Preimage hexutil.Bytes `json:"preimage,omitempty"`
+	// Chunk is the chunk of a large pre-image, for the absorb and finalize calls of large pre-images
+	Chunk hexutil.Bytes `json:"chunk,omitempty"`
+}
+
+// decodePartOffset decodes the part offset argument with the given index.
+func decodePartOffset(args abiArgs, i uint64) (uint32, error) {
+	partOffset, err := args.argUint(i)
+	if err != nil {
+		return 0, err
+	}
+	if partOffset > uint64(^uint32(0)) {
+		return 0, fmt.Errorf("part offset %d does not fit in 32 bits", partOffset)
+	}
+	return uint32(partOffset), nil
+}
+
+// DecodePreimageOracleInput decodes the calldata that prepares a pre-image part in the oracle contract.
+func DecodePreimageOracleInput(input []byte) (*PreimageOracleInput, error) {
+	if len(input) < 4 {
+		return nil, errors.New("calldata has no method selector")
+	}
+	args := abiArgs(input[4:])
+	out := &PreimageOracleInput{}
+	var err error
+	switch {
+	case bytes.Equal(input[:4], CheatBytes4), bytes.Equal(input[:4], InitLargeKeccak256PreimageBytes4):
+		out.Method = "cheat"
+		if bytes.Equal(input[:4], InitLargeKeccak256PreimageBytes4) {
+			out.Method = "initLargeKeccak256Preimage"
+		}
+		if out.PartOffset, err = decodePartOffset(args, 0); err != nil {
+			return nil, err
+		}
+		key, err := args.arg(1)
+		if err != nil {
+			return nil, err
+		}
+		out.Key = common.BytesToHash(key)
+		if out.Part, err = args.arg(2); err != nil {
+			return nil, err
+		}
+		if out.Size, err = args.argUint(3); err != nil {
+			return nil, err
+		}
+	case bytes.Equal(input[:4], LoadKeccak256PreimagePartBytes4):
+		out.Method = "loadKeccak256PreimagePart"
+		if out.PartOffset, err = decodePartOffset(args, 0); err != nil {
+			return nil, err
+		}
+		if out.Preimage, err = args.argBytes(1); err != nil {
+			return nil, fmt.Errorf("invalid pre-image: %w", err)
+		}
+		out.Key = preimage.Keccak256Key(crypto.Keccak256Hash(out.Preimage)).PreimageKey()
+		out.Size = uint64(len(out.Preimage))
+	case bytes.Equal(input[:4], LoadSha256PreimagePartBytes4):
+		out.Method = "loadSha256PreimagePart"
+		if out.PartOffset, err = decodePartOffset(args, 0); err != nil {
+			return nil, err
+		}
+		if out.Preimage, err = args.argBytes(1); err != nil {
+			return nil, fmt.Errorf("invalid pre-image: %w", err)
+		}
+		out.Key = preimage.Sha256Key(preimage.Sha256(out.Preimage)).PreimageKey()
+		out.Size = uint64(len(out.Preimage))
+	case bytes.Equal(input[:4], AbsorbLargeKeccak256PreimageBytes4), bytes.Equal(input[:4], FinalizeLargeKeccak256PreimageBytes4):
+		out.Method = "absorbLargeKeccak256Preimage"
+		if bytes.Equal(input[:4], FinalizeLargeKeccak256PreimageBytes4) {
+			out.Method = "finalizeLargeKeccak256Preimage"
+		}
+		key, err := args.arg(0)
+		if err != nil {
+			return nil, err
+		}
+		out.Key = common.BytesToHash(key)
+		if out.Chunk, err = args.argBytes(1); err != nil {
+			return nil, fmt.Errorf("invalid chunk: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown pre-image oracle method %x", input[:4])
+	}
+	return out, nil
+}
@@ -0,0 +1,136 @@
+package mipsevm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+func TestDecodeStateWitness(t *testing.T) {
+	state := loadProgram(t, 0x24082000) // li $t0, 0x2000
+	state.PreimageKey = crypto.Keccak256Hash([]byte("key"))
+	state.PreimageOffset = 42
+	state.LO, state.HI, state.Heap = 1, 2, 0x4000_0000
+	state.ExitCode, state.Exited = 3, true
+	state.Step = 1 << 40
+	for i := range state.Registers {
+		state.Registers[i] = uint32(i) * 0x01010101
+	}
+	enc := state.EncodeWitness()
+	wit, err := DecodeStateWitness(enc)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash(state.Memory.MerkleRoot()), wit.MemRoot)
+	require.Equal(t, HexU32(state.PC), wit.PC)
+	require.Equal(t, state.Step, wit.Step)
+	require.Equal(t, HexU32(0x1f1f1f1f), wit.Registers[31])
+
+	// re-encoding the decoded state with the same memory root results in the same witness
+	require.Equal(t, enc, wit.State().encodeWitness(wit.MemRoot))
+
+	_, err = DecodeStateWitness(enc[:len(enc)-1])
+	require.ErrorContains(t, err, "expected state witness")
+	enc[32+32+4*6+1] = 2
+	_, err = DecodeStateWitness(enc)
+	require.ErrorContains(t, err, "invalid exited flag")
+}
+
+func TestDecodeStepInput(t *testing.T) {
+	data := []byte("hello world")
+	key := preimage.Keccak256Key(crypto.Keccak256Hash(data)).PreimageKey()
+	oracle := &testOracle{
+		hint:        func(v []byte) {},
+		getPreimage: func(k [32]byte) []byte { return data },
+	}
+	state := loadProgram(t,
+		0x24082000, // li $t0, 0x2000
+		0xad080004, // sw $t0, 4($t0)
+		0x24020fa3, // li $v0, 4003 (read)
+		0x24040005, // li $a0, 5 (pre-image read fd)
+		0x24053000, // li $a1, 0x3000
+		0x24060004, // li $a2, 4
+		0x0000000c, // syscall
+	)
+	state.PreimageKey = key
+	us := NewInstrumentedState(state, oracle, io.Discard, io.Discard)
+	for i := 0; i < 7; i++ {
+		wit, err := us.Step(true)
+		require.NoError(t, err)
+		input := wit.EncodeStepInput()
+		res, err := DecodeStepInput(input)
+		require.NoError(t, err, "step %d", i)
+		require.Equal(t, crypto.Keccak256Hash(wit.State), res.StateHash)
+		require.Equal(t, uint64(i), res.State.Step)
+		require.Equal(t, wit.MemProof[:28*32], []byte(res.InsnProof))
+		require.Len(t, res.MemProofs, len(wit.MemAccess))
+		for j, p := range res.MemProofs {
+			require.Equal(t, wit.MemProof[(j+1)*28*32:(j+2)*28*32], []byte(p))
+		}
+
+		if !wit.HasPreimage() {
+			continue
+		}
+		oracleInput, err := wit.EncodePreimageOracleInput()
+		require.NoError(t, err)
+		pre, err := DecodePreimageOracleInput(oracleInput)
+		require.NoError(t, err)
+		require.Equal(t, "loadKeccak256PreimagePart", pre.Method)
+		require.Equal(t, common.Hash(key), pre.Key)
+		require.Equal(t, uint64(len(data)), pre.Size)
+		require.Equal(t, data, []byte(pre.Preimage))
+	}
+
+	wit, err := us.Step(true)
+	require.NoError(t, err)
+	input := wit.EncodeStepInput()
+	_, err = DecodeStepInput(append([]byte{0, 0, 0, 0}, input[4:]...))
+	require.ErrorContains(t, err, "not a MIPS step")
+	input[4] ^= 1
+	_, err = DecodeStepInput(input)
+	require.ErrorContains(t, err, "calldata commits to")
+	_, err = DecodeStepInput(input[:len(input)-32])
+	require.ErrorContains(t, err, "out of bounds")
+}
+
+func TestDecodePreimageOracleInputLocal(t *testing.T) {
+	key := preimage.LocalIndexKey(1).PreimageKey()
+	wit := &StepWitness{
+		PreimageKey:    key,
+		PreimageValue:  append([]byte{0, 0, 0, 0, 0, 0, 0, 5}, "hello"...),
+		PreimageOffset: 4,
+	}
+	input, err := wit.EncodePreimageOracleInput()
+	require.NoError(t, err)
+	res, err := DecodePreimageOracleInput(input)
+	require.NoError(t, err)
+	require.Equal(t, "cheat", res.Method)
+	require.Equal(t, uint32(4), res.PartOffset)
+	require.Equal(t, common.Hash(key), res.Key)
+	require.Equal(t, uint64(5), res.Size)
+	require.Equal(t, append([]byte{0, 0, 0, 5, 'h', 'e', 'l', 'l', 'o'}, make([]byte, 23)...), []byte(res.Part))
+
+	_, err = DecodePreimageOracleInput(append([]byte{1, 2, 3, 4}, input[4:]...))
+	require.ErrorContains(t, err, "unknown pre-image oracle method")
+}
+
+func TestDecodePreimageOracleInputSha256(t *testing.T) {
+	data := []byte("hello world")
+	key := preimage.Sha256Key(preimage.Sha256(data)).PreimageKey()
+	wit := &StepWitness{
+		PreimageKey:    key,
+		PreimageValue:  append([]byte{0, 0, 0, 0, 0, 0, 0, byte(len(data))}, data...),
+		PreimageOffset: 8,
+	}
+	input, err := wit.EncodePreimageOracleInput()
+	require.NoError(t, err)
+	res, err := DecodePreimageOracleInput(input)
+	require.NoError(t, err)
+	require.Equal(t, "loadSha256PreimagePart", res.Method)
+	require.Equal(t, uint32(8), res.PartOffset)
+	require.Equal(t, common.Hash(key), res.Key)
+	require.Equal(t, data, []byte(res.Preimage))
+}
@@ -0,0 +1,366 @@
+package mipsevm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// regNames are the o32 ABI names of the general purpose registers.
+var regNames = [32]string{
+	"zero", "at", "v0", "v1", "a0", "a1", "a2", "a3",
+	"t0", "t1", "t2", "t3", "t4", "t5", "t6", "t7",
+	"s0", "s1", "s2", "s3", "s4", "s5", "s6", "s7",
+	"t8", "t9", "k0", "k1", "gp", "sp", "fp", "ra",
+}
+
+// operandForm describes how the operands of an instruction are laid out in the assembly syntax.
+type operandForm uint8
+
+const (
+	formNone       operandForm = iota // syscall, sync, nop
+	formRdRsRt                        // addu rd, rs, rt
+	formRdRtSa                        // sll rd, rt, sa
+	formRdRtRs                        // sllv rd, rt, rs
+	formRdRs                          // jalr rd, rs / clz rd, rs / move rd, rs
+	formRdRt                          // negu rd, rt
+	formRd                            // mfhi rd
+	formRs                            // jr rs / mthi rs
+	formRsRt                          // mult rs, rt
+	formRtRsImm                       // addiu rt, rs, simm
+	formRtRsUImm                      // andi rt, rs, uimm
+	formRtUImm                        // lui rt, uimm
+	formRtImm                         // li rt, simm
+	formRsRtBranch                    // beq rs, rt, target
+	formRsBranch                      // bltz rs, target
+	formBranch                        // b target
+	formJump                          // j target
+	formMem                           // lw rt, offset(rs)
+	formRtHwr                         // rdhwr rt, hwr
+)
+
+// Instruction is a decoded MIPS instruction, supported by the VM.
+type Instruction struct {
+	// Word is the raw instruction
+	Word uint32
+	// Mnemonic is the assembly name of the instruction.
+	// Common assembler aliases are used where they apply, e.g. nop, move, b, beqz.
+	Mnemonic string
+
+	form operandForm
+}
+
+func (ins Instruction) rs() uint32  { return (ins.Word >> 21) & 0x1F }
+func (ins Instruction) rt() uint32  { return (ins.Word >> 16) & 0x1F }
+func (ins Instruction) rd() uint32  { return (ins.Word >> 11) & 0x1F }
+func (ins Instruction) sa() uint32  { return (ins.Word >> 6) & 0x1F }
+func (ins Instruction) imm() uint32 { return ins.Word & 0xFFFF }
+
+// Target returns the destination of a branch or jump instruction at the given PC,
+// computed the same way as the VM does. The bool is false if the instruction has no static target.
+func (ins Instruction) Target(pc uint32) (uint32, bool) {
+	switch ins.form {
+	case formRsRtBranch, formRsBranch, formBranch:
+		return pc + 4 + (SE(ins.imm(), 16) << 2), true
+	case formJump:
+		// Note: the VM does not use the 256 MB region of the delay slot, see mipsStep.
+		return SE(ins.Word&0x03FFFFFF, 26) << 2, true
+	default:
+		return 0, false
+	}
+}
+
+// Format formats the instruction in assembly syntax.
+// Branch and jump targets are annotated with their symbol if the metadata is not nil.
+func (ins Instruction) Format(pc uint32, meta *Metadata) string {
+	reg := func(r uint32) string { return "$" + regNames[r] }
+	var args string
+	switch ins.form {
+	case formNone:
+	case formRdRsRt:
+		args = fmt.Sprintf("%s, %s, %s", reg(ins.rd()), reg(ins.rs()), reg(ins.rt()))
+	case formRdRtSa:
+		args = fmt.Sprintf("%s, %s, %d", reg(ins.rd()), reg(ins.rt()), ins.sa())
+	case formRdRtRs:
+		args = fmt.Sprintf("%s, %s, %s", reg(ins.rd()), reg(ins.rt()), reg(ins.rs()))
+	case formRdRs:
+		args = fmt.Sprintf("%s, %s", reg(ins.rd()), reg(ins.rs()))
+	case formRdRt:
+		args = fmt.Sprintf("%s, %s", reg(ins.rd()), reg(ins.rt()))
+	case formRd:
+		args = reg(ins.rd())
+	case formRs:
+		args = reg(ins.rs())
+	case formRsRt:
+		args = fmt.Sprintf("%s, %s", reg(ins.rs()), reg(ins.rt()))
+	case formRtRsImm:
+		args = fmt.Sprintf("%s, %s, %d", reg(ins.rt()), reg(ins.rs()), int32(SE(ins.imm(), 16)))
+	case formRtRsUImm:
+		args = fmt.Sprintf("%s, %s, 0x%x", reg(ins.rt()), reg(ins.rs()), ins.imm())
+	case formRtUImm:
+		args = fmt.Sprintf("%s, 0x%x", reg(ins.rt()), ins.imm())
+	case formRtImm:
+		args = fmt.Sprintf("%s, %d", reg(ins.rt()), int32(SE(ins.imm(), 16)))
+	case formRsRtBranch, formRsBranch, formBranch, formJump:
+		target, _ := ins.Target(pc)
+		args = formatTarget(target, meta)
+		if ins.form == formRsRtBranch {
+			args = fmt.Sprintf("%s, %s, %s", reg(ins.rs()), reg(ins.rt()), args)
+		} else if ins.form == formRsBranch {
+			args = fmt.Sprintf("%s, %s", reg(ins.rs()), args)
+		}
+	case formMem:
+		args = fmt.Sprintf("%s, %d(%s)", reg(ins.rt()), int32(SE(ins.imm(), 16)), reg(ins.rs()))
+	case formRtHwr:
+		args = fmt.Sprintf("%s, $%d", reg(ins.rt()), ins.rd())
+	}
+	if args == "" {
+		return ins.Mnemonic
+	}
+	return fmt.Sprintf("%-7s %s", ins.Mnemonic, args)
+}
+
+func formatTarget(target uint32, meta *Metadata) string {
+	if meta == nil {
+		return fmt.Sprintf("0x%08x", target)
+	}
+	name, offset, ok := meta.LookupSymbolOffset(target)
+	if !ok {
+		return fmt.Sprintf("0x%08x", target)
+	}
+	if offset == 0 {
+		return fmt.Sprintf("0x%08x <%s>", target, name)
+	}
+	return fmt.Sprintf("0x%08x <%s+0x%x>", target, name, offset)
+}
+
+var specialNames = map[uint32]struct {
+	name string
+	form operandForm
+}{
+	0x00: {"sll", formRdRtSa},
+	0x02: {"srl", formRdRtSa},
+	0x03: {"sra", formRdRtSa},
+	0x04: {"sllv", formRdRtRs},
+	0x06: {"srlv", formRdRtRs},
+	0x07: {"srav", formRdRtRs},
+	0x08: {"jr", formRs},
+	0x09: {"jalr", formRdRs},
+	0x0a: {"movz", formRdRsRt},
+	0x0b: {"movn", formRdRsRt},
+	0x0c: {"syscall", formNone},
+	0x0f: {"sync", formNone}, // executed as no-op
+	0x10: {"mfhi", formRd},
+	0x11: {"mthi", formRs},
+	0x12: {"mflo", formRd},
+	0x13: {"mtlo", formRs},
+	0x18: {"mult", formRsRt},
+	0x19: {"multu", formRsRt},
+	0x1a: {"div", formRsRt},
+	0x1b: {"divu", formRsRt},
+	0x20: {"add", formRdRsRt},
+	0x21: {"addu", formRdRsRt},
+	0x22: {"sub", formRdRsRt},
+	0x23: {"subu", formRdRsRt},
+	0x24: {"and", formRdRsRt},
+	0x25: {"or", formRdRsRt},
+	0x26: {"xor", formRdRsRt},
+	0x27: {"nor", formRdRsRt},
+	0x2a: {"slt", formRdRsRt},
+	0x2b: {"sltu", formRdRsRt},
+}
+
+var opcodeNames = map[uint32]struct {
+	name string
+	form operandForm
+}{
+	0x02: {"j", formJump},
+	0x03: {"jal", formJump},
+	0x04: {"beq", formRsRtBranch},
+	0x05: {"bne", formRsRtBranch},
+	0x06: {"blez", formRsBranch},
+	0x07: {"bgtz", formRsBranch},
+	0x08: {"addi", formRtRsImm},
+	0x09: {"addiu", formRtRsImm},
+	0x0a: {"slti", formRtRsImm},
+	0x0b: {"sltiu", formRtRsImm},
+	0x0c: {"andi", formRtRsUImm},
+	0x0d: {"ori", formRtRsUImm},
+	0x0e: {"xori", formRtRsUImm},
+	0x0f: {"lui", formRtUImm},
+	0x20: {"lb", formMem},
+	0x21: {"lh", formMem},
+	0x22: {"lwl", formMem},
+	0x23: {"lw", formMem},
+	0x24: {"lbu", formMem},
+	0x25: {"lhu", formMem},
+	0x26: {"lwr", formMem},
+	0x28: {"sb", formMem},
+	0x29: {"sh", formMem},
+	0x2a: {"swl", formMem},
+	0x2b: {"sw", formMem},
+	0x2e: {"swr", formMem},
+	0x30: {"ll", formMem},
+	0x38: {"sc", formMem},
+}
+
+// unsupportedSpecial names SPECIAL functions that are valid MIPS, but not supported by the VM.
+var unsupportedSpecial = map[uint32]string{
+	0x01: "FPU conditional move movf/movt",
+	0x0d: "break",
+	0x30: "trap tge",
+	0x31: "trap tgeu",
+	0x32: "trap tlt",
+	0x33: "trap tltu",
+	0x34: "trap teq",
+	0x36: "trap tne",
+}
+
+// unsupportedRegimm names REGIMM instructions that are valid MIPS, but not supported by the VM.
+var unsupportedRegimm = map[uint32]string{
+	0x02: "branch-likely bltzl",
+	0x03: "branch-likely bgezl",
+	0x08: "trap tgei",
+	0x09: "trap tgeiu",
+	0x0a: "trap tlti",
+	0x0b: "trap tltiu",
+	0x0c: "trap teqi",
+	0x0e: "trap tnei",
+	0x10: "bltzal",
+	0x11: "bgezal",
+	0x12: "branch-likely bltzall",
+	0x13: "branch-likely bgezall",
+}
+
+// unsupportedOpcodes names primary opcodes that are valid MIPS, but not supported by the VM.
+var unsupportedOpcodes = map[uint32]string{
+	0x10: "coprocessor 0 (COP0)",
+	0x11: "FPU (COP1)",
+	0x12: "coprocessor 2 (COP2)",
+	0x13: "FPU (COP1X)",
+	0x14: "branch-likely beql",
+	0x15: "branch-likely bnel",
+	0x16: "branch-likely blezl",
+	0x17: "branch-likely bgtzl",
+	0x1f: "SPECIAL3",
+	0x2f: "cache",
+	0x31: "FPU lwc1",
+	0x32: "lwc2",
+	0x33: "pref",
+	0x35: "FPU ldc1",
+	0x36: "ldc2",
+	0x39: "FPU swc1",
+	0x3a: "swc2",
+	0x3d: "FPU sdc1",
+	0x3e: "sdc2",
+}
+
+// UnsupportedInstructionError is returned when decoding an instruction that the VM cannot execute.
+type UnsupportedInstructionError struct {
+	Word   uint32
+	Reason string
+}
+
+func (e *UnsupportedInstructionError) Error() string {
+	return fmt.Sprintf("unsupported instruction %08x: %s", e.Word, e.Reason)
+}
+
+func unsupported(insn uint32, format string, args ...any) (Instruction, error) {
+	return Instruction{Word: insn}, &UnsupportedInstructionError{Word: insn, Reason: fmt.Sprintf(format, args...)}
+}
+
+// DecodeInstruction decodes an instruction word.
+// An *UnsupportedInstructionError is returned if the VM does not support the instruction.
+func DecodeInstruction(insn uint32) (Instruction, error) {
+	opcode := insn >> 26
+	fun := insn & 0x3F
+	rs := (insn >> 21) & 0x1F
+	rt := (insn >> 16) & 0x1F
+	switch opcode {
+	case 0: // SPECIAL
+		if insn == 0 {
+			return Instruction{Word: insn, Mnemonic: "nop", form: formNone}, nil
+		}
+		entry, ok := specialNames[fun]
+		if !ok {
+			if name, ok := unsupportedSpecial[fun]; ok {
+				return unsupported(insn, "%s", name)
+			}
+			return unsupported(insn, "unknown SPECIAL function 0x%02x", fun)
+		}
+		out := Instruction{Word: insn, Mnemonic: entry.name, form: entry.form}
+		switch {
+		case (fun == 0x21 || fun == 0x25) && rt == 0: // addu/or with $zero
+			out.Mnemonic, out.form = "move", formRdRs
+		case (fun == 0x21 || fun == 0x25) && rs == 0:
+			out.Mnemonic, out.form = "move", formRdRt
+		case fun == 0x23 && rs == 0: // subu from $zero
+			out.Mnemonic, out.form = "negu", formRdRt
+		case fun == 0x27 && rt == 0: // nor with $zero
+			out.Mnemonic, out.form = "not", formRdRs
+		case fun == 0x09 && (insn>>11)&0x1F == 31: // jalr with default link register
+			out.form = formRs
+		}
+		return out, nil
+	case 1: // REGIMM
+		switch rt {
+		case 0:
+			return Instruction{Word: insn, Mnemonic: "bltz", form: formRsBranch}, nil
+		case 1:
+			return Instruction{Word: insn, Mnemonic: "bgez", form: formRsBranch}, nil
+		}
+		if name, ok := unsupportedRegimm[rt]; ok {
+			return unsupported(insn, "%s", name)
+		}
+		return unsupported(insn, "unknown REGIMM instruction 0x%02x", rt)
+	case 0x1c: // SPECIAL2
+		switch fun {
+		case 0x02:
+			return Instruction{Word: insn, Mnemonic: "mul", form: formRdRsRt}, nil
+		case 0x20:
+			return Instruction{Word: insn, Mnemonic: "clz", form: formRdRs}, nil
+		case 0x21:
+			return Instruction{Word: insn, Mnemonic: "clo", form: formRdRs}, nil
+		}
+		return unsupported(insn, "unknown SPECIAL2 function 0x%02x", fun)
+	case 0x1f: // SPECIAL3
+		if fun == 0x3b && (insn>>11)&0x1F == 29 { // only the thread pointer hardware register is supported
+			return Instruction{Word: insn, Mnemonic: "rdhwr", form: formRtHwr}, nil
+		}
+		return unsupported(insn, "SPECIAL3")
+	}
+	entry, ok := opcodeNames[opcode]
+	if !ok {
+		if name, ok := unsupportedOpcodes[opcode]; ok {
+			return unsupported(insn, "%s", name)
+		}
+		return unsupported(insn, "unknown opcode 0x%02x", opcode)
+	}
+	out := Instruction{Word: insn, Mnemonic: entry.name, form: entry.form}
+	switch {
+	case opcode == 0x04 && rs == 0 && rt == 0:
+		out.Mnemonic, out.form = "b", formBranch
+	case opcode == 0x04 && rt == 0:
+		out.Mnemonic, out.form = "beqz", formRsBranch
+	case opcode == 0x05 && rt == 0:
+		out.Mnemonic, out.form = "bnez", formRsBranch
+	case opcode == 0x09 && rs == 0: // addiu from $zero
+		out.Mnemonic, out.form = "li", formRtImm
+	}
+	return out, nil
+}
+
+// Disassemble formats the instruction at the given PC in assembly syntax.
+// Branch and jump targets are annotated with their symbol if the metadata is not nil.
+// Instructions that are not supported by the VM are formatted as raw data, with the reason as comment.
+func Disassemble(pc uint32, insn uint32, meta *Metadata) string {
+	ins, err := DecodeInstruction(insn)
+	if err != nil {
+		reason := err.Error()
+		var unsupportedErr *UnsupportedInstructionError
+		if errors.As(err, &unsupportedErr) {
+			reason = unsupportedErr.Reason
+		}
+		return fmt.Sprintf(".word   0x%08x # unsupported: %s", insn, reason)
+	}
+	return ins.Format(pc, meta)
+}
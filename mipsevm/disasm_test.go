@@ -0,0 +1,71 @@
+package mipsevm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisassemble(t *testing.T) {
+	meta := &Metadata{Symbols: []Symbol{
+		{Name: "main.main", Start: 0x1000, Size: 0x100},
+		{Name: "main.helper", Start: 0x1100, Size: 0x40},
+	}}
+	cases := []struct {
+		pc       uint32
+		insn     uint32
+		expected string
+	}{
+		{0x1000, 0x27bdffe8, "addiu   $sp, $sp, -24"},
+		{0x1000, 0x00000000, "nop"},
+		{0x1000, 0x02002021, "move    $a0, $s0"},
+		{0x1000, 0x001f1825, "move    $v1, $ra"},
+		{0x1000, 0x03e00008, "jr      $ra"},
+		{0x1000, 0x0320f809, "jalr    $t9"},
+		{0x1000, 0x8fb90008, "lw      $t9, 8($sp)"},
+		{0x1000, 0xafbf0014, "sw      $ra, 20($sp)"},
+		{0x1000, 0x3c011234, "lui     $at, 0x1234"},
+		{0x1000, 0x342100ff, "ori     $at, $at, 0xff"},
+		{0x1000, 0x00094080, "sll     $t0, $t1, 2"},
+		{0x1000, 0x0000000c, "syscall"},
+		{0x1000, 0x0000000f, "sync"},
+		{0x1000, 0x00001010, "mfhi    $v0"},
+		{0x1000, 0x00850018, "mult    $a0, $a1"},
+		{0x1000, 0x70851002, "mul     $v0, $a0, $a1"},
+		{0x1000, 0x70801020, "clz     $v0, $a0"},
+		{0x1000, 0x24020fa4, "li      $v0, 4004"},
+		{0x1000, 0x10850004, "beq     $a0, $a1, 0x00001014 <main.main+0x14>"},
+		{0x1000, 0x1000ffff, "b       0x00001000 <main.main>"},
+		{0x1000, 0x14800002, "bnez    $a0, 0x0000100c <main.main+0xc>"},
+		{0x1000, 0x04800001, "bltz    $a0, 0x00001008 <main.main+0x8>"},
+		{0x1000, 0x0c000440, "jal     0x00001100 <main.helper>"},
+		{0x1000, 0x08000800, "j       0x00002000"},
+		{0x1000, 0x01000034, ".word   0x01000034 # unsupported: trap teq"},
+		{0x1000, 0x46000000, ".word   0x46000000 # unsupported: FPU (COP1)"},
+		{0x1000, 0x7c03e83b, "rdhwr   $v1, $29"},
+		{0x1000, 0x7c02003b, ".word   0x7c02003b # unsupported: SPECIAL3"},
+	}
+	for _, c := range cases {
+		require.Equal(t, c.expected, Disassemble(c.pc, c.insn, meta), "disassemble %08x", c.insn)
+	}
+	require.Equal(t, "jal     0x00001100", Disassemble(0x1000, 0x0c000440, nil), "no symbols without metadata")
+}
+
+func TestDecodeInstruction(t *testing.T) {
+	// every supported instruction must be decoded, and branch/jump targets must match the VM
+	ins, err := DecodeInstruction(0x10850004)
+	require.NoError(t, err)
+	target, ok := ins.Target(0x1000)
+	require.True(t, ok)
+	require.Equal(t, uint32(0x1014), target)
+
+	ins, err = DecodeInstruction(0x27bdffe8)
+	require.NoError(t, err)
+	_, ok = ins.Target(0x1000)
+	require.False(t, ok, "no target for ALU instructions")
+
+	_, err = DecodeInstruction(0x0000000d)
+	var unsupportedErr *UnsupportedInstructionError
+	require.ErrorAs(t, err, &unsupportedErr)
+	require.Equal(t, "break", unsupportedErr.Reason)
+}
@@ -24,6 +24,12 @@ var (
 	StepBytes4                      = crypto.Keccak256([]byte("Step(bytes32,bytes,bytes)"))[:4]
 	CheatBytes4                     = crypto.Keccak256([]byte("cheat(uint256,bytes32,bytes32,uint256)"))[:4]
 	LoadKeccak256PreimagePartBytes4 = crypto.Keccak256([]byte("loadKeccak256PreimagePart(uint256,bytes)"))[:4]
+	LoadSha256PreimagePartBytes4    = crypto.Keccak256([]byte("loadSha256PreimagePart(uint256,bytes)"))[:4]
+	ReadPreimageBytes4              = crypto.Keccak256([]byte("readPreimage(bytes32,uint256)"))[:4]
+
+	InitLargeKeccak256PreimageBytes4     = crypto.Keccak256([]byte("initLargeKeccak256Preimage(uint256,bytes32,bytes32,uint256)"))[:4]
+	AbsorbLargeKeccak256PreimageBytes4   = crypto.Keccak256([]byte("absorbLargeKeccak256Preimage(bytes32,bytes)"))[:4]
+	FinalizeLargeKeccak256PreimageBytes4 = crypto.Keccak256([]byte("finalizeLargeKeccak256Preimage(bytes32,bytes)"))[:4]
 )
 
 func LoadContracts() (*Contracts, error) {
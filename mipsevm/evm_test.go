@@ -12,9 +12,12 @@ import (
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
 	"github.com/ethereum/go-ethereum/core/vm"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
 )
 
 func testContractsSetup(t *testing.T) (*Contracts, *Addresses, *SourceMapTracer) {
@@ -120,7 +123,7 @@ func TestHelloEVM(t *testing.T) {
 
 	err = PatchGo(elfProgram, state)
 	require.NoError(t, err, "apply Go runtime patches")
-	require.NoError(t, PatchStack(state), "add initial stack")
+	require.NoError(t, PatchStack(state, nil), "add initial stack")
 
 	var stdOutBuf, stdErrBuf bytes.Buffer
 	us := NewInstrumentedState(state, nil, io.MultiWriter(&stdOutBuf, os.Stdout), io.MultiWriter(&stdErrBuf, os.Stderr))
@@ -187,7 +190,7 @@ func TestClaimEVM(t *testing.T) {
 
 	err = PatchGo(elfProgram, state)
 	require.NoError(t, err, "apply Go runtime patches")
-	require.NoError(t, PatchStack(state), "add initial stack")
+	require.NoError(t, PatchStack(state, nil), "add initial stack")
 
 	oracle, expectedStdOut, expectedStdErr := claimTestOracle(t)
 
@@ -242,3 +245,104 @@ func TestClaimEVM(t *testing.T) {
 	require.Equal(t, expectedStdOut, stdOutBuf.String(), "stdout")
 	require.Equal(t, expectedStdErr, stdErrBuf.String(), "stderr")
 }
+
+func TestLibcEVM(t *testing.T) {
+	contracts, addrs, tracer := testContractsSetup(t)
+
+	state := loadLibcProgram(t, writeLibcTestELF(t))
+	var stdOutBuf bytes.Buffer
+	us := NewInstrumentedState(state, nil, io.MultiWriter(&stdOutBuf, os.Stdout), os.Stderr)
+
+	env, evmState := NewEVMEnv(contracts, addrs)
+	env.Config.Debug = false
+	env.Config.Tracer = tracer
+
+	for i := 0; i < 100 && !state.Exited; i++ {
+		stepWitness, err := us.Step(true)
+		require.NoError(t, err)
+		input := stepWitness.EncodeStepInput()
+		startingGas := uint64(30_000_000)
+
+		snap := env.StateDB.Snapshot()
+		ret, leftOverGas, err := env.Call(vm.AccountRef(addrs.Sender), addrs.MIPS, input, startingGas, big.NewInt(0))
+		require.NoErrorf(t, err, "evm should not fail, took %d gas", startingGas-leftOverGas)
+		require.Len(t, ret, 32, "expecting 32-byte state hash")
+		logs := evmState.Logs()
+		require.Equal(t, 1, len(logs), "expecting a log with post-state")
+		evmPost := logs[0].Data
+		env.StateDB.RevertToSnapshot(snap)
+
+		require.Equal(t, hexutil.Bytes(us.state.EncodeWitness()).String(), hexutil.Bytes(evmPost).String(),
+			"unicorn produced different state than EVM")
+	}
+
+	require.True(t, state.Exited, "must complete program")
+	require.Equal(t, uint8(0), state.ExitCode, "exit with 0")
+	require.Equal(t, "hello, world\n", stdOutBuf.String())
+}
+
+func TestLargePreimageEVM(t *testing.T) {
+	contracts, addrs, _ := testContractsSetup(t)
+	data := make([]byte, 100_000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	key := preimage.Keccak256Key(crypto.Keccak256Hash(data)).PreimageKey()
+	limits := PreimageLoadLimits{MaxCalldata: 120_000, MaxGas: 10_000_000}
+	for _, offset := range []uint32{0, 4, 8, 50_000, uint32(len(data)) - 4} {
+		env, _ := NewEVMEnv(contracts, addrs)
+		wit := preimageWitness(key, data, offset)
+		calls, err := wit.PlanPreimageOracleInput(limits)
+		require.NoError(t, err)
+		require.Greater(t, len(calls), 2, "loaded in chunks")
+		gasUsed, err := SimulatePreimageOracleInput(env, addrs, wit, calls)
+		require.NoError(t, err)
+		t.Logf("loaded part at offset %d with %d calls, using %v gas", offset, len(calls), gasUsed)
+		for i, input := range calls {
+			intrinsic, err := core.IntrinsicGas(input, nil, false, true, true, true)
+			require.NoError(t, err)
+			require.LessOrEqualf(t, gasUsed[i]+intrinsic, limits.MaxGas, "call %d of %d exceeds the gas limit", i, len(calls))
+		}
+		// the absorb calls must stay within the estimate that the chunk size is derived from
+		for i := 1; i < len(calls)-1; i++ {
+			blocks := uint64(len(calls[i])-largePreimageChunkOverhead) / keccakRate
+			require.LessOrEqualf(t, gasUsed[i], oracleStorageGas+blocks*largePreimageBlockGas, "absorb call %d exceeds the estimate", i)
+			t.Logf("absorb call %d used %d gas per block", i, gasUsed[i]/blocks)
+		}
+	}
+
+	// a claimed part that does not match the pre-image is rejected
+	env, _ := NewEVMEnv(contracts, addrs)
+	wit := preimageWitness(key, data, 50_000)
+	calls, err := wit.PlanPreimageOracleInput(limits)
+	require.NoError(t, err)
+	calls[0][4+32*2] ^= 1 // first byte of the claimed part
+	_, err = SimulatePreimageOracleInput(env, addrs, wit, calls)
+	require.ErrorContains(t, err, "execution reverted")
+}
+
+func TestSha256PreimageEVM(t *testing.T) {
+	contracts, addrs, _ := testContractsSetup(t)
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	key := preimage.Sha256Key(preimage.Sha256(data)).PreimageKey()
+	for _, offset := range []uint32{0, 4, 8, 40, uint32(len(data)) + 8 - 4} {
+		env, _ := NewEVMEnv(contracts, addrs)
+		wit := preimageWitness(key, data, offset)
+		input, err := wit.EncodePreimageOracleInput()
+		require.NoError(t, err)
+		_, err = SimulatePreimageOracleInput(env, addrs, wit, [][]byte{input})
+		require.NoErrorf(t, err, "part at offset %d", offset)
+	}
+
+	// a pre-image that does not match the sha256 key is not available by that key
+	env, _ := NewEVMEnv(contracts, addrs)
+	wit := preimageWitness(key, data, 8)
+	input, err := wit.EncodePreimageOracleInput()
+	require.NoError(t, err)
+	input[len(input)-1] ^= 1 // last byte of the pre-image
+	_, err = SimulatePreimageOracleInput(env, addrs, wit, [][]byte{input})
+	require.ErrorContains(t, err, "execution reverted")
+}
@@ -0,0 +1,89 @@
+package mipsevm
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// decodedInsn holds the fields of an instruction that mipsStep needs,
+// so instructions that run often are not re-decoded every step.
+type decodedInsn struct {
+	insn   uint32
+	opcode uint32
+	fun    uint32
+	rsReg  uint32
+	rtReg  uint32
+	// rdReg is the register the result is written to: rd for R-type, and rt otherwise
+	rdReg uint32
+	// imm is the immediate, sign-extended or zero-extended as the instruction requires.
+	// For j/jal it is the jump target.
+	imm uint32
+	// rtIsReg is true if the rt operand is read from the rt register, rather than from the immediate
+	rtIsReg bool
+	valid   bool
+}
+
+func decodeInsn(insn uint32) decodedInsn {
+	d := decodedInsn{
+		insn:   insn,
+		opcode: insn >> 26,
+		fun:    insn & 0x3f,
+		rsReg:  (insn >> 21) & 0x1F,
+		rtReg:  (insn >> 16) & 0x1F,
+		valid:  true,
+	}
+	d.rdReg = d.rtReg
+	switch {
+	case d.opcode == 2 || d.opcode == 3:
+		d.imm = SE(insn&0x03FFFFFF, 26) << 2
+	case d.opcode == 0 || d.opcode == 0x1c:
+		// R-type (stores rd)
+		d.rtIsReg = true
+		d.rdReg = (insn >> 11) & 0x1F
+	case d.opcode < 0x20:
+		// don't sign extend for andi, ori, xori
+		if d.opcode == 0xC || d.opcode == 0xD || d.opcode == 0xe {
+			d.imm = insn & 0xFFFF
+		} else {
+			d.imm = SE(insn&0xFFFF, 16)
+		}
+	default:
+		d.imm = SE(insn&0xFFFF, 16)
+		// store rt value with store, and actual rt with lwl and lwr
+		d.rtIsReg = d.opcode >= 0x28 || d.opcode == 0x22 || d.opcode == 0x26
+	}
+	return d
+}
+
+// decoded returns the decoded instruction at the given address within the page,
+// decoding it first if it was not cached, or if it was invalidated by a write.
+func (p *CachedPage) decoded(pageAddr uint32) *decodedInsn {
+	if p.insns == nil {
+		p.insns = new([PageSize / 4]decodedInsn)
+	}
+	d := &p.insns[pageAddr>>2]
+	if !d.valid {
+		*d = decodeInsn(binary.BigEndian.Uint32(p.Data[pageAddr : pageAddr+4]))
+	}
+	return d
+}
+
+// zeroInsn is the decoded instruction of unallocated memory
+var zeroInsn = decodeInsn(0)
+
+// fetch returns the decoded instruction at the given address.
+// The page of the last fetch is remembered, to avoid a page lookup for most instructions.
+func (m *InstrumentedState) fetch(pc uint32) *decodedInsn {
+	if pc&0x3 != 0 {
+		panic(fmt.Errorf("unaligned memory access: %x", pc))
+	}
+	pageIndex := pc >> PageAddrSize
+	if m.fetchPage == nil || m.fetchPageIndex != pageIndex || m.fetchMem != m.state.Memory {
+		p, ok := m.state.Memory.getPage(pageIndex)
+		if !ok {
+			return &zeroInsn
+		}
+		m.fetchPage, m.fetchPageIndex, m.fetchMem = p, pageIndex, m.state.Memory
+	}
+	return m.fetchPage.decoded(pc & PageAddrMask)
+}
@@ -0,0 +1,69 @@
+package mipsevm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func loadProgram(t testing.TB, insns ...uint32) *State {
+	dat := make([]byte, len(insns)*4)
+	for i, insn := range insns {
+		binary.BigEndian.PutUint32(dat[i*4:], insn)
+	}
+	state := &State{PC: 0x1000, NextPC: 0x1004, Memory: NewMemory()}
+	require.NoError(t, state.Memory.SetMemoryRange(0x1000, bytes.NewReader(dat)))
+	return state
+}
+
+func TestInsnCacheInvalidation(t *testing.T) {
+	state := loadProgram(t,
+		0x24020001, // li $v0, 1
+		0x3c032442, // lui $v1, 0x2442  (upper half of: addiu $v0, $v0, 2)
+		0x34630002, // ori $v1, $v1, 2
+		0x1000fffc, // b 0x1000 (loop back once the code is patched)
+		0x00000000, // nop (delay slot)
+	)
+	us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+	for i := 0; i < 5; i++ {
+		_, err := us.Step(false)
+		require.NoError(t, err)
+	}
+	require.Equal(t, uint32(0x1000), state.PC)
+	require.Equal(t, uint32(1), state.Registers[2])
+
+	// overwrite the first instruction with "addiu $v0, $v0, 2", after it has been cached
+	state.Memory.SetMemory(0x1000, state.Registers[3])
+	_, err := us.Step(false)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), state.Registers[2], "must run the new instruction")
+
+	// overwrite the page as a whole
+	require.NoError(t, state.Memory.SetMemoryRange(0x1000, bytes.NewReader([]byte{0x24, 0x02, 0x00, 0x07}))) // li $v0, 7
+	state.PC, state.NextPC = 0x1000, 0x1004
+	_, err = us.Step(false)
+	require.NoError(t, err)
+	require.Equal(t, uint32(7), state.Registers[2], "must run the new instruction")
+}
+
+func BenchmarkStep(b *testing.B) {
+	state := loadProgram(b,
+		0x24840001, // addiu $a0, $a0, 1
+		0x8fa50000, // lw $a1, 0($sp)
+		0x00a43021, // addu $a2, $a1, $a0
+		0xafa60000, // sw $a2, 0($sp)
+		0x1000fffb, // b 0x1000
+		0x00000000, // nop (delay slot)
+	)
+	state.Registers[29] = 0x7000
+	us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := us.Step(false); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package mipsevm
 
 import (
+	"fmt"
 	"io"
 )
 
@@ -15,9 +16,13 @@ type InstrumentedState struct {
 	stdOut io.Writer
 	stdErr io.Writer
 
-	lastMemAccess   uint32
 	memProofEnabled bool
-	memProof        [28 * 32]byte
+	// addresses of the memory words accessed by the current step, in order, with repeated accesses of a word merged
+	memAccess []uint32
+	// memory proof of each memory access, taken right before the access
+	memProofs [][28 * 32]byte
+	// called before each memory access that needs its own memory proof, used to verify the memory proofs of a step
+	memAccessHook func(addr uint32)
 
 	preimageOracle PreimageOracle
 
@@ -27,6 +32,11 @@ type InstrumentedState struct {
 	lastPreimageKey [32]byte
 	// offset we last read from, or max uint32 if nothing is read this step
 	lastPreimageOffset uint32
+
+	// page of the last instruction fetch
+	fetchMem       *Memory
+	fetchPage      *CachedPage
+	fetchPageIndex uint32
 }
 
 const (
@@ -39,6 +49,44 @@ const (
 	fdPreimageWrite = 6
 )
 
+// Linux MIPS o32 syscall numbers, as handled by the VM.
+// Any other syscall is a no-op, returning 0.
+const (
+	sysExit          = 4001
+	sysRead          = 4003
+	sysWrite         = 4004
+	sysBrk           = 4045
+	sysFcntl         = 4055
+	sysMmap          = 4090
+	sysClone         = 4120
+	sysWritev        = 4146
+	sysMmap2         = 4210
+	sysExitGroup     = 4246
+	sysSetThreadArea = 4283
+)
+
+// Kernel words are memory words outside of the user address space,
+// that hold the state of syscalls which is not part of the VM state itself.
+// Like all other memory, they are proven with the memory proof of the step that accesses them.
+const (
+	// BrkAddr holds the current program break.
+	// If zero, brk always returns the legacy fixed BrkStart, as expected by the Go runtime.
+	BrkAddr = 0xFF_FF_FF_F0
+	// TLSAddr holds the thread pointer, as set by set_thread_area and read by rdhwr $29.
+	TLSAddr = 0xFF_FF_FF_F4
+)
+
+const (
+	// BrkStart is the program break returned by brk when no brk-based heap is set up.
+	BrkStart = 0x40_00_00_00
+	// HeapStart is the start of the mmap heap. A brk-based heap may grow up to it.
+	HeapStart = 0x20_00_00_00
+)
+
+// maxIovcnt is the maximum number of iovecs of a writev syscall.
+// Each iovec needs its own memory proof onchain, to read the iov_len word.
+const maxIovcnt = 16
+
 const (
 	MipsEBADF  = 0x9
 	MipsEINVAL = 0x16
@@ -53,9 +101,20 @@ func NewInstrumentedState(state *State, po PreimageOracle, stdOut, stdErr io.Wri
 	}
 }
 
+// ReplayHint sends the last complete hint of the state to the pre-image oracle again, if there is any.
+// A VM that starts from a snapshot calls it before stepping, so the oracle is prepared for the pre-image requests
+// that follow the hint. Only the last hint is repeated: a program that still reads pre-images of an earlier hint
+// needs a pre-image server that serves them without the hint.
+func (m *InstrumentedState) ReplayHint() {
+	if len(m.state.PrevHint) > 0 {
+		m.preimageOracle.Hint(m.state.PrevHint)
+	}
+}
+
 func (m *InstrumentedState) Step(proof bool) (wit *StepWitness, err error) {
 	m.memProofEnabled = proof
-	m.lastMemAccess = ^uint32(0)
+	m.memAccess = m.memAccess[:0]
+	m.memProofs = m.memProofs[:0]
 	m.lastPreimageOffset = ^uint32(0)
 
 	if proof {
@@ -71,7 +130,10 @@ func (m *InstrumentedState) Step(proof bool) (wit *StepWitness, err error) {
 	}
 
 	if proof {
-		wit.MemProof = append(wit.MemProof, m.memProof[:]...)
+		for _, p := range m.memProofs {
+			wit.MemProof = append(wit.MemProof, p[:]...)
+		}
+		wit.MemAccess = append([]uint32(nil), m.memAccess...)
 		if m.lastPreimageOffset != ^uint32(0) {
 			wit.PreimageOffset = m.lastPreimageOffset
 			wit.PreimageKey = m.lastPreimageKey
@@ -80,3 +142,60 @@ func (m *InstrumentedState) Step(proof bool) (wit *StepWitness, err error) {
 	}
 	return
 }
+
+// StopReason describes why RunUntil stopped.
+type StopReason uint8
+
+const (
+	// StoppedMaxSteps is returned when the maximum number of steps was executed.
+	StoppedMaxSteps StopReason = iota
+	// StoppedExited is returned when the program exited.
+	StoppedExited
+	// StoppedCondition is returned when the stop condition was met.
+	StoppedCondition
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case StoppedMaxSteps:
+		return "max-steps"
+	case StoppedExited:
+		return "exited"
+	case StoppedCondition:
+		return "condition"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(r))
+	}
+}
+
+// StopCondition is checked by RunUntil before every step. Execution stops before the step if it returns true.
+type StopCondition func(st *State) bool
+
+// StopAtPC returns a StopCondition that holds when the PC is in the range [start, end).
+func StopAtPC(start uint32, end uint32) StopCondition {
+	return func(st *State) bool {
+		return st.PC >= start && st.PC < end
+	}
+}
+
+// RunUntil runs up to maxSteps steps without generating witness data, until the program exits,
+// or until the optional condition holds. It returns the number of steps that were executed, and why it stopped.
+// If an error is returned, the step that failed is not included in the number of steps.
+func (m *InstrumentedState) RunUntil(maxSteps uint64, cond StopCondition) (steps uint64, reason StopReason, err error) {
+	m.memProofEnabled = false
+	for ; steps < maxSteps; steps++ {
+		if m.state.Exited {
+			return steps, StoppedExited, nil
+		}
+		if cond != nil && cond(m.state) {
+			return steps, StoppedCondition, nil
+		}
+		if err := m.mipsStep(); err != nil {
+			return steps, 0, err
+		}
+	}
+	if m.state.Exited {
+		return steps, StoppedExited, nil
+	}
+	return steps, StoppedMaxSteps, nil
+}
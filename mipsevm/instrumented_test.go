@@ -0,0 +1,131 @@
+package mipsevm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunUntil(t *testing.T) {
+	newState := func() *State {
+		return loadProgram(t,
+			0x24840001, // addiu $a0, $a0, 1
+			0x1000fffe, // b 0x1000
+			0x00000000, // nop (delay slot)
+		)
+	}
+
+	t.Run("max steps", func(t *testing.T) {
+		state := newState()
+		us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+		n, reason, err := us.RunUntil(30, nil)
+		require.NoError(t, err)
+		require.Equal(t, uint64(30), n)
+		require.Equal(t, StoppedMaxSteps, reason)
+		require.Equal(t, uint64(30), state.Step)
+		require.Equal(t, uint32(10), state.Registers[4])
+	})
+
+	t.Run("condition", func(t *testing.T) {
+		state := newState()
+		us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+		n, reason, err := us.RunUntil(1000, func(st *State) bool {
+			return st.Registers[4] == 5
+		})
+		require.NoError(t, err)
+		require.Equal(t, uint64(13), n)
+		require.Equal(t, StoppedCondition, reason)
+
+		n, reason, err = us.RunUntil(1000, StopAtPC(0x1008, 0x100c))
+		require.NoError(t, err)
+		require.Equal(t, StoppedCondition, reason, "stops before the step at the PC")
+		require.Equal(t, uint32(0x1008), state.PC)
+		require.Equal(t, uint64(1), n)
+	})
+
+	t.Run("exited", func(t *testing.T) {
+		state := loadProgram(t,
+			0x24020fa1, // li $v0, 4001 (exit)
+			0x0000000c, // syscall
+		)
+		us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+		n, reason, err := us.RunUntil(1000, nil)
+		require.NoError(t, err)
+		require.Equal(t, uint64(2), n)
+		require.Equal(t, StoppedExited, reason)
+		require.True(t, state.Exited)
+	})
+
+	t.Run("matches stepping", func(t *testing.T) {
+		a, b := newState(), newState()
+		usA := NewInstrumentedState(a, nil, os.Stdout, os.Stderr)
+		usB := NewInstrumentedState(b, nil, os.Stdout, os.Stderr)
+		_, _, err := usA.RunUntil(100, nil)
+		require.NoError(t, err)
+		for i := 0; i < 100; i++ {
+			_, err := usB.Step(false)
+			require.NoError(t, err)
+		}
+		require.Equal(t, b.EncodeWitness(), a.EncodeWitness())
+	})
+}
+
+func TestTrackMemAccess(t *testing.T) {
+	state := &State{Memory: NewMemory()}
+	us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+	us.memProofEnabled = true
+	roots := [][32]byte{state.Memory.MerkleRoot()}
+	// accesses of different words each get their own proof, taken right before the access
+	for i, addr := range []uint32{0x1000, 0x1000, 0x2004, 0x1000} {
+		us.trackMemAccess(addr)
+		state.Memory.SetMemory(addr, uint32(i+1))
+		roots = append(roots, state.Memory.MerkleRoot())
+	}
+	require.Equal(t, []uint32{0x1000, 0x2004, 0x1000}, us.memAccess)
+	require.Len(t, us.memProofs, 3)
+	// each proof commits to the memory as changed by the earlier accesses
+	require.Equal(t, roots[0], proofRoot(us.memProofs[0][:], 0x1000))
+	require.Equal(t, roots[2], proofRoot(us.memProofs[1][:], 0x2004))
+	require.Equal(t, roots[3], proofRoot(us.memProofs[2][:], 0x1000))
+	require.Equal(t, uint32(2), proofWord(us.memProofs[2][:], 0x1000))
+}
+
+func TestHintWrite(t *testing.T) {
+	var hints []string
+	oracle := &testOracle{hint: func(v []byte) {
+		hints = append(hints, string(v))
+	}}
+	state := &State{PC: 0x1000, NextPC: 0x1004, Memory: NewMemory()}
+	us := NewInstrumentedState(state, oracle, os.Stdout, os.Stderr)
+	// write the hint data with the write syscall, to the hint fd
+	write := func(dat []byte) {
+		require.NoError(t, state.Memory.SetMemoryRange(0x2000, bytes.NewReader(dat)))
+		state.Registers[2] = sysWrite
+		state.Registers[4] = fdHintWrite
+		state.Registers[5] = 0x2000
+		state.Registers[6] = uint32(len(dat))
+		require.NoError(t, us.handleSyscall())
+		require.Equal(t, uint32(len(dat)), state.Registers[2])
+	}
+	hint := func(v string) []byte {
+		return append(binary.BigEndian.AppendUint32(nil, uint32(len(v))), v...)
+	}
+
+	// multiple hints in a single write
+	write(append(hint("hello"), hint("world")...))
+	require.Equal(t, []string{"hello", "world"}, hints)
+	require.Empty(t, state.LastHint)
+
+	// a hint that is split across writes is processed once complete
+	dat := hint("split hint")
+	write(dat[:2])
+	write(dat[2:7])
+	require.Len(t, hints, 2)
+	require.Equal(t, dat[:7], []byte(state.LastHint), "incomplete hint is buffered")
+	write(dat[7:])
+	require.Equal(t, []string{"hello", "world", "split hint"}, hints)
+	require.Empty(t, state.LastHint)
+}
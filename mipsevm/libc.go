@@ -0,0 +1,89 @@
+package mipsevm
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+)
+
+// Auxiliary vector entry types used by libc startup code, as defined by Linux.
+const (
+	AtPhdr  = 3
+	AtPhent = 4
+	AtPhnum = 5
+	AtEntry = 9
+)
+
+// ProgramBreak returns the initial program break of the ELF program:
+// the page-aligned end of the highest loaded segment, i.e. the end of the BSS.
+func ProgramBreak(f *elf.File) (uint32, error) {
+	end := uint64(0)
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD && prog.Vaddr+prog.Memsz > end {
+			end = prog.Vaddr + prog.Memsz
+		}
+	}
+	if end == 0 {
+		return 0, errors.New("no loadable program segments")
+	}
+	end = (end + PageSize - 1) &^ (PageSize - 1)
+	if end > HeapStart {
+		return 0, fmt.Errorf("program break %08x is above the heap start %08x", end, HeapStart)
+	}
+	return uint32(end), nil
+}
+
+// PatchBrk sets up a brk-based heap, starting at the program break of the ELF program,
+// and growing up to HeapStart, where mmap allocations start.
+// Without this patch brk always returns BrkStart, which the Go runtime does not rely on.
+func PatchBrk(f *elf.File, st *State) error {
+	brk, err := ProgramBreak(f)
+	if err != nil {
+		return fmt.Errorf("failed to determine program break: %w", err)
+	}
+	st.Memory.SetMemory(BrkAddr, brk)
+	return nil
+}
+
+// LibcAuxv returns the auxiliary vector entries that libc startup code, like the one of musl,
+// uses to find the program headers, e.g. to initialize thread-local storage from PT_TLS.
+func LibcAuxv(f *elf.File) ([]AuxvEntry, error) {
+	var phdr uint64
+	found := false
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_PHDR {
+			phdr, found = prog.Vaddr, true
+			break
+		}
+	}
+	if !found { // the program headers may be loaded as part of the segment that starts with the ELF header
+		for _, prog := range f.Progs {
+			if prog.Type != elf.PT_LOAD || prog.Off != 0 {
+				continue
+			}
+			var hdr [52]byte // ELF32 header
+			if _, err := prog.ReadAt(hdr[:], 0); err != nil {
+				return nil, fmt.Errorf("failed to read ELF header from first segment: %w", err)
+			}
+			phoff := uint64(f.ByteOrder.Uint32(hdr[28:32]))
+			if phentsize := f.ByteOrder.Uint16(hdr[42:44]); phentsize != 32 {
+				return nil, fmt.Errorf("unexpected ELF32 program header size %d", phentsize)
+			}
+			if end := phoff + 32*uint64(len(f.Progs)); end > prog.Filesz {
+				return nil, fmt.Errorf("program headers at file offset %d to %d are not loaded by the first segment of %d bytes",
+					phoff, end, prog.Filesz)
+			}
+			phdr, found = prog.Vaddr+phoff, true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.New("program headers are not loaded into memory")
+	}
+	return []AuxvEntry{
+		{Type: AtPhdr, Value: uint32(phdr)},
+		{Type: AtPhent, Value: 32}, // size of ELF32 program header
+		{Type: AtPhnum, Value: uint32(len(f.Progs))},
+		{Type: AtEntry, Value: uint32(f.Entry)},
+	}, nil
+}
@@ -0,0 +1,277 @@
+package mipsevm
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// runProgram executes the instructions, starting at 0x1000, until the PC leaves the program.
+func runProgram(t *testing.T, state *State, insns ...uint32) {
+	dat := make([]byte, len(insns)*4)
+	for i, insn := range insns {
+		binary.BigEndian.PutUint32(dat[i*4:], insn)
+	}
+	state.PC = 0x1000
+	state.NextPC = 0x1004
+	require.NoError(t, state.Memory.SetMemoryRange(0x1000, bytes.NewReader(dat)))
+	us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+	for state.PC >= 0x1000 && state.PC < 0x1000+uint32(len(dat)) && !state.Exited {
+		wit, err := us.Step(true)
+		require.NoError(t, err)
+		require.Len(t, wit.MemProof, (1+len(wit.MemAccess))*28*32, "instruction and memory proofs")
+	}
+}
+
+func TestBrk(t *testing.T) {
+	brk := func(state *State, a0 uint32) uint32 {
+		state.Registers[2] = sysBrk
+		state.Registers[4] = a0
+		runProgram(t, state, 0x0000000c) // syscall
+		return state.Registers[2]
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		state := &State{Memory: NewMemory(), Heap: HeapStart}
+		require.Equal(t, uint32(BrkStart), brk(state, 0))
+		require.Equal(t, uint32(BrkStart), brk(state, 0x1234_0000))
+		require.Equal(t, uint32(0), state.Memory.GetMemory(BrkAddr))
+	})
+
+	t.Run("heap", func(t *testing.T) {
+		state := &State{Memory: NewMemory(), Heap: HeapStart}
+		state.Memory.SetMemory(BrkAddr, 0x0050_0000)
+		require.Equal(t, uint32(0x0050_0000), brk(state, 0), "query")
+		require.Equal(t, uint32(0x0052_0000), brk(state, 0x0052_0000), "grow")
+		require.Equal(t, uint32(0x0052_0000), brk(state, 0x0051_0000), "no shrink")
+		require.Equal(t, uint32(0x0052_0000), brk(state, HeapStart+4), "no growth into mmap heap")
+		require.Equal(t, uint32(0x0052_0000), state.Memory.GetMemory(BrkAddr))
+	})
+}
+
+func TestThreadPointer(t *testing.T) {
+	state := &State{Memory: NewMemory()}
+	state.Registers[4] = 0x1234_7000
+	runProgram(t, state,
+		0x240210bb, // li $v0, 4283 (set_thread_area)
+		0x0000000c, // syscall
+		0x7c03e83b, // rdhwr $v1, $29
+	)
+	require.Equal(t, uint32(0x1234_7000), state.Memory.GetMemory(TLSAddr))
+	require.Equal(t, uint32(0x1234_7000), state.Registers[3])
+}
+
+func TestExit(t *testing.T) {
+	state := &State{Memory: NewMemory()}
+	state.Registers[4] = 3
+	runProgram(t, state,
+		0x24020fa1, // li $v0, 4001 (exit)
+		0x0000000c, // syscall
+	)
+	require.True(t, state.Exited)
+	require.Equal(t, uint8(3), state.ExitCode)
+}
+
+func TestWritev(t *testing.T) {
+	// writev runs the syscall, and checks that only the iov_len words are proven
+	writev := func(fd uint32, iovcnt uint32, proven int) (state *State, stdout string) {
+		state = &State{Memory: NewMemory()}
+		// iovecs at 0x2000, pointing to "hello, " and "world\n"
+		require.NoError(t, state.Memory.SetMemoryRange(0x3000, bytes.NewReader([]byte("hello, world\n"))))
+		state.Memory.SetMemory(0x2000, 0x3000)
+		state.Memory.SetMemory(0x2004, 7)
+		state.Memory.SetMemory(0x2008, 0x3007)
+		state.Memory.SetMemory(0x200c, 6)
+		state.Registers[2] = sysWritev
+		state.Registers[4] = fd
+		state.Registers[5] = 0x2000
+		state.Registers[6] = iovcnt
+		state.PC = 0x1000
+		state.NextPC = 0x1004
+		state.Memory.SetMemory(0x1000, 0x0000000c) // syscall
+		var out bytes.Buffer
+		us := NewInstrumentedState(state, nil, &out, io.Discard)
+		wit, err := us.Step(true)
+		require.NoError(t, err)
+		require.Len(t, wit.MemProof, (1+len(wit.MemAccess))*28*32, "instruction and memory proofs")
+		require.Len(t, wit.MemAccess, proven)
+		return state, out.String()
+	}
+
+	state, out := writev(fdStdout, 2, 2)
+	require.Equal(t, "hello, world\n", out)
+	require.Equal(t, uint32(13), state.Registers[2])
+	require.Equal(t, uint32(0), state.Registers[7])
+
+	state, out = writev(fdStdout, 1, 1)
+	require.Equal(t, "hello, ", out)
+	require.Equal(t, uint32(7), state.Registers[2])
+
+	state, _ = writev(fdPreimageWrite, 2, 0)
+	require.Equal(t, uint32(0xFFffFFff), state.Registers[2])
+	require.Equal(t, uint32(MipsEBADF), state.Registers[7])
+
+	state, _ = writev(fdStdout, maxIovcnt+1, 0)
+	require.Equal(t, uint32(0xFFffFFff), state.Registers[2])
+	require.Equal(t, uint32(MipsEINVAL), state.Registers[7])
+}
+
+// libcTestProgram writes like the __stdio_write of musl: it calls writev until all data is written, and retries on a
+// short write. An unsupported writev returns 0, and hangs the program.
+var libcTestProgram = []uint32{
+	0x2410000d, // li $s0, 13 (remaining)
+	0x24021032, // loop: li $v0, 4146 (writev)
+	0x24040001, // li $a0, 1 (stdout)
+	0x3c050040, // lui $a1, 0x40
+	0x34a50210, // ori $a1, $a1, 0x210 (iovecs)
+	0x24060002, // li $a2, 2
+	0x0000000c, // syscall
+	0x14e00007, // bnez $a3, fail
+	0x00000000, // nop
+	0x02028023, // subu $s0, $s0, $v0
+	0x1600fff6, // bnez $s0, loop
+	0x00000000, // nop
+	0x24021096, // li $v0, 4246 (exit_group)
+	0x24040000, // li $a0, 0
+	0x0000000c, // syscall
+	0x24021096, // fail: li $v0, 4246 (exit_group)
+	0x24040001, // li $a0, 1
+	0x0000000c, // syscall
+}
+
+// writeLibcTestELF writes a statically linked ELF file with the libcTestProgram,
+// with a single segment at 0x400000 that includes the ELF header and program headers, like the first segment of musl programs.
+func writeLibcTestELF(t *testing.T) string {
+	const (
+		vaddr      = 0x40_0000
+		textOffset = 0x100
+		dataOffset = 0x200
+		bssSize    = 0x1000
+	)
+	img := make([]byte, dataOffset+0x20)
+	for i, insn := range libcTestProgram {
+		binary.BigEndian.PutUint32(img[textOffset+i*4:], insn)
+	}
+	copy(img[dataOffset:], "hello, ")
+	copy(img[dataOffset+8:], "world\n")
+	for i, v := range []uint32{vaddr + dataOffset, 7, vaddr + dataOffset + 8, 6} {
+		binary.BigEndian.PutUint32(img[dataOffset+0x10+i*4:], v)
+	}
+
+	var buf bytes.Buffer
+	hdr := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_MIPS),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     vaddr + textOffset,
+		Phoff:     52,
+		Ehsize:    52,
+		Phentsize: 32,
+		Phnum:     1,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, hdr))
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, elf.Prog32{
+		Type:   uint32(elf.PT_LOAD),
+		Vaddr:  vaddr,
+		Paddr:  vaddr,
+		Filesz: uint32(len(img)),
+		Memsz:  uint32(len(img)) + bssSize,
+		Flags:  uint32(elf.PF_R | elf.PF_W | elf.PF_X),
+		Align:  PageSize,
+	}))
+	copy(img, buf.Bytes())
+
+	p := filepath.Join(t.TempDir(), "libc.elf")
+	require.NoError(t, os.WriteFile(p, img, 0644))
+	return p
+}
+
+// loadLibcProgram loads the ELF file with the libc loader profile.
+func loadLibcProgram(t *testing.T, path string) *State {
+	f, err := elf.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	state, err := LoadELF(f)
+	require.NoError(t, err, "load ELF into state")
+	require.NoError(t, PatchBrk(f, state))
+	auxv, err := LibcAuxv(f)
+	require.NoError(t, err)
+	cfg := DefaultStackConfig()
+	cfg.Auxv = append(auxv, cfg.Auxv...)
+	require.NoError(t, PatchStack(state, cfg))
+	return state
+}
+
+func TestLibcAuxv(t *testing.T) {
+	img, err := os.ReadFile(writeLibcTestELF(t))
+	require.NoError(t, err)
+	// auxv returns the auxiliary vector of the test ELF file, with its program header moved to phoff
+	auxv := func(phoff uint32) ([]AuxvEntry, error) {
+		img := append([]byte(nil), img...)
+		if end := int(phoff) + 32; end > len(img) {
+			img = append(img, make([]byte, end-len(img))...)
+		}
+		copy(img[phoff:phoff+32], img[52:52+32])
+		binary.BigEndian.PutUint32(img[28:], phoff)
+		f, err := elf.NewFile(bytes.NewReader(img))
+		require.NoError(t, err)
+		return LibcAuxv(f)
+	}
+
+	res, err := auxv(52)
+	require.NoError(t, err)
+	require.Contains(t, res, AuxvEntry{Type: AtPhdr, Value: 0x40_0000 + 52})
+	require.Contains(t, res, AuxvEntry{Type: AtPhnum, Value: 1})
+	res, err = auxv(0x80)
+	require.NoError(t, err)
+	require.Contains(t, res, AuxvEntry{Type: AtPhdr, Value: 0x40_0000 + 0x80}, "program headers found by e_phoff")
+
+	_, err = auxv(uint32(len(img)))
+	require.ErrorContains(t, err, "are not loaded by the first segment")
+}
+
+func TestLibcProgram(t *testing.T) {
+	state := loadLibcProgram(t, writeLibcTestELF(t))
+	require.Equal(t, uint32(0x40_2000), state.Memory.GetMemory(BrkAddr), "program break after the BSS")
+
+	var stdOutBuf bytes.Buffer
+	us := NewInstrumentedState(state, nil, &stdOutBuf, os.Stderr)
+	for i := 0; i < 100 && !state.Exited; i++ {
+		wit, err := us.Step(true)
+		require.NoError(t, err)
+		require.Len(t, wit.MemProof, (1+len(wit.MemAccess))*28*32, "instruction and memory proofs")
+	}
+	require.True(t, state.Exited, "must complete program")
+	require.Equal(t, uint8(0), state.ExitCode, "exit with 0")
+	require.Equal(t, "hello, world\n", stdOutBuf.String())
+}
+
+func TestLibcHello(t *testing.T) {
+	const path = "../example/bin/libc.elf"
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		t.Skip("musl example is not built: run make libc in the example directory, with a MIPS musl cross-compiler")
+	}
+	state := loadLibcProgram(t, path)
+
+	var stdOutBuf, stdErrBuf bytes.Buffer
+	us := NewInstrumentedState(state, nil, io.MultiWriter(&stdOutBuf, os.Stdout), io.MultiWriter(&stdErrBuf, os.Stderr))
+	for i := 0; i < 1_000_000 && !state.Exited; i++ {
+		_, err := us.Step(false)
+		require.NoError(t, err)
+	}
+	require.True(t, state.Exited, "must complete program")
+	require.Equal(t, uint8(0), state.ExitCode, "exit with 0")
+	require.Equal(t, "hello libc!\n", stdOutBuf.String(), "stdout says hello")
+	require.Equal(t, "", stdErrBuf.String(), "stderr silent")
+}
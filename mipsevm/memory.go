@@ -3,11 +3,16 @@ package mipsevm
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/bits"
+	"runtime"
 	"sort"
+	"sync"
+	"sync/atomic"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
 )
 
@@ -38,23 +43,130 @@ var zeroHashes = func() [256][32]byte {
 }()
 
 type Memory struct {
-	// generalized index -> merkle root or nil if invalidated
-	Nodes map[uint64]*[32]byte
+	// intermediate merkle tree nodes above the pages
+	tree *memTree
 
-	// pageIndex -> cached page
+	// pageIndex -> cached page, for all loaded pages
 	Pages map[uint32]*CachedPage
 
+	// pageIndex -> merkle root, for pages that are allocated but not loaded yet.
+	// These are loaded from the store on first access, but their root is used without loading them.
+	lazy  map[uint32]common.Hash
+	store PageStore
+
 	// Note: since we don't de-alloc pages, we don't do ref-counting.
 	// Once a page exists, it doesn't leave memory
 }
 
 func NewMemory() *Memory {
 	return &Memory{
-		Nodes: make(map[uint64]*[32]byte),
+		tree:  new(memTree),
 		Pages: make(map[uint32]*CachedPage),
 	}
 }
 
+// NewLazyMemory returns an empty memory that pages can be added to with AddLazyPage.
+func NewLazyMemory(store PageStore) *Memory {
+	m := NewMemory()
+	m.lazy = make(map[uint32]common.Hash)
+	m.store = store
+	return m
+}
+
+// AddLazyPage allocates a page, that is only loaded from the store on first access.
+// Until then, the merkle root of the page is used as-is, without loading the page.
+// The page must be in the store, since loading it on access cannot fail.
+func (m *Memory) AddLazyPage(pageIndex uint32, root common.Hash) error {
+	if m.store == nil {
+		return errors.New("memory has no page store to load pages from")
+	}
+	if _, ok := m.lookupPage(pageIndex, false); ok {
+		return fmt.Errorf("page %d already exists", pageIndex)
+	}
+	if root == (common.Hash{}) {
+		return fmt.Errorf("page %d has no merkle root", pageIndex)
+	}
+	if ok, err := m.store.HasPage(root); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("page %d: page %s: %w", pageIndex, root, ErrPageNotFound)
+	}
+	m.lazy[pageIndex] = root
+	m.tree.alloc(pageIndex)
+	return nil
+}
+
+// PageCount returns the number of allocated pages, whether they are loaded or not.
+func (m *Memory) PageCount() int {
+	return len(m.Pages) + len(m.lazy)
+}
+
+// PageLoadError is a failure to load a lazy page from the store, e.g. since the page was deleted or corrupted.
+// Memory access cannot return an error, so it panics with a *PageLoadError instead, for the caller to recover.
+type PageLoadError struct {
+	Index uint32
+	Err   error
+}
+
+func (e *PageLoadError) Error() string {
+	return fmt.Sprintf("failed to load page %d: %v", e.Index, e.Err)
+}
+
+func (e *PageLoadError) Unwrap() error {
+	return e.Err
+}
+
+// lookupPage returns the page, and loads it from the store if it is not loaded yet and load is true.
+// The returned page is nil if the page is allocated but not loaded.
+// Loading panics with a *PageLoadError if the page cannot be loaded from the store.
+func (m *Memory) lookupPage(pageIndex uint32, load bool) (*CachedPage, bool) {
+	if p, ok := m.Pages[pageIndex]; ok {
+		return p, true
+	}
+	if _, ok := m.lazy[pageIndex]; !ok {
+		return nil, false
+	}
+	if !load {
+		return nil, true
+	}
+	p, err := m.loadPage(pageIndex)
+	if err != nil {
+		panic(err)
+	}
+	return p, true
+}
+
+// loadPage loads the lazy page from the store.
+func (m *Memory) loadPage(pageIndex uint32) (*CachedPage, error) {
+	root := m.lazy[pageIndex]
+	data, err := m.store.GetPage(root)
+	if err != nil {
+		return nil, &PageLoadError{Index: pageIndex, Err: err}
+	}
+	p := &CachedPage{Data: data}
+	if r := common.Hash(p.MerkleRoot()); r != root {
+		return nil, &PageLoadError{Index: pageIndex, Err: fmt.Errorf("loaded page has root %s, expected %s", r, root)}
+	}
+	m.Pages[pageIndex] = p
+	delete(m.lazy, pageIndex)
+	return p, nil
+}
+
+// getPage returns the page, loading it if necessary.
+func (m *Memory) getPage(pageIndex uint32) (*CachedPage, bool) {
+	return m.lookupPage(pageIndex, true)
+}
+
+// loadAll loads all pages that are not loaded yet.
+func (m *Memory) loadAll() error {
+	for pageIndex := range m.lazy {
+		if _, err := m.loadPage(pageIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *Memory) Invalidate(addr uint32) {
 	// addr must be aligned to 4 bytes
 	if addr&0x3 != 0 {
@@ -62,17 +174,12 @@ func (m *Memory) Invalidate(addr uint32) {
 	}
 
 	// find page, and invalidate addr within it
-	if p, ok := m.Pages[addr>>PageAddrSize]; ok {
+	if p, ok := m.getPage(addr >> PageAddrSize); ok {
 		p.Invalidate(addr & PageAddrMask)
 	}
 
-	// find the gindex of the first page covering the address
-	gindex := ((uint64(1) << 32) | uint64(addr)) >> PageAddrSize
-
-	for gindex > 0 {
-		m.Nodes[gindex] = nil
-		gindex >>= 1
-	}
+	// The page node itself is merkleized by the page, invalidate the nodes above it.
+	m.tree.invalidate(addr>>PageAddrSize, false)
 }
 
 func (m *Memory) MerkleizeSubtree(gindex uint64) [32]byte {
@@ -83,32 +190,40 @@ func (m *Memory) MerkleizeSubtree(gindex uint64) [32]byte {
 	if l > PageKeySize {
 		depthIntoPage := l - 1 - PageKeySize
 		pageIndex := (gindex >> depthIntoPage) & PageKeyMask
-		if p, ok := m.Pages[uint32(pageIndex)]; ok {
+		if depthIntoPage == 0 {
+			if root, ok := m.lazy[uint32(pageIndex)]; ok {
+				return root // use the root of the page, without loading it
+			}
+		}
+		if p, ok := m.getPage(uint32(pageIndex)); ok {
 			pageGindex := (1 << depthIntoPage) | (gindex & ((1 << depthIntoPage) - 1))
 			return p.MerkleizeSubtree(pageGindex)
 		} else {
 			return zeroHashes[28-l] // page does not exist
 		}
 	}
-	if l > PageKeySize+1 {
-		panic("cannot jump into intermediate node of page")
-	}
-	n, ok := m.Nodes[gindex]
-	if !ok {
+	n, ok := m.tree.node(gindex)
+	if n == nil {
 		// if the node doesn't exist, the whole sub-tree is zeroed
 		return zeroHashes[28-l]
 	}
-	if n != nil {
+	if *ok {
 		return *n
 	}
 	left := m.MerkleizeSubtree(gindex << 1)
 	right := m.MerkleizeSubtree((gindex << 1) | 1)
-	r := HashPair(left, right)
-	m.Nodes[gindex] = &r
-	return r
+	if left == zeroHashes[28-l-1] && right == zeroHashes[28-l-1] {
+		*n = zeroHashes[28-l]
+	} else {
+		*n = HashPair(left, right)
+	}
+	*ok = true
+	return *n
 }
 
 func (m *Memory) MerkleProof(addr uint32) (out [28 * 32]byte) {
+	// the proof covers the whole tree, hash the dirty pages upfront
+	m.merkleizeDirtyPages()
 	proof := m.traverseBranch(1, addr, 0)
 	// encode the proof
 	for i := 0; i < 28; i++ {
@@ -138,9 +253,60 @@ func (m *Memory) traverseBranch(parent uint64, addr uint32, depth uint8) (proof
 }
 
 func (m *Memory) MerkleRoot() [32]byte {
+	m.merkleizeDirtyPages()
 	return m.MerkleizeSubtree(1)
 }
 
+// parallelMerkleMinPages is the minimum number of dirty pages to hash them in parallel.
+// Fewer pages are hashed serially, as part of the tree merkleization.
+const parallelMerkleMinPages = 64
+
+// dirtyPages appends the allocated pages below the given node that need to be merkleized.
+// Only invalidated nodes are traversed: all pages below a valid node are valid.
+func (m *Memory) dirtyPages(gindex uint64, out []*CachedPage) []*CachedPage {
+	if gindex >= 1<<PageKeySize {
+		if p, ok := m.Pages[uint32(gindex&PageKeyMask)]; ok && !p.Ok[1] {
+			out = append(out, p)
+		}
+		return out
+	}
+	if _, ok := m.tree.node(gindex); ok == nil || *ok {
+		return out
+	}
+	out = m.dirtyPages(gindex<<1, out)
+	return m.dirtyPages((gindex<<1)|1, out)
+}
+
+// merkleizeDirtyPages hashes the dirty pages across a pool of workers,
+// such that merkleizing the upper levels of the tree only combines cached page roots.
+// Pages are merkleized independently, the result matches serial merkleization.
+func (m *Memory) merkleizeDirtyPages() {
+	pages := m.dirtyPages(1, nil)
+	if len(pages) < parallelMerkleMinPages {
+		return
+	}
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(pages) {
+		workers = len(pages)
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				j := next.Add(1) - 1
+				if j >= int64(len(pages)) {
+					return
+				}
+				pages[j].MerkleRoot()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
 func (m *Memory) SetMemory(addr uint32, v uint32) {
 	// addr must be aligned to 4 bytes
 	if addr&0x3 != 0 {
@@ -149,7 +315,7 @@ func (m *Memory) SetMemory(addr uint32, v uint32) {
 
 	pageIndex := addr >> PageAddrSize
 	pageAddr := addr & PageAddrMask
-	p, ok := m.Pages[pageIndex]
+	p, ok := m.getPage(pageIndex)
 	if !ok {
 		// allocate the page if we have not already.
 		// Go may mmap relatively large ranges, but we only allocate the pages just in time.
@@ -165,7 +331,7 @@ func (m *Memory) GetMemory(addr uint32) uint32 {
 	if addr&0x3 != 0 {
 		panic(fmt.Errorf("unaligned memory access: %x", addr))
 	}
-	p, ok := m.Pages[addr>>PageAddrSize]
+	p, ok := m.getPage(addr >> PageAddrSize)
 	if !ok {
 		return 0
 	}
@@ -176,12 +342,7 @@ func (m *Memory) GetMemory(addr uint32) uint32 {
 func (m *Memory) AllocPage(pageIndex uint32) *CachedPage {
 	p := &CachedPage{Data: new(Page)}
 	m.Pages[pageIndex] = p
-	// make nodes to root
-	k := (1 << PageKeySize) | uint64(pageIndex)
-	for k > 0 {
-		m.Nodes[k] = nil
-		k >>= 1
-	}
+	m.tree.alloc(pageIndex)
 	return p
 }
 
@@ -191,6 +352,9 @@ type pageEntry struct {
 }
 
 func (m *Memory) MarshalJSON() ([]byte, error) {
+	if err := m.loadAll(); err != nil {
+		return nil, err
+	}
 	pages := make([]pageEntry, 0, len(m.Pages))
 	for k, p := range m.Pages {
 		pages = append(pages, pageEntry{
@@ -209,13 +373,15 @@ func (m *Memory) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, &pages); err != nil {
 		return err
 	}
-	m.Nodes = make(map[uint64]*[32]byte)
+	m.tree = new(memTree)
 	m.Pages = make(map[uint32]*CachedPage)
+	m.lazy = nil
+	m.store = nil
 	for i, p := range pages {
 		if _, ok := m.Pages[p.Index]; ok {
 			return fmt.Errorf("cannot load duplicate page, entry %d, page index %d", i, p.Index)
 		}
-		m.Pages[p.Index] = &CachedPage{Data: p.Data}
+		m.AllocPage(p.Index).Data = p.Data
 	}
 	return nil
 }
@@ -224,9 +390,11 @@ func (m *Memory) SetMemoryRange(addr uint32, r io.Reader) error {
 	for {
 		pageIndex := addr >> PageAddrSize
 		pageAddr := addr & PageAddrMask
-		p, ok := m.Pages[pageIndex]
+		p, ok := m.getPage(pageIndex)
 		if !ok {
 			p = m.AllocPage(pageIndex)
+		} else {
+			m.Invalidate(pageIndex << PageAddrSize) // invalidate the branch of the page
 		}
 		p.InvalidateFull()
 		n, err := r.Read(p.Data[pageAddr:])
@@ -262,7 +430,7 @@ func (r *memReader) Read(dest []byte) (n int, err error) {
 	if pageIndex == (endAddr >> PageAddrSize) {
 		end = endAddr & PageAddrMask
 	}
-	p, ok := r.m.Pages[pageIndex]
+	p, ok := r.m.getPage(pageIndex)
 	if ok {
 		n = copy(dest, p.Data[start:end])
 	} else {
@@ -6,6 +6,9 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"io"
+	"math/bits"
+	mathrand "math/rand"
+	"sort"
 	"strings"
 	"testing"
 
@@ -110,6 +113,93 @@ func TestMemoryMerkleRoot(t *testing.T) {
 	})
 }
 
+// referenceMerkleize merkleizes the subtree at the given gindex from scratch,
+// from the page data and the sorted list of allocated pages.
+func referenceMerkleize(m *Memory, pages []uint32, gindex uint64) [32]byte {
+	l := uint64(bits.Len64(gindex))
+	if l > PageKeySize {
+		depthIntoPage := l - 1 - PageKeySize
+		pageIndex := uint32((gindex >> depthIntoPage) & PageKeyMask)
+		p, ok := m.Pages[pageIndex]
+		if !ok {
+			return zeroHashes[28-l]
+		}
+		fresh := &CachedPage{Data: p.Data}
+		return fresh.MerkleizeSubtree((1 << depthIntoPage) | (gindex & ((1 << depthIntoPage) - 1)))
+	}
+	// range of pages covered by the subtree
+	depthToPages := PageKeySize + 1 - l
+	start := uint32((gindex << depthToPages) & PageKeyMask)
+	end := uint64(start) + (1 << depthToPages)
+	i := sort.Search(len(pages), func(i int) bool { return pages[i] >= start })
+	if i == len(pages) || uint64(pages[i]) >= end {
+		return zeroHashes[28-l]
+	}
+	return HashPair(referenceMerkleize(m, pages, gindex<<1), referenceMerkleize(m, pages, (gindex<<1)|1))
+}
+
+func TestMemoryMerkleReference(t *testing.T) {
+	rng := mathrand.New(mathrand.NewSource(1234))
+	m := NewMemory()
+	for i := 0; i < 200; i++ {
+		var addr uint32
+		switch rng.Intn(3) {
+		case 0: // anywhere
+			addr = rng.Uint32() &^ 3
+		case 1: // few neighbouring pages
+			addr = 0x10000 + uint32(rng.Intn(4*PageSize))&^3
+		case 2: // top of memory
+			addr = (^uint32(0) - uint32(rng.Intn(PageSize))) &^ 3
+		}
+		m.SetMemory(addr, rng.Uint32()&uint32(rng.Intn(2)-1))
+		if i%10 != 0 {
+			continue
+		}
+		pages := make([]uint32, 0, len(m.Pages))
+		for k := range m.Pages {
+			pages = append(pages, k)
+		}
+		sort.Slice(pages, func(i, j int) bool { return pages[i] < pages[j] })
+
+		require.Equal(t, referenceMerkleize(m, pages, 1), m.MerkleRoot(), "root after %d writes", i+1)
+		proofAddr := addr
+		if rng.Intn(2) == 0 {
+			proofAddr = rng.Uint32() &^ 3
+		}
+		proof := m.MerkleProof(proofAddr)
+		gindex := ((uint64(1) << 32) | uint64(proofAddr)) >> 5
+		require.Equal(t, referenceMerkleize(m, pages, gindex), *(*[32]byte)(proof[:32]), "proof leaf")
+		for j := 1; j < 28; j++ {
+			require.Equal(t, referenceMerkleize(m, pages, gindex^1), *(*[32]byte)(proof[j*32 : (j+1)*32]), "proof sibling %d", j)
+			gindex >>= 1
+		}
+	}
+}
+
+func TestMemoryParallelMerkle(t *testing.T) {
+	rng := mathrand.New(mathrand.NewSource(1234))
+	data := make([]byte, 1000*PageSize)
+	rng.Read(data)
+	parallel, serial := NewMemory(), NewMemory()
+	require.NoError(t, parallel.SetMemoryRange(0x1000_0000, bytes.NewReader(data)))
+	require.NoError(t, serial.SetMemoryRange(0x1000_0000, bytes.NewReader(data)))
+	require.Len(t, parallel.dirtyPages(1, nil), len(parallel.Pages))
+	require.Equal(t, serial.MerkleizeSubtree(1), parallel.MerkleRoot(), "all pages dirty")
+	require.Empty(t, parallel.dirtyPages(1, nil))
+
+	for i := 0; i < 300; i++ {
+		addr := 0x1000_0000 + uint32(rng.Intn(len(data)))&^3
+		v := rng.Uint32()
+		parallel.SetMemory(addr, v)
+		serial.SetMemory(addr, v)
+	}
+	require.GreaterOrEqual(t, len(parallel.dirtyPages(1, nil)), parallelMerkleMinPages)
+	proof := parallel.MerkleProof(0x1000_0000)
+	require.Empty(t, parallel.dirtyPages(1, nil))
+	require.Equal(t, serial.MerkleizeSubtree(1), parallel.MerkleRoot(), "some pages dirty")
+	require.Equal(t, serial.MerkleProof(0x1000_0000), proof)
+}
+
 func TestMemoryReadWrite(t *testing.T) {
 
 	t.Run("large random", func(t *testing.T) {
@@ -136,6 +226,14 @@ func TestMemoryReadWrite(t *testing.T) {
 		require.Equal(t, make([]byte, 10), res[len(res)-10:], "empty end")
 	})
 
+	t.Run("overwrite range", func(t *testing.T) {
+		m := NewMemory()
+		require.NoError(t, m.SetMemoryRange(0x1000, bytes.NewReader(make([]byte, 8))))
+		root := m.MerkleRoot()
+		require.NoError(t, m.SetMemoryRange(0x1000, bytes.NewReader([]byte{1, 2, 3, 4})))
+		require.NotEqual(t, root, m.MerkleRoot(), "merkle root must be updated")
+	})
+
 	t.Run("read-write", func(t *testing.T) {
 		m := NewMemory()
 		m.SetMemory(12, 0xAABBCCDD)
@@ -186,4 +284,33 @@ func TestMemoryJSON(t *testing.T) {
 	var res Memory
 	require.NoError(t, json.Unmarshal(dat, &res))
 	require.Equal(t, uint32(123), res.GetMemory(8))
+	require.Equal(t, m.MerkleRoot(), res.MerkleRoot(), "merkle root must be restored")
+}
+
+func BenchmarkMemoryMerkleRoot(b *testing.B) {
+	rng := mathrand.New(mathrand.NewSource(1234))
+	m := NewMemory()
+	addrs := make([]uint32, 1024)
+	for i := range addrs {
+		addrs[i] = rng.Uint32() &^ 3
+		m.SetMemory(addrs[i], uint32(i))
+	}
+	m.MerkleRoot()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 16; j++ {
+			m.SetMemory(addrs[rng.Intn(len(addrs))], uint32(i))
+		}
+		m.MerkleRoot()
+		m.MerkleProof(addrs[rng.Intn(len(addrs))])
+	}
+}
+
+func BenchmarkMemoryMerkleRootDirty(b *testing.B) {
+	data := make([]byte, 4<<20)
+	for i := 0; i < b.N; i++ {
+		m := NewMemory()
+		require.NoError(b, m.SetMemoryRange(0, bytes.NewReader(data)))
+		m.MerkleRoot()
+	}
 }
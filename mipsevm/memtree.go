@@ -0,0 +1,81 @@
+package mipsevm
+
+import "math/bits"
+
+// The memory merkle tree above the pages has PageKeySize levels of intermediate nodes.
+// The top levels are stored densely, indexed by generalized index.
+// The lower levels are stored in chunks, each covering the subtree below a node at depth memTreeTopDepth,
+// that are only allocated once a page in that subtree is allocated.
+// A missing chunk means the whole subtree is zeroed.
+const (
+	memTreeTopDepth   = 12
+	memTreeChunkDepth = PageKeySize - memTreeTopDepth
+	memTreeChunkCount = 1 << memTreeTopDepth
+)
+
+type memTreeChunk struct {
+	// intermediate nodes, indexed by generalized index relative to the chunk root
+	nodes [1 << memTreeChunkDepth][32]byte
+	// true if the node is valid
+	ok [1 << memTreeChunkDepth]bool
+}
+
+// memTree holds the intermediate nodes of the memory merkle tree, from the root up to (excluding) the page roots.
+// Nodes are merkleized lazily: a node is only valid if all nodes below it are valid,
+// hence invalidating a node invalidates all its parent nodes too.
+type memTree struct {
+	// top-level nodes, indexed by generalized index
+	nodes [memTreeChunkCount][32]byte
+	ok    [memTreeChunkCount]bool
+	// chunks of the lower levels, indexed by the position of the chunk root at depth memTreeTopDepth
+	chunks [memTreeChunkCount]*memTreeChunk
+}
+
+// node returns the hash and validity of the intermediate node at the given generalized index.
+// It returns nil if the node is in a chunk that was never allocated.
+func (t *memTree) node(gindex uint64) (*[32]byte, *bool) {
+	if gindex < memTreeChunkCount {
+		return &t.nodes[gindex], &t.ok[gindex]
+	}
+	// depth of the node below the chunk root
+	depth := uint64(bits.Len64(gindex)) - 1 - memTreeTopDepth
+	c := t.chunks[(gindex>>depth)-memTreeChunkCount]
+	if c == nil {
+		return nil, nil
+	}
+	local := (uint64(1) << depth) | (gindex & ((1 << depth) - 1))
+	return &c.nodes[local], &c.ok[local]
+}
+
+// alloc allocates the chunk covering the given page, and invalidates all nodes from the page up to the root.
+func (t *memTree) alloc(pageIndex uint32) {
+	ci := pageIndex >> memTreeChunkDepth
+	if t.chunks[ci] == nil {
+		t.chunks[ci] = new(memTreeChunk)
+	}
+	t.invalidate(pageIndex, true)
+}
+
+// invalidate invalidates the nodes on the path from the given page up to the root.
+// Unless full is set, this stops at the first node that is already invalid:
+// all nodes above it are invalid too.
+func (t *memTree) invalidate(pageIndex uint32, full bool) {
+	c := t.chunks[pageIndex>>memTreeChunkDepth]
+	if c != nil {
+		k := (uint32(1) << memTreeChunkDepth) | (pageIndex & ((1 << memTreeChunkDepth) - 1))
+		for k >>= 1; k > 0; k >>= 1 {
+			if !c.ok[k] && !full {
+				return
+			}
+			c.ok[k] = false
+		}
+	}
+	// the chunk root is stored in the chunk, continue with its parent in the top levels
+	k := (uint32(1) << memTreeTopDepth) | (pageIndex >> memTreeChunkDepth)
+	for k >>= 1; k > 0; k >>= 1 {
+		if !t.ok[k] && !full {
+			return
+		}
+		t.ok[k] = false
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"debug/elf"
 	"fmt"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type Symbol struct {
@@ -14,6 +16,8 @@ type Symbol struct {
 
 type Metadata struct {
 	Symbols []Symbol `json:"symbols"`
+	// Patches records the patch sets that were applied to the program, if any.
+	Patches []*PatchReport `json:"patches,omitempty"`
 }
 
 func MakeMetadata(elfProgram *elf.File) (*Metadata, error) {
@@ -36,10 +40,7 @@ func (m *Metadata) LookupSymbol(addr uint32) string {
 	if len(m.Symbols) == 0 {
 		return "!unknown"
 	}
-	// find first symbol with higher start. Or n if no such symbol exists
-	i := sort.Search(len(m.Symbols), func(i int) bool {
-		return m.Symbols[i].Start > addr
-	})
+	i := m.searchSymbol(addr)
 	if i == 0 {
 		return "!start"
 	}
@@ -50,6 +51,28 @@ func (m *Metadata) LookupSymbol(addr uint32) string {
 	return out.Name
 }
 
+// LookupSymbolOffset returns the name of the symbol that contains the address,
+// and the offset of the address relative to the start of the symbol.
+// The bool is false if the address is not covered by any symbol.
+func (m *Metadata) LookupSymbolOffset(addr uint32) (name string, offset uint32, ok bool) {
+	i := m.searchSymbol(addr)
+	if i == 0 {
+		return "", 0, false
+	}
+	out := &m.Symbols[i-1]
+	if out.Start+out.Size < addr {
+		return "", 0, false
+	}
+	return out.Name, addr - out.Start, true
+}
+
+// searchSymbol finds the first symbol with higher start. Or n if no such symbol exists
+func (m *Metadata) searchSymbol(addr uint32) int {
+	return sort.Search(len(m.Symbols), func(i int) bool {
+		return m.Symbols[i].Start > addr
+	})
+}
+
 // HexU32 to lazy-format integer attributes for logging
 type HexU32 uint32
 
@@ -60,3 +83,12 @@ func (v HexU32) String() string {
 func (v HexU32) MarshalText() ([]byte, error) {
 	return []byte(v.String()), nil
 }
+
+func (v *HexU32) UnmarshalText(dat []byte) error {
+	n, err := strconv.ParseUint(strings.TrimPrefix(string(dat), "0x"), 16, 32)
+	if err != nil {
+		return err
+	}
+	*v = HexU32(n)
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
 func (m *InstrumentedState) readPreimage(key [32]byte, offset uint32) (dat [32]byte, datLen uint32) {
@@ -22,13 +24,45 @@ func (m *InstrumentedState) readPreimage(key [32]byte, offset uint32) (dat [32]b
 	return
 }
 
+// trackMemAccess tracks an access of the memory word at effAddr, before the access happens,
+// and takes its memory proof if it needs one, in the order of StepWitness.
 func (m *InstrumentedState) trackMemAccess(effAddr uint32) {
-	if m.memProofEnabled && m.lastMemAccess != effAddr {
-		if m.lastMemAccess != ^uint32(0) {
-			panic(fmt.Errorf("unexpected different mem access at %08x, already have access at %08x buffered", effAddr, m.lastMemAccess))
+	if !m.memProofEnabled && m.memAccessHook == nil {
+		return
+	}
+	if n := len(m.memAccess); n > 0 && m.memAccess[n-1] == effAddr {
+		return // the proof of the previous access still applies
+	}
+	if m.memAccessHook != nil {
+		m.memAccessHook(effAddr)
+	}
+	m.memAccess = append(m.memAccess, effAddr)
+	if m.memProofEnabled {
+		m.memProofs = append(m.memProofs, m.state.Memory.MerkleProof(effAddr))
+	}
+}
+
+// writeOutput writes the memory range to stdout, stderr, or the hint channel, for write and writev syscalls.
+func (m *InstrumentedState) writeOutput(fd uint32, addr uint32, count uint32) {
+	switch fd {
+	case fdStdout:
+		_, _ = io.Copy(m.stdOut, m.state.Memory.ReadMemoryRange(addr, count))
+	case fdStderr:
+		_, _ = io.Copy(m.stdErr, m.state.Memory.ReadMemoryRange(addr, count))
+	case fdHintWrite:
+		hintData, _ := io.ReadAll(m.state.Memory.ReadMemoryRange(addr, count))
+		m.state.LastHint = append(m.state.LastHint, hintData...)
+		for len(m.state.LastHint) >= 4 { // process while there is enough data to check if there are any hints
+			hintLen := binary.BigEndian.Uint32(m.state.LastHint[:4])
+			if hintLen <= uint32(len(m.state.LastHint[4:])) {
+				hint := m.state.LastHint[4 : 4+hintLen] // without the length prefix
+				m.state.LastHint = m.state.LastHint[4+hintLen:]
+				m.state.PrevHint = append(hexutil.Bytes(nil), hint...)
+				m.preimageOracle.Hint(hint)
+			} else {
+				break // stop processing hints if there is incomplete data buffered
+			}
 		}
-		m.lastMemAccess = effAddr
-		m.memProof = m.state.Memory.MerkleProof(effAddr)
 	}
 }
 
@@ -43,7 +77,7 @@ func (m *InstrumentedState) handleSyscall() error {
 
 	fmt.Printf("syscall: %d\n", syscallNum)
 	switch syscallNum {
-	case 4090: // mmap
+	case sysMmap, sysMmap2: // anonymous mappings only, the offset unit difference does not matter
 		sz := a1
 		if sz&PageAddrMask != 0 { // adjust size to align with page size
 			sz += PageSize - (sz & PageAddrMask)
@@ -65,15 +99,29 @@ func (m *InstrumentedState) handleSyscall() error {
 		//		log.Fatalf("mmap fail: %v", err)
 		//	}
 		//}
-	case 4045: // brk
-		v0 = 0x40000000
-	case 4120: // clone (not supported)
+	case sysBrk:
+		m.trackMemAccess(BrkAddr)
+		brk := m.state.Memory.GetMemory(BrkAddr)
+		if brk == 0 {
+			v0 = BrkStart // legacy fixed program break, for programs without brk-based heap
+		} else {
+			// the heap can grow, up to the mmap heap, but not shrink
+			if a0 > brk && a0 <= HeapStart {
+				brk = a0
+				m.state.Memory.SetMemory(BrkAddr, brk)
+			}
+			v0 = brk
+		}
+	case sysSetThreadArea:
+		m.trackMemAccess(TLSAddr)
+		m.state.Memory.SetMemory(TLSAddr, a0)
+	case sysClone: // not supported
 		v0 = 1
-	case 4246: // exit_group
+	case sysExit, sysExitGroup: // there is only a single thread
 		m.state.Exited = true
 		m.state.ExitCode = uint8(a0)
 		return nil
-	case 4003: // read
+	case sysRead:
 		// args: a0 = fd, a1 = addr, a2 = count
 		// returns: v0 = read, v1 = err code
 		switch a0 {
@@ -101,35 +149,21 @@ func (m *InstrumentedState) handleSyscall() error {
 			v0 = datLen
 			fmt.Printf("read %d pre-image bytes, new offset: %d, eff addr: %08x mem: %08x\n", datLen, m.state.PreimageOffset, effAddr, outMem)
 		case fdHintRead: // hint response
-			// don't actually read into memory, just say we read it all, we ignore the result anyway
+			// don't actually read into memory, just say we read it all, we ignore the result anyway.
+			// The onchain VM cannot observe the host, so neither can the program observe the hint status:
+			// the read buffer is left as is, and the HintWriter of the program gets the zero HintOK status.
+			// The host of the VM checks the status instead.
 			v0 = a2
 		default:
 			v0 = 0xFFffFFff
 			v1 = MipsEBADF
 		}
-	case 4004: // write
+	case sysWrite:
 		// args: a0 = fd, a1 = addr, a2 = count
 		// returns: v0 = written, v1 = err code
 		switch a0 {
-		case fdStdout:
-			_, _ = io.Copy(m.stdOut, m.state.Memory.ReadMemoryRange(a1, a2))
-			v0 = a2
-		case fdStderr:
-			_, _ = io.Copy(m.stdErr, m.state.Memory.ReadMemoryRange(a1, a2))
-			v0 = a2
-		case fdHintWrite:
-			hintData, _ := io.ReadAll(m.state.Memory.ReadMemoryRange(a1, a2))
-			m.state.LastHint = append(m.state.LastHint, hintData...)
-			for len(m.state.LastHint) >= 4 { // process while there is enough data to check if there are any hints
-				hintLen := binary.BigEndian.Uint32(m.state.LastHint[:4])
-				if hintLen >= uint32(len(m.state.LastHint[4:])) {
-					hint := m.state.LastHint[4 : 4+hintLen] // without the length prefix
-					m.state.LastHint = m.state.LastHint[4+hintLen:]
-					m.preimageOracle.Hint(hint)
-				} else {
-					break // stop processing hints if there is incomplete data buffered
-				}
-			}
+		case fdStdout, fdStderr, fdHintWrite:
+			m.writeOutput(a0, a1, a2)
 			v0 = a2
 		case fdPreimageWrite:
 			effAddr := a1 & 0xFFffFFfc
@@ -153,7 +187,28 @@ func (m *InstrumentedState) handleSyscall() error {
 			v0 = 0xFFffFFff
 			v1 = MipsEBADF
 		}
-	case 4055: // fcntl
+	case sysWritev:
+		// args: a0 = fd, a1 = iov, a2 = iovcnt
+		// returns: v0 = written, v1 = err code
+		// Like write, all data is written to the output fds. Only the iov_len word of each iovec is proven,
+		// to sum the written size: the data itself is not part of the onchain state.
+		switch {
+		case a0 != fdStdout && a0 != fdStderr && a0 != fdHintWrite:
+			v0 = 0xFFffFFff
+			v1 = MipsEBADF
+		case a1&3 != 0 || a2 > maxIovcnt:
+			v0 = 0xFFffFFff
+			v1 = MipsEINVAL
+		default:
+			for i := uint32(0); i < a2; i++ {
+				lenAddr := a1 + i*8 + 4
+				m.trackMemAccess(lenAddr)
+				count := m.state.Memory.GetMemory(lenAddr)
+				m.writeOutput(a0, m.state.Memory.GetMemory(lenAddr-4), count)
+				v0 += count
+			}
+		}
+	case sysFcntl:
 		// args: a0 = fd, a1 = cmd
 		if a1 == 3 { // F_GETFL: get file descriptor flags
 			switch a0 {
@@ -272,8 +327,9 @@ func (m *InstrumentedState) mipsStep() error {
 	}
 	m.state.Step += 1
 	// instruction fetch
-	insn := m.state.Memory.GetMemory(m.state.PC)
-	opcode := insn >> 26 // 6-bits
+	d := m.fetch(m.state.PC)
+	insn := d.insn
+	opcode := d.opcode // 6-bits
 
 	// j-type j/jal
 	if opcode == 2 || opcode == 3 {
@@ -283,50 +339,37 @@ func (m *InstrumentedState) mipsStep() error {
 		if opcode == 3 {
 			linkReg = 31
 		}
-		return m.handleJump(linkReg, SE(insn&0x03FFFFFF, 26)<<2)
+		return m.handleJump(linkReg, d.imm)
 	}
 
 	// register fetch
-	rs := uint32(0) // source register 1 value
-	rt := uint32(0) // source register 2 / temp value
-	rtReg := (insn >> 16) & 0x1F
-
-	// R-type or I-type (stores rt)
-	rs = m.state.Registers[(insn>>21)&0x1F]
-	rdReg := rtReg
-	if opcode == 0 || opcode == 0x1c {
-		// R-type (stores rd)
+	rtReg := d.rtReg
+	rs := m.state.Registers[d.rsReg] // source register 1 value
+	rt := uint32(0)                  // source register 2 / temp value
+	if d.rtIsReg {
 		rt = m.state.Registers[rtReg]
-		rdReg = (insn >> 11) & 0x1F
 	} else if opcode < 0x20 {
-		// rt is SignExtImm
-		// don't sign extend for andi, ori, xori
-		if opcode == 0xC || opcode == 0xD || opcode == 0xe {
-			// ZeroExtImm
-			rt = insn & 0xFFFF
-		} else {
-			// SignExtImm
-			rt = SE(insn&0xFFFF, 16)
-		}
-	} else if opcode >= 0x28 || opcode == 0x22 || opcode == 0x26 {
-		// store rt value with store
-		rt = m.state.Registers[rtReg]
-
-		// store actual rt with lwl and lwr
-		rdReg = rtReg
+		rt = d.imm // rt is the extended immediate
 	}
+	rdReg := d.rdReg
 
 	if (opcode >= 4 && opcode < 8) || opcode == 1 {
 		return m.handleBranch(opcode, insn, rtReg, rs)
 	}
 
+	// rdhwr $rt, $29: read the thread pointer, like the kernel emulates it
+	if opcode == 0x1f && d.fun == 0x3b && (insn>>11)&0x1F == 29 {
+		m.trackMemAccess(TLSAddr)
+		return m.handleRd(rtReg, m.state.Memory.GetMemory(TLSAddr), true)
+	}
+
 	storeAddr := uint32(0xFF_FF_FF_FF)
 	// memory fetch (all I-type)
 	// we do the load for stores also
 	mem := uint32(0)
 	if opcode >= 0x20 {
 		// M[R[rs]+SignExtImm]
-		rs += SE(insn&0xFFFF, 16)
+		rs += d.imm
 		addr := rs & 0xFFFFFFFC
 		m.trackMemAccess(addr)
 		mem = m.state.Memory.GetMemory(addr)
@@ -341,7 +384,7 @@ func (m *InstrumentedState) mipsStep() error {
 	// ALU
 	val := execute(insn, rs, rt, mem)
 
-	fun := insn & 0x3f // 6-bits
+	fun := d.fun // 6-bits
 	if opcode == 0 && fun >= 8 && fun < 0x1c {
 		if fun == 8 || fun == 9 { // jr/jalr
 			linkReg := uint32(0)
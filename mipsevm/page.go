@@ -29,12 +29,17 @@ type CachedPage struct {
 	Cache [PageSize / 32][32]byte
 	// true if the intermediate node is valid
 	Ok [PageSize / 32]bool
+	// decoded instructions, allocated when an instruction is first fetched from the page
+	insns *[PageSize / 4]decodedInsn
 }
 
 func (p *CachedPage) Invalidate(pageAddr uint32) {
 	if pageAddr >= PageSize {
 		panic("invalid page addr")
 	}
+	if p.insns != nil {
+		p.insns[pageAddr>>2].valid = false
+	}
 	k := (1 << PageAddrSize) | pageAddr
 	// first cache layer caches nodes that has two 32 byte leaf nodes.
 	k >>= 5 + 1
@@ -46,6 +51,7 @@ func (p *CachedPage) Invalidate(pageAddr uint32) {
 
 func (p *CachedPage) InvalidateFull() {
 	p.Ok = [PageSize / 32]bool{} // reset everything to false
+	p.insns = nil
 }
 
 func (p *CachedPage) MerkleRoot() [32]byte {
@@ -0,0 +1,222 @@
+package mipsevm
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// ErrPageNotFound is returned by a PageStore that does not have the requested page.
+var ErrPageNotFound = errors.New("page not found")
+
+// PageStore stores pages by their merkle root, such that a page that is part of many snapshots is only stored once.
+type PageStore interface {
+	// GetPage returns the page with the given merkle root, or ErrPageNotFound.
+	GetPage(root common.Hash) (*Page, error)
+	// PutPage stores the page with the given merkle root. Storing a page that already exists is a no-op.
+	PutPage(root common.Hash, p *Page) error
+	// HasPage returns whether the page with the given merkle root is stored, without reading it.
+	HasPage(root common.Hash) (bool, error)
+}
+
+// DirPageStore is a PageStore that stores each page as a file in a directory,
+// named after the hex-encoded merkle root of the page.
+type DirPageStore struct {
+	dir string
+	// roots of pages known to be stored, to avoid checking the file system for each page of each snapshot
+	known sync.Map
+}
+
+func NewDirPageStore(dir string) (*DirPageStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create page store dir: %w", err)
+	}
+	return &DirPageStore{dir: dir}, nil
+}
+
+func (s *DirPageStore) path(root common.Hash) string {
+	name := root.Hex()[2:]
+	// spread the pages over sub-directories, to keep directory sizes manageable
+	return filepath.Join(s.dir, name[:2], name[2:])
+}
+
+func (s *DirPageStore) GetPage(root common.Hash) (*Page, error) {
+	data, err := os.ReadFile(s.path(root))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("page %s: %w", root, ErrPageNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read page %s: %w", root, err)
+	}
+	if len(data) != PageSize {
+		return nil, fmt.Errorf("page %s has %d bytes, expected %d", root, len(data), PageSize)
+	}
+	p := new(Page)
+	copy(p[:], data)
+	return p, nil
+}
+
+func (s *DirPageStore) HasPage(root common.Hash) (bool, error) {
+	if _, ok := s.known.Load(root); ok {
+		return true, nil
+	}
+	if _, err := os.Stat(s.path(root)); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to check page %s: %w", root, err)
+	}
+	s.known.Store(root, struct{}{})
+	return true, nil
+}
+
+func (s *DirPageStore) PutPage(root common.Hash, p *Page) error {
+	if _, ok := s.known.Load(root); ok {
+		return nil
+	}
+	path := s.path(root)
+	if _, err := os.Stat(path); err == nil {
+		s.known.Store(root, struct{}{})
+		return nil // pages are content-addressed, no need to overwrite
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create page dir: %w", err)
+	}
+	// write to a temporary file first, so a page file is never partially written
+	f, err := os.CreateTemp(filepath.Dir(path), ".page-*")
+	if err != nil {
+		return fmt.Errorf("failed to create page file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(p[:]); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to write page %s: %w", root, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write page %s: %w", root, err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("failed to store page %s: %w", root, err)
+	}
+	s.known.Store(root, struct{}{})
+	return nil
+}
+
+// PageRef references a page of memory by its merkle root.
+type PageRef struct {
+	Index uint32      `json:"index"`
+	Root  common.Hash `json:"root"`
+}
+
+// Snapshot is a VM state with the memory pages stored separately in a PageStore.
+// Its JSON encoding matches that of the State, except that memory pages are encoded as references.
+type Snapshot struct {
+	State
+	// Memory shadows the memory of the state, which is always nil.
+	Memory []PageRef `json:"memory"`
+}
+
+// NewSnapshot stores all memory pages of the state that are not in the store yet,
+// and returns the snapshot that references them.
+// Pages that are not loaded yet are copied if they come from a different store, but not loaded into memory.
+func NewSnapshot(st *State, store PageStore) (*Snapshot, error) {
+	mem := st.Memory
+	out := &Snapshot{State: *st, Memory: make([]PageRef, 0, mem.PageCount())}
+	out.State.Memory = nil
+	for index, root := range mem.lazy {
+		if mem.store != store {
+			// copy the page between stores, without loading it into memory
+			data, err := mem.store.GetPage(root)
+			if err != nil {
+				return nil, err
+			}
+			if err := store.PutPage(root, data); err != nil {
+				return nil, err
+			}
+		}
+		out.Memory = append(out.Memory, PageRef{Index: index, Root: root})
+	}
+	for index, p := range mem.Pages {
+		root := common.Hash(p.MerkleRoot())
+		if err := store.PutPage(root, p.Data); err != nil {
+			return nil, err
+		}
+		out.Memory = append(out.Memory, PageRef{Index: index, Root: root})
+	}
+	sort.Slice(out.Memory, func(i, j int) bool {
+		return out.Memory[i].Index < out.Memory[j].Index
+	})
+	return out, nil
+}
+
+// Load reconstructs the state of the snapshot with pages from the store.
+// Each page is checked against the merkle root it is referenced by.
+func (s *Snapshot) Load(store PageStore) (*State, error) {
+	st := s.State
+	st.Memory = NewMemory()
+	for i, ref := range s.Memory {
+		if _, ok := st.Memory.Pages[ref.Index]; ok {
+			return nil, fmt.Errorf("cannot load duplicate page, entry %d, page index %d", i, ref.Index)
+		}
+		data, err := store.GetPage(ref.Root)
+		if err != nil {
+			return nil, err
+		}
+		p := st.Memory.AllocPage(ref.Index)
+		p.Data = data
+		if root := common.Hash(p.MerkleRoot()); root != ref.Root {
+			return nil, fmt.Errorf("page %d has root %s, expected %s", ref.Index, root, ref.Root)
+		}
+	}
+	return &st, nil
+}
+
+// LoadLazy reconstructs the state of the snapshot, with pages that are only loaded from the store on first access.
+// The state hash is computed from the page roots of the snapshot, without loading any pages,
+// and a merkle proof only loads the page that is proven.
+// Each page is checked against the merkle root it is referenced by when it is loaded,
+// and it must be in the store already, so a bad snapshot fails here instead of on first access.
+func (s *Snapshot) LoadLazy(store PageStore) (*State, error) {
+	st := s.State
+	st.Memory = NewLazyMemory(store)
+	for i, ref := range s.Memory {
+		if err := st.Memory.AddLazyPage(ref.Index, ref.Root); err != nil {
+			return nil, fmt.Errorf("cannot load entry %d: %w", i, err)
+		}
+	}
+	return &st, nil
+}
+
+// PageDiff is a page that differs between two snapshots. The root is zero if the page is not allocated.
+type PageDiff struct {
+	Index uint32      `json:"index"`
+	From  common.Hash `json:"from"`
+	To    common.Hash `json:"to"`
+}
+
+// DiffSnapshots returns the pages that differ between the two snapshots, ordered by page index.
+// The pages of both snapshots must be ordered by index, as created by NewSnapshot.
+func DiffSnapshots(a, b *Snapshot) []PageDiff {
+	var out []PageDiff
+	i, j := 0, 0
+	for i < len(a.Memory) || j < len(b.Memory) {
+		switch {
+		case j == len(b.Memory) || (i < len(a.Memory) && a.Memory[i].Index < b.Memory[j].Index):
+			out = append(out, PageDiff{Index: a.Memory[i].Index, From: a.Memory[i].Root})
+			i++
+		case i == len(a.Memory) || b.Memory[j].Index < a.Memory[i].Index:
+			out = append(out, PageDiff{Index: b.Memory[j].Index, To: b.Memory[j].Root})
+			j++
+		default:
+			if a.Memory[i].Root != b.Memory[j].Root {
+				out = append(out, PageDiff{Index: a.Memory[i].Index, From: a.Memory[i].Root, To: b.Memory[j].Root})
+			}
+			i++
+			j++
+		}
+	}
+	return out
+}
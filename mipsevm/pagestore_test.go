@@ -0,0 +1,193 @@
+package mipsevm
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewDirPageStore(dir)
+	require.NoError(t, err)
+
+	st := &State{PC: 0x1000, NextPC: 0x1004, Step: 42, Memory: NewMemory()}
+	st.Registers[29] = 0x7fff0000
+	for i := uint32(0); i < 10; i++ {
+		st.Memory.SetMemory(i*PageSize, i)
+	}
+	st.Memory.SetMemory(PageSize*20, 0) // zero page, still allocated
+	a, err := NewSnapshot(st, store)
+	require.NoError(t, err)
+	require.Len(t, a.Memory, 11)
+
+	countPages := func() (n int) {
+		require.NoError(t, filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				n++
+			}
+			return err
+		}))
+		return n
+	}
+	require.Equal(t, 10, countPages(), "pages 0 and 20 are both zero, and stored once")
+
+	st.Memory.SetMemory(PageSize*3, 123)
+	st.Memory.SetMemory(PageSize*30, 0x1337)
+	st.Step += 1
+	b, err := NewSnapshot(st, store)
+	require.NoError(t, err)
+	require.Equal(t, 12, countPages(), "only changed and new pages are stored")
+
+	t.Run("load", func(t *testing.T) {
+		loaded, err := b.Load(store)
+		require.NoError(t, err)
+		require.Equal(t, st.EncodeWitness(), loaded.EncodeWitness())
+		require.Len(t, loaded.Memory.Pages, len(st.Memory.Pages))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		dat, err := json.Marshal(b)
+		require.NoError(t, err)
+		var header struct {
+			Step   uint64            `json:"step"`
+			Memory []json.RawMessage `json:"memory"`
+		}
+		require.NoError(t, json.Unmarshal(dat, &header))
+		require.Equal(t, uint64(43), header.Step)
+		require.Len(t, header.Memory, 12)
+		var res Snapshot
+		require.NoError(t, json.Unmarshal(dat, &res))
+		require.Equal(t, b, &res)
+	})
+
+	t.Run("diff", func(t *testing.T) {
+		diff := DiffSnapshots(a, b)
+		require.Len(t, diff, 2)
+		require.Equal(t, uint32(3), diff[0].Index)
+		require.Equal(t, a.Memory[3].Root, diff[0].From)
+		require.Equal(t, b.Memory[3].Root, diff[0].To)
+		require.Equal(t, PageDiff{Index: 30, To: b.Memory[11].Root}, diff[1])
+		require.Empty(t, DiffSnapshots(b, b))
+		require.Equal(t, PageDiff{Index: 30, From: b.Memory[11].Root}, DiffSnapshots(b, a)[1])
+	})
+
+	t.Run("missing page", func(t *testing.T) {
+		c := *b
+		c.Memory = append([]PageRef{}, b.Memory...)
+		c.Memory[0].Root = common.Hash{1}
+		_, err := c.Load(store)
+		require.ErrorIs(t, err, ErrPageNotFound)
+	})
+
+	t.Run("corrupt page", func(t *testing.T) {
+		var p Page
+		p[0] = 1
+		require.NoError(t, os.WriteFile(store.path(b.Memory[0].Root), p[:], 0644))
+		_, err := b.Load(store)
+		require.ErrorContains(t, err, "page 0 has root")
+	})
+}
+
+// countingPageStore counts the pages that are read from the store.
+type countingPageStore struct {
+	PageStore
+	reads int
+}
+
+func (s *countingPageStore) GetPage(root common.Hash) (*Page, error) {
+	s.reads++
+	return s.PageStore.GetPage(root)
+}
+
+func TestSnapshotLoadLazy(t *testing.T) {
+	dir, err := NewDirPageStore(t.TempDir())
+	require.NoError(t, err)
+	store := &countingPageStore{PageStore: dir}
+
+	st := &State{PC: 0x1000, NextPC: 0x1004, Memory: NewMemory()}
+	for i := uint32(0); i < 100; i++ {
+		st.Memory.SetMemory(i*PageSize*7, i+1)
+	}
+	snap, err := NewSnapshot(st, store)
+	require.NoError(t, err)
+
+	lazy, err := snap.LoadLazy(store)
+	require.NoError(t, err)
+	require.Equal(t, 100, lazy.Memory.PageCount())
+	require.Equal(t, st.EncodeWitness(), lazy.EncodeWitness(), "state hash from page roots")
+	require.Zero(t, store.reads, "no pages are loaded for the state hash")
+
+	require.Equal(t, st.Memory.MerkleProof(PageSize*7*3), lazy.Memory.MerkleProof(PageSize*7*3))
+	require.Equal(t, 1, store.reads, "only the proven page is loaded")
+	require.Len(t, lazy.Memory.Pages, 1)
+
+	require.Equal(t, uint32(6), lazy.Memory.GetMemory(PageSize*7*5))
+	st.Memory.SetMemory(PageSize*7*9+4, 0x1337)
+	lazy.Memory.SetMemory(PageSize*7*9+4, 0x1337)
+	require.Equal(t, st.Memory.MerkleRoot(), lazy.Memory.MerkleRoot(), "root after write to lazy page")
+	require.Equal(t, 3, store.reads)
+
+	t.Run("snapshot", func(t *testing.T) {
+		again, err := NewSnapshot(lazy, store)
+		require.NoError(t, err)
+		require.Equal(t, 3, store.reads, "unloaded pages are referenced as-is")
+		require.Len(t, again.Memory, 100)
+		require.Equal(t, []PageDiff{{Index: 9 * 7, From: snap.Memory[9].Root, To: again.Memory[9].Root}}, DiffSnapshots(snap, again))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		dat, err := json.Marshal(lazy.Memory)
+		require.NoError(t, err)
+		require.Equal(t, 100, store.reads, "all pages are loaded")
+		var res Memory
+		require.NoError(t, json.Unmarshal(dat, &res))
+		require.Equal(t, st.Memory.MerkleRoot(), res.MerkleRoot())
+	})
+
+	t.Run("missing page", func(t *testing.T) {
+		other, err := NewDirPageStore(t.TempDir())
+		require.NoError(t, err)
+		_, err = snap.LoadLazy(other)
+		require.ErrorIs(t, err, ErrPageNotFound, "fails to load, instead of on first access")
+	})
+
+	t.Run("deleted page", func(t *testing.T) {
+		dir := t.TempDir()
+		other, err := NewDirPageStore(dir)
+		require.NoError(t, err)
+		snap, err := NewSnapshot(st, other)
+		require.NoError(t, err)
+		lazy, err := snap.LoadLazy(other)
+		require.NoError(t, err)
+		require.NoError(t, os.RemoveAll(dir))
+
+		var perr *PageLoadError
+		func() {
+			defer func() {
+				r := recover()
+				require.IsType(t, perr, r)
+				perr = r.(*PageLoadError)
+			}()
+			lazy.Memory.GetMemory(PageSize * 7 * 2)
+		}()
+		require.Equal(t, uint32(7*2), perr.Index)
+		require.ErrorIs(t, perr, ErrPageNotFound)
+
+		_, err = json.Marshal(lazy.Memory)
+		require.ErrorIs(t, err, ErrPageNotFound, "marshaling fails instead of panicking")
+	})
+}
+
+func TestAddLazyPageRoot(t *testing.T) {
+	store, err := NewDirPageStore(t.TempDir())
+	require.NoError(t, err)
+	m := NewLazyMemory(store)
+	require.ErrorContains(t, m.AddLazyPage(1, common.Hash{}), "no merkle root")
+	require.ErrorIs(t, m.AddLazyPage(1, common.Hash{1}), ErrPageNotFound)
+	require.Zero(t, m.PageCount())
+}
@@ -8,13 +8,21 @@ import (
 	"io"
 )
 
-func LoadELF(f *elf.File) (*State, error) {
+// LoadELF loads the program segments of the ELF file into a new VM state,
+// after running the given checks, if any, on the ELF file.
+func LoadELF(f *elf.File, checks ...ELFCheck) (*State, error) {
+	for _, check := range checks {
+		if err := check(f); err != nil {
+			return nil, err
+		}
+	}
+
 	s := &State{
 		PC:        uint32(f.Entry),
 		NextPC:    uint32(f.Entry + 4),
 		HI:        0,
 		LO:        0,
-		Heap:      0x20000000,
+		Heap:      HeapStart,
 		Registers: [32]uint32{},
 		Memory:    NewMemory(),
 		ExitCode:  0,
@@ -51,76 +59,134 @@ func LoadELF(f *elf.File) (*State, error) {
 	return s, nil
 }
 
+// PatchGo applies the built-in Go runtime and prometheus patch sets, ignoring any missing symbols.
 func PatchGo(f *elf.File, st *State) error {
-	symbols, err := f.Symbols()
-	if err != nil {
-		return fmt.Errorf("failed to read symbols data, cannot patch program: %w", err)
-	}
-
-	for _, s := range symbols {
-		// Disable Golang GC by patching the functions that enable the GC to a no-op function.
-		switch s.Name {
-		case "runtime.gcenable",
-			"runtime.init.5",            // patch out: init() { go forcegchelper() }
-			"runtime.main.func1",        // patch out: main.func() { newm(sysmon, ....) }
-			"runtime.deductSweepCredit", // uses floating point nums and interacts with gc we disabled
-			"runtime.(*gcControllerState).commit",
-			// these prometheus packages rely on concurrent background things. We cannot run those.
-			"github.com/prometheus/client_golang/prometheus.init",
-			"github.com/prometheus/client_golang/prometheus.init.0",
-			"github.com/prometheus/procfs.init",
-			"github.com/prometheus/common/model.init",
-			"github.com/prometheus/client_model/go.init",
-			"github.com/prometheus/client_model/go.init.0",
-			"github.com/prometheus/client_model/go.init.1",
-			// skip flag pkg init, we need to debug arg-processing more to see why this fails
-			"flag.init",
-			// We need to patch this out, we don't pass float64nan because we don't support floats
-			"runtime.check":
-			// MIPS32 patch: ret (pseudo instruction)
-			// 03e00008 = jr $ra = ret (pseudo instruction)
-			// 00000000 = nop (executes with delay-slot, but does nothing)
-			if err := st.Memory.SetMemoryRange(uint32(s.Value), bytes.NewReader([]byte{
-				0x03, 0xe0, 0x00, 0x08,
-				0, 0, 0, 0,
-			})); err != nil {
-				return fmt.Errorf("failed to patch Go runtime.gcenable: %w", err)
-			}
-		case "runtime.MemProfileRate":
-			if err := st.Memory.SetMemoryRange(uint32(s.Value), bytes.NewReader(make([]byte, 4))); err != nil { // disable mem profiling, to avoid a lot of unnecessary floating point ops
-				return err
-			}
+	for _, ps := range []*PatchSet{GoPatchSet, PrometheusPatchSet} {
+		if _, err := ApplyPatchSet(f, st, ps); err != nil {
+			return fmt.Errorf("failed to apply patch set %s: %w", ps.Name, err)
 		}
 	}
 	return nil
 }
 
-func PatchStack(st *State) error {
-	// setup stack pointer
-	sp := uint32(0x7f_ff_d0_00)
-	// allocate 1 page for the initial stack data, and 16KB = 4 pages for the stack to grow
-	if err := st.Memory.SetMemoryRange(sp-4*PageSize, bytes.NewReader(make([]byte, 5*PageSize))); err != nil {
-		return fmt.Errorf("failed to allocate page for stack content")
+// Auxiliary vector entry types, as defined by Linux.
+const (
+	AtNull   = 0
+	AtPageSz = 6
+	AtRandom = 25
+)
+
+// AuxvEntry is an entry of the auxiliary vector, which the kernel passes to the program on the initial stack.
+type AuxvEntry struct {
+	Type  uint32
+	Value uint32
+}
+
+// StackConfig describes the initial stack of the program, as set up by PatchStack.
+type StackConfig struct {
+	// Top is the exclusive end of the stack memory.
+	// The argv and envp strings and the AT_RANDOM bytes are placed right below it.
+	Top uint32
+	// Size is the total size of the stack memory, including the initial stack data.
+	Size uint32
+	// Args are the program arguments, the first one is conventionally the program name.
+	Args []string
+	// Env are the environment variables, formatted as "KEY=VALUE".
+	Env []string
+	// Auxv are additional auxiliary vector entries, added after AT_PAGESZ and AT_RANDOM.
+	Auxv []AuxvEntry
+	// Random are the 16 bytes that AT_RANDOM points to.
+	Random [16]byte
+}
+
+// DefaultStackConfig returns the stack configuration used by PatchStack when none is specified.
+func DefaultStackConfig() *StackConfig {
+	cfg := &StackConfig{
+		Top:  0x7f_ff_e0_00,
+		Size: 5 * PageSize, // 1 page for the initial stack data, and 16KB = 4 pages for the stack to grow
+		Args: []string{"program"},
 	}
-	st.Registers[29] = sp
+	copy(cfg.Random[:], "4;byfairdiceroll") // 16 bytes of "randomness"
+	return cfg
+}
 
-	storeMem := func(addr uint32, v uint32) {
-		var dat [4]byte
-		binary.BigEndian.PutUint32(dat[:], v)
-		_ = st.Memory.SetMemoryRange(addr, bytes.NewReader(dat[:]))
+// PatchStack sets up the initial stack of the program, like the Linux kernel does on MIPS:
+//
+//	sp -> argc
+//	      argv[0], ..., argv[argc-1], 0
+//	      envp[0], ..., envp[n-1], 0
+//	      auxv[0].type, auxv[0].value, ..., AT_NULL, 0
+//	      ...
+//	      argv and envp strings, AT_RANDOM bytes
+//	top
+//
+// The default StackConfig is used if cfg is nil.
+func PatchStack(st *State, cfg *StackConfig) error {
+	if cfg == nil {
+		cfg = DefaultStackConfig()
+	}
+	if cfg.Top < cfg.Size {
+		return fmt.Errorf("stack of size %d does not fit below stack top %08x", cfg.Size, cfg.Top)
+	}
+	for _, e := range cfg.Auxv {
+		if e.Type == AtNull {
+			return fmt.Errorf("auxv entry with AT_NULL type is not allowed, the auxiliary vector is terminated automatically")
+		}
 	}
 
-	// init argc, argv, aux on stack
-	storeMem(sp+4*1, 0x42)   // argc = 0 (argument count)
-	storeMem(sp+4*2, 0x35)   // argv[n] = 0 (terminating argv)
-	storeMem(sp+4*3, 0)      // envp[term] = 0 (no env vars)
-	storeMem(sp+4*4, 6)      // auxv[0] = _AT_PAGESZ = 6 (key)
-	storeMem(sp+4*5, 4096)   // auxv[1] = page size of 4 KiB (value) - (== minPhysPageSize)
-	storeMem(sp+4*6, 25)     // auxv[2] = AT_RANDOM
-	storeMem(sp+4*7, sp+4*9) // auxv[3] = address of 16 bytes containing random value
-	storeMem(sp+4*8, 0)      // auxv[term] = 0
+	base := cfg.Top - cfg.Size
+	stack := make([]byte, cfg.Size)
 
-	_ = st.Memory.SetMemoryRange(sp+4*9, bytes.NewReader([]byte("4;byfairdiceroll"))) // 16 bytes of "randomness"
+	// the strings and random bytes are placed at the top of the stack
+	strSize := uint32(len(cfg.Random))
+	for _, v := range cfg.Args {
+		strSize += uint32(len(v)) + 1
+	}
+	for _, v := range cfg.Env {
+		strSize += uint32(len(v)) + 1
+	}
+	if strSize > cfg.Size {
+		return fmt.Errorf("argv and envp strings of %d bytes do not fit in stack of size %d", strSize, cfg.Size)
+	}
+	ptr := (cfg.Top - strSize) &^ 3
+	strStart := ptr
+	putStrings := func(strs []string) (addrs []uint32) {
+		for _, v := range strs {
+			addrs = append(addrs, ptr)
+			copy(stack[ptr-base:], v) // followed by a zero byte
+			ptr += uint32(len(v)) + 1
+		}
+		return addrs
+	}
+	argv := putStrings(cfg.Args)
+	envp := putStrings(cfg.Env)
+	randomAddr := ptr
+	copy(stack[ptr-base:], cfg.Random[:])
 
+	var words []uint32
+	words = append(words, uint32(len(cfg.Args)))
+	words = append(words, argv...)
+	words = append(words, 0)
+	words = append(words, envp...)
+	words = append(words, 0)
+	words = append(words, AtPageSz, PageSize) // page size of 4 KiB (== minPhysPageSize)
+	words = append(words, AtRandom, randomAddr)
+	for _, e := range cfg.Auxv {
+		words = append(words, e.Type, e.Value)
+	}
+	words = append(words, AtNull, 0)
+
+	// setup stack pointer, 16-byte aligned like the kernel does
+	if uint32(len(words))*4+15 > strStart-base {
+		return fmt.Errorf("initial stack data of %d bytes does not fit in stack of size %d", strSize+uint32(len(words))*4, cfg.Size)
+	}
+	sp := (strStart - uint32(len(words))*4) &^ 15
+	for i, w := range words {
+		binary.BigEndian.PutUint32(stack[sp-base+uint32(i)*4:], w)
+	}
+	if err := st.Memory.SetMemoryRange(base, bytes.NewReader(stack)); err != nil {
+		return fmt.Errorf("failed to allocate stack memory: %w", err)
+	}
+	st.Registers[29] = sp
 	return nil
 }
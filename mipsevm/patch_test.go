@@ -0,0 +1,79 @@
+package mipsevm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatchStack(t *testing.T) {
+	readString := func(m *Memory, addr uint32) string {
+		var buf bytes.Buffer
+		for {
+			var b [1]byte
+			_, err := io.ReadFull(m.ReadMemoryRange(addr, 1), b[:])
+			require.NoError(t, err)
+			if b[0] == 0 {
+				return buf.String()
+			}
+			buf.WriteByte(b[0])
+			addr++
+		}
+	}
+
+	t.Run("default", func(t *testing.T) {
+		st := &State{Memory: NewMemory()}
+		require.NoError(t, PatchStack(st, nil))
+		sp := st.Registers[29]
+		require.Zero(t, sp%16, "aligned stack pointer")
+		require.Equal(t, uint32(1), st.Memory.GetMemory(sp), "argc")
+		require.Equal(t, "program", readString(st.Memory, st.Memory.GetMemory(sp+4)))
+		require.Equal(t, uint32(0), st.Memory.GetMemory(sp+8), "argv terminator")
+		require.Equal(t, uint32(0), st.Memory.GetMemory(sp+12), "envp terminator")
+		require.Equal(t, uint32(AtPageSz), st.Memory.GetMemory(sp+16))
+		require.Equal(t, uint32(PageSize), st.Memory.GetMemory(sp+20))
+		require.Equal(t, uint32(AtRandom), st.Memory.GetMemory(sp+24))
+		var random [16]byte
+		_, err := io.ReadFull(st.Memory.ReadMemoryRange(st.Memory.GetMemory(sp+28), 16), random[:])
+		require.NoError(t, err)
+		require.Equal(t, "4;byfairdiceroll", string(random[:]))
+		require.Equal(t, uint32(AtNull), st.Memory.GetMemory(sp+32))
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		st := &State{Memory: NewMemory()}
+		cfg := &StackConfig{
+			Top:  0x6000_0000,
+			Size: 2 * PageSize,
+			Args: []string{"prog", "--foo", "bar"},
+			Env:  []string{"A=1", "HELLO=world"},
+			Auxv: []AuxvEntry{{Type: 16, Value: 0x1234}},
+		}
+		require.NoError(t, PatchStack(st, cfg))
+		sp := st.Registers[29]
+		require.Less(t, sp, cfg.Top)
+		require.GreaterOrEqual(t, sp, cfg.Top-cfg.Size)
+		require.Equal(t, uint32(3), st.Memory.GetMemory(sp))
+		for i, arg := range cfg.Args {
+			require.Equal(t, arg, readString(st.Memory, st.Memory.GetMemory(sp+4+uint32(i)*4)))
+		}
+		require.Equal(t, uint32(0), st.Memory.GetMemory(sp+16))
+		for i, env := range cfg.Env {
+			require.Equal(t, env, readString(st.Memory, st.Memory.GetMemory(sp+20+uint32(i)*4)))
+		}
+		require.Equal(t, uint32(0), st.Memory.GetMemory(sp+28))
+		require.Equal(t, uint32(16), st.Memory.GetMemory(sp+48), "extra auxv entry after AT_PAGESZ and AT_RANDOM")
+		require.Equal(t, uint32(0x1234), st.Memory.GetMemory(sp+52))
+		require.Equal(t, uint32(AtNull), st.Memory.GetMemory(sp+56))
+	})
+
+	t.Run("too large", func(t *testing.T) {
+		st := &State{Memory: NewMemory()}
+		cfg := DefaultStackConfig()
+		cfg.Size = 64
+		cfg.Env = []string{"A=aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+		require.ErrorContains(t, PatchStack(st, cfg), "do not fit")
+	})
+}
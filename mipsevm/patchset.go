@@ -0,0 +1,200 @@
+package mipsevm
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ConstPatch replaces a function with one that returns a constant value.
+type ConstPatch struct {
+	Symbol string `json:"symbol" yaml:"symbol"`
+	Value  uint32 `json:"value" yaml:"value"`
+	// StackOffset, if set, is the offset relative to the stack pointer at function entry to write the value to,
+	// for functions that return their results on the stack, like Go ABI0 functions do.
+	// For Go functions the offset is 4 + the size of the arguments.
+	// If not set, the value is returned in $v0, like C functions do.
+	StackOffset *uint32 `json:"stackOffset,omitempty" yaml:"stackOffset,omitempty"`
+}
+
+// PatchSet describes a set of patches to apply to a program, to disable functionality that the VM does not support.
+type PatchSet struct {
+	Name string `json:"name" yaml:"name"`
+	// Ret lists the functions to replace with an immediate return.
+	Ret []string `json:"ret,omitempty" yaml:"ret,omitempty"`
+	// ReturnConst lists the functions to replace with an immediate return of a constant value.
+	ReturnConst []ConstPatch `json:"returnConst,omitempty" yaml:"returnConst,omitempty"`
+	// Zero lists the data symbols to overwrite with zeroes.
+	Zero []string `json:"zero,omitempty" yaml:"zero,omitempty"`
+}
+
+// PatchReport records which symbols of a PatchSet were patched, and which were not found in the program.
+type PatchReport struct {
+	Set     *PatchSet `json:"set"`
+	Applied []string  `json:"applied"`
+	Missing []string  `json:"missing"`
+}
+
+// GoPatchSet disables the Go garbage collector and other Go runtime functionality that the VM does not support.
+var GoPatchSet = &PatchSet{
+	Name: "go",
+	Ret: []string{
+		// Disable Golang GC by patching the functions that enable the GC to a no-op function.
+		"runtime.gcenable",
+		"runtime.init.5",            // patch out: init() { go forcegchelper() }
+		"runtime.main.func1",        // patch out: main.func() { newm(sysmon, ....) }
+		"runtime.deductSweepCredit", // uses floating point nums and interacts with gc we disabled
+		"runtime.(*gcControllerState).commit",
+		// We need to patch this out, we don't pass float64nan because we don't support floats
+		"runtime.check",
+	},
+	Zero: []string{
+		"runtime.MemProfileRate", // disable mem profiling, to avoid a lot of unnecessary floating point ops
+	},
+}
+
+// PrometheusPatchSet disables the init functions of the prometheus packages,
+// which rely on concurrent background things. We cannot run those.
+var PrometheusPatchSet = &PatchSet{
+	Name: "prometheus",
+	Ret: []string{
+		"github.com/prometheus/client_golang/prometheus.init",
+		"github.com/prometheus/client_golang/prometheus.init.0",
+		"github.com/prometheus/procfs.init",
+		"github.com/prometheus/common/model.init",
+		"github.com/prometheus/client_model/go.init",
+		"github.com/prometheus/client_model/go.init.0",
+		"github.com/prometheus/client_model/go.init.1",
+	},
+}
+
+// BuiltinPatchSets are the patch sets that can be selected by name.
+var BuiltinPatchSets = map[string]*PatchSet{
+	GoPatchSet.Name:         GoPatchSet,
+	PrometheusPatchSet.Name: PrometheusPatchSet,
+}
+
+// LoadPatchSet returns the built-in patch set with the given name,
+// or else loads the patch set from the given JSON or YAML file path.
+func LoadPatchSet(nameOrPath string) (*PatchSet, error) {
+	if ps, ok := BuiltinPatchSets[nameOrPath]; ok {
+		return ps, nil
+	}
+	data, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read patch set file %q: %w", nameOrPath, err)
+	}
+	var ps PatchSet
+	switch filepath.Ext(nameOrPath) {
+	case ".json":
+		err = json.Unmarshal(data, &ps)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &ps)
+	default:
+		return nil, fmt.Errorf("unrecognized patch set %q, expected a built-in patch set name or a .json/.yaml file", nameOrPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode patch set file %q: %w", nameOrPath, err)
+	}
+	if ps.Name == "" {
+		ps.Name = filepath.Base(nameOrPath)
+	}
+	return &ps, nil
+}
+
+// retPatch is the MIPS32 code for an immediate return:
+// 03e00008 = jr $ra = ret (pseudo instruction)
+// 00000000 = nop (executes with delay-slot, but does nothing)
+var retPatch = []uint32{0x03e00008, 0}
+
+func returnConstPatch(p *ConstPatch) []uint32 {
+	hi, lo := p.Value>>16, p.Value&0xFFFF
+	if p.StackOffset == nil {
+		return []uint32{
+			0x3c020000 | hi, // lui $v0, hi
+			0x03e00008,      // jr $ra
+			0x34420000 | lo, // ori $v0, $v0, lo (delay slot)
+		}
+	}
+	return []uint32{
+		0x3c010000 | hi,                    // lui $at, hi
+		0x34210000 | lo,                    // ori $at, $at, lo
+		0x03e00008,                         // jr $ra
+		0xafa10000 | *p.StackOffset&0xFFFF, // sw $at, offset($sp) (delay slot)
+	}
+}
+
+// ApplyPatchSet patches the symbols of the patch set in the VM state, and reports which symbols were applied and missing.
+// An error is returned if the program has no symbols, if a function is too small to fit the patch,
+// or if a data symbol to zero has no size, since the patch would have no effect.
+func ApplyPatchSet(f *elf.File, st *State, ps *PatchSet) (*PatchReport, error) {
+	symbols, err := f.Symbols()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read symbols data, cannot patch program: %w", err)
+	}
+	bySymbol := make(map[string][]elf.Symbol)
+	for _, s := range symbols {
+		bySymbol[s.Name] = append(bySymbol[s.Name], s)
+	}
+
+	report := &PatchReport{Set: ps, Applied: []string{}, Missing: []string{}}
+	patch := func(name string, code []uint32, zero bool) error {
+		syms, ok := bySymbol[name]
+		if !ok {
+			report.Missing = append(report.Missing, name)
+			return nil
+		}
+		for _, s := range syms {
+			var dat []byte
+			if zero {
+				if s.Size == 0 {
+					return fmt.Errorf("data symbol %s has no size, cannot zero it", name)
+				}
+				dat = make([]byte, s.Size)
+			} else {
+				if s.Size != 0 && s.Size < uint64(len(code)*4) {
+					return fmt.Errorf("function %s of %d bytes is too small for patch of %d bytes", name, s.Size, len(code)*4)
+				}
+				dat = make([]byte, len(code)*4)
+				for i, insn := range code {
+					binary.BigEndian.PutUint32(dat[i*4:], insn)
+				}
+			}
+			if err := st.Memory.SetMemoryRange(uint32(s.Value), bytes.NewReader(dat)); err != nil {
+				return fmt.Errorf("failed to patch %s: %w", name, err)
+			}
+		}
+		report.Applied = append(report.Applied, name)
+		return nil
+	}
+
+	for _, name := range ps.Ret {
+		if err := patch(name, retPatch, false); err != nil {
+			return nil, err
+		}
+	}
+	for i := range ps.ReturnConst {
+		p := &ps.ReturnConst[i]
+		if p.StackOffset != nil && *p.StackOffset > 0x7FFF {
+			return nil, fmt.Errorf("stack offset %d of %s does not fit in a store instruction", *p.StackOffset, p.Symbol)
+		}
+		if err := patch(p.Symbol, returnConstPatch(p), false); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range ps.Zero {
+		if err := patch(name, nil, true); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(report.Applied)
+	sort.Strings(report.Missing)
+	return report, nil
+}
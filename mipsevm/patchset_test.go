@@ -0,0 +1,161 @@
+package mipsevm
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadPatchSet(t *testing.T) {
+	ps, err := LoadPatchSet("go")
+	require.NoError(t, err)
+	require.Equal(t, GoPatchSet, ps)
+
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "custom.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`
+name: custom
+ret:
+  - example.com/metrics.init
+returnConst:
+  - symbol: example.com/cpu.count
+    value: 0x1
+    stackOffset: 4
+  - symbol: getpid
+    value: 42
+zero:
+  - example.com/metrics.enabled
+`), 0644))
+	ps, err = LoadPatchSet(yamlPath)
+	require.NoError(t, err)
+	require.Equal(t, "custom", ps.Name)
+	require.Equal(t, []string{"example.com/metrics.init"}, ps.Ret)
+	require.Len(t, ps.ReturnConst, 2)
+	require.Equal(t, uint32(1), ps.ReturnConst[0].Value)
+	require.Equal(t, uint32(4), *ps.ReturnConst[0].StackOffset)
+	require.Nil(t, ps.ReturnConst[1].StackOffset)
+	require.Equal(t, []string{"example.com/metrics.enabled"}, ps.Zero)
+
+	jsonPath := filepath.Join(dir, "other.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"ret": ["foo"]}`), 0644))
+	ps, err = LoadPatchSet(jsonPath)
+	require.NoError(t, err)
+	require.Equal(t, "other.json", ps.Name, "name defaults to file name")
+	require.Equal(t, []string{"foo"}, ps.Ret)
+
+	_, err = LoadPatchSet("unknown")
+	require.Error(t, err)
+}
+
+func TestReturnConstPatch(t *testing.T) {
+	run := func(t *testing.T, p *ConstPatch) *State {
+		code := returnConstPatch(p)
+		dat := make([]byte, len(code)*4)
+		for i, insn := range code {
+			binary.BigEndian.PutUint32(dat[i*4:], insn)
+		}
+		state := &State{PC: 0x1000, NextPC: 0x1004, Memory: NewMemory()}
+		require.NoError(t, state.Memory.SetMemoryRange(0x1000, bytes.NewReader(dat)))
+		require.NoError(t, state.Memory.SetMemoryRange(0x7000, bytes.NewReader(make([]byte, 64))))
+		state.Registers[29] = 0x7000
+		state.Registers[31] = 0x2000
+		us := NewInstrumentedState(state, nil, os.Stdout, os.Stderr)
+		for state.PC != 0x2000 {
+			_, err := us.Step(false)
+			require.NoError(t, err)
+		}
+		return state
+	}
+	t.Run("register", func(t *testing.T) {
+		state := run(t, &ConstPatch{Value: 0xdeadbeef})
+		require.Equal(t, uint32(0xdeadbeef), state.Registers[2])
+	})
+	t.Run("stack", func(t *testing.T) {
+		offset := uint32(12)
+		state := run(t, &ConstPatch{Value: 0x12345678, StackOffset: &offset})
+		require.Equal(t, uint32(0x12345678), state.Memory.GetMemory(0x7000+12))
+		require.Equal(t, uint32(0), state.Registers[2])
+	})
+}
+
+// symbolsELF returns an ELF file with only a symbol table, of absolute data symbols.
+func symbolsELF(t *testing.T, syms []elf.Symbol) *elf.File {
+	strtab := []byte{0}
+	symtab := make([]elf.Sym32, 1, 1+len(syms))
+	for _, sym := range syms {
+		symtab = append(symtab, elf.Sym32{
+			Name:  uint32(len(strtab)),
+			Value: uint32(sym.Value),
+			Size:  uint32(sym.Size),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_OBJECT),
+			Shndx: uint16(elf.SHN_ABS),
+		})
+		strtab = append(append(strtab, sym.Name...), 0)
+	}
+	shstrtab := []byte("\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+	var data bytes.Buffer
+	data.Write(make([]byte, 52)) // ELF header, written last
+	symtabOff := data.Len()
+	require.NoError(t, binary.Write(&data, binary.BigEndian, symtab))
+	strtabOff := data.Len()
+	data.Write(strtab)
+	shstrtabOff := data.Len()
+	data.Write(shstrtab)
+	shoff := data.Len()
+	require.NoError(t, binary.Write(&data, binary.BigEndian, []elf.Section32{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_SYMTAB), Off: uint32(symtabOff), Size: uint32(len(symtab) * 16), Link: 2, Info: 1, Entsize: 16},
+		{Name: 9, Type: uint32(elf.SHT_STRTAB), Off: uint32(strtabOff), Size: uint32(len(strtab))},
+		{Name: 17, Type: uint32(elf.SHT_STRTAB), Off: uint32(shstrtabOff), Size: uint32(len(shstrtab))},
+	}))
+
+	hdr := elf.Header32{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_MIPS),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint32(shoff),
+		Ehsize:    52,
+		Shentsize: 40,
+		Shnum:     4,
+		Shstrndx:  3,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS32)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2MSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	var buf bytes.Buffer
+	require.NoError(t, binary.Write(&buf, binary.BigEndian, hdr))
+	img := data.Bytes()
+	copy(img, buf.Bytes())
+	f, err := elf.NewFile(bytes.NewReader(img))
+	require.NoError(t, err)
+	return f
+}
+
+func TestApplyPatchSet(t *testing.T) {
+	f := symbolsELF(t, []elf.Symbol{
+		{Name: "enabled", Value: 0x1000, Size: 8},
+		{Name: "marker", Value: 0x2000},
+	})
+	state := &State{Memory: NewMemory()}
+	state.Memory.SetMemory(0x1000, 1)
+	state.Memory.SetMemory(0x1004, 2)
+	state.Memory.SetMemory(0x1008, 3)
+
+	report, err := ApplyPatchSet(f, state, &PatchSet{Zero: []string{"enabled", "disabled"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"enabled"}, report.Applied)
+	require.Equal(t, []string{"disabled"}, report.Missing)
+	require.Equal(t, uint32(0), state.Memory.GetMemory(0x1000))
+	require.Equal(t, uint32(0), state.Memory.GetMemory(0x1004))
+	require.Equal(t, uint32(3), state.Memory.GetMemory(0x1008), "only the size of the symbol is zeroed")
+
+	_, err = ApplyPatchSet(f, state, &PatchSet{Zero: []string{"marker"}})
+	require.ErrorContains(t, err, "data symbol marker has no size")
+}
@@ -0,0 +1,160 @@
+package mipsevm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// PreimageLoadLimits bound each transaction that loads a pre-image into the oracle contract.
+type PreimageLoadLimits struct {
+	// MaxCalldata is the maximum calldata size of a transaction, in bytes.
+	MaxCalldata uint64
+	// MaxGas is the maximum gas of a transaction, including the intrinsic gas.
+	MaxGas uint64
+}
+
+// DefaultPreimageLoadLimits stay below the default transaction size limit of nodes, and the block gas limit.
+var DefaultPreimageLoadLimits = PreimageLoadLimits{
+	MaxCalldata: 120_000,
+	MaxGas:      25_000_000,
+}
+
+const (
+	// keccakRate is the number of bytes that the keccak256 sponge absorbs per permutation
+	keccakRate = 136
+
+	txGas              = 21_000
+	calldataGasPerByte = 16
+	// estimated gas of the storage writes of a call to the oracle
+	oracleStorageGas = 150_000
+	// estimated gas of absorbing a block into the keccak256 sponge of a large pre-image, in the oracle contract.
+	// This is not measured yet: it counts about 20k gas per round of keccakF without the optimizer,
+	// for ~400 bounds-checked memory array accesses, index arithmetic and rotl calls, over 24 rounds,
+	// with a margin on top. TestLargePreimageEVM checks each absorb call against it,
+	// and logs the measured gas per block of the compiled contract, to set it from.
+	largePreimageBlockGas = 600_000
+
+	// size of the calldata of a large pre-image chunk call, excluding the chunk:
+	// selector, key, chunk offset and chunk length
+	largePreimageChunkOverhead = 4 + 32*3
+)
+
+// estimateLoadGas estimates the gas of a transaction that loads the pre-image in a single call.
+func estimateLoadGas(input []byte) uint64 {
+	words := uint64(len(input)+31) / 32
+	memoryGas := words*3 + words*words/512
+	hashGas := 30 + words*6
+	return txGas + uint64(len(input))*calldataGasPerByte + memoryGas + hashGas + oracleStorageGas
+}
+
+// largePreimageChunkSize returns the largest chunk of a large pre-image that a transaction can absorb within the limits.
+func largePreimageChunkSize(limits PreimageLoadLimits) uint64 {
+	if limits.MaxCalldata <= largePreimageChunkOverhead || limits.MaxGas <= txGas+oracleStorageGas {
+		return 0
+	}
+	size := limits.MaxCalldata - largePreimageChunkOverhead
+	blocks := (limits.MaxGas - txGas - oracleStorageGas) / (keccakRate*calldataGasPerByte + largePreimageBlockGas)
+	if blocks*keccakRate < size {
+		size = blocks * keccakRate
+	}
+	return size - size%keccakRate
+}
+
+func encodeLargePreimageChunk(selector []byte, key [32]byte, chunk []byte) []byte {
+	var input []byte
+	input = append(input, selector...)
+	input = append(input, key[:]...)
+	input = append(input, uint32ToBytes32(32+32)...) // chunk calldata offset
+	input = append(input, uint32ToBytes32(uint32(len(chunk)))...)
+	input = append(input, chunk...)
+	return input
+}
+
+// PlanPreimageOracleInput returns the ordered oracle calls that prepare the pre-image part of the step, each within the limits.
+// The pre-image is loaded with the single call of EncodePreimageOracleInput if it fits.
+// Larger keccak256 pre-images are loaded in chunks instead: an init call that claims the part,
+// absorb calls with the chunks of the pre-image, and a finalize call that checks the pre-image against the key.
+func (wit *StepWitness) PlanPreimageOracleInput(limits PreimageLoadLimits) ([][]byte, error) {
+	input, err := wit.EncodePreimageOracleInput()
+	if err != nil {
+		return nil, err
+	}
+	keyType := preimage.KeyType(wit.PreimageKey[0])
+	if keyType == preimage.LocalKeyType || (uint64(len(input)) <= limits.MaxCalldata && estimateLoadGas(input) <= limits.MaxGas) {
+		return [][]byte{input}, nil
+	}
+	data := wit.PreimageValue[8:]
+	if keyType != preimage.Keccak256KeyType {
+		return nil, fmt.Errorf("pre-image %x of %d bytes does not fit in a single transaction, and only keccak256 pre-images can be loaded in chunks",
+			wit.PreimageKey, len(data))
+	}
+	chunkSize := largePreimageChunkSize(limits)
+	if chunkSize == 0 {
+		return nil, fmt.Errorf("limits %+v are too small to load a pre-image in chunks", limits)
+	}
+
+	var part [32]byte
+	copy(part[:], wit.PreimageValue[wit.PreimageOffset:])
+	var init []byte
+	init = append(init, InitLargeKeccak256PreimageBytes4...)
+	init = append(init, uint32ToBytes32(wit.PreimageOffset)...)
+	init = append(init, wit.PreimageKey[:]...)
+	init = append(init, part[:]...)
+	init = append(init, uint32ToBytes32(uint32(len(data)))...)
+	calls := [][]byte{init}
+	// the finalize call pads the tail with an extra block, so the tail is kept smaller than a chunk
+	for uint64(len(data)) >= chunkSize {
+		calls = append(calls, encodeLargePreimageChunk(AbsorbLargeKeccak256PreimageBytes4, wit.PreimageKey, data[:chunkSize]))
+		data = data[chunkSize:]
+	}
+	calls = append(calls, encodeLargePreimageChunk(FinalizeLargeKeccak256PreimageBytes4, wit.PreimageKey, data))
+	return calls, nil
+}
+
+// SimulatePreimageOracleInput applies the oracle calls in the EVM, as created by NewEVMEnv, and checks that the
+// pre-image part of the step is then available to the step. It returns the gas of each call, excluding the intrinsic gas.
+func SimulatePreimageOracleInput(env *vm.EVM, addrs *Addresses, wit *StepWitness, calls [][]byte) ([]uint64, error) {
+	if !wit.HasPreimage() {
+		return nil, errors.New("witness has no pre-image to prepare")
+	}
+	gasLimit := env.Context.GasLimit
+	var gasUsed []uint64
+	for i, input := range calls {
+		_, leftOverGas, err := env.Call(vm.AccountRef(addrs.Sender), addrs.Oracle, input, gasLimit, big.NewInt(0))
+		if err != nil {
+			return nil, fmt.Errorf("oracle call %d of %d failed, after %d gas: %w", i, len(calls), gasLimit-leftOverGas, err)
+		}
+		gasUsed = append(gasUsed, gasLimit-leftOverGas)
+	}
+
+	var input []byte
+	input = append(input, ReadPreimageBytes4...)
+	input = append(input, wit.PreimageKey[:]...)
+	input = append(input, uint32ToBytes32(wit.PreimageOffset)...)
+	ret, _, err := env.StaticCall(vm.AccountRef(addrs.MIPS), addrs.Oracle, input, gasLimit)
+	if err != nil {
+		return nil, fmt.Errorf("pre-image part is not available: %w", err)
+	}
+	if len(ret) != 64 {
+		return nil, fmt.Errorf("unexpected readPreimage result of %d bytes", len(ret))
+	}
+	var part [32]byte
+	copy(part[:], wit.PreimageValue[wit.PreimageOffset:])
+	if !bytes.Equal(ret[:32], part[:]) {
+		return nil, fmt.Errorf("oracle has pre-image part %x, expected %x", ret[:32], part)
+	}
+	partLen := uint32(len(wit.PreimageValue)) - wit.PreimageOffset
+	if partLen > 32 {
+		partLen = 32
+	}
+	if !bytes.Equal(ret[32:], uint32ToBytes32(partLen)) {
+		return nil, fmt.Errorf("oracle has pre-image part length %x, expected %d", ret[32:], partLen)
+	}
+	return gasUsed, nil
+}
@@ -0,0 +1,82 @@
+package mipsevm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// preimageWitness returns a witness that reads the pre-image of the key at the given offset.
+func preimageWitness(key [32]byte, data []byte, offset uint32) *StepWitness {
+	value := binary.BigEndian.AppendUint64(nil, uint64(len(data)))
+	return &StepWitness{
+		PreimageKey:    key,
+		PreimageValue:  append(value, data...),
+		PreimageOffset: offset,
+	}
+}
+
+func TestPlanPreimageOracleInput(t *testing.T) {
+	t.Run("small", func(t *testing.T) {
+		data := []byte("hello world")
+		wit := preimageWitness(preimage.Keccak256Key(crypto.Keccak256Hash(data)).PreimageKey(), data, 8)
+		calls, err := wit.PlanPreimageOracleInput(DefaultPreimageLoadLimits)
+		require.NoError(t, err)
+		input, err := wit.EncodePreimageOracleInput()
+		require.NoError(t, err)
+		require.Equal(t, [][]byte{input}, calls)
+	})
+	t.Run("large", func(t *testing.T) {
+		data := make([]byte, 300_000)
+		rand.New(rand.NewSource(1234)).Read(data)
+		key := preimage.Keccak256Key(crypto.Keccak256Hash(data)).PreimageKey()
+		wit := preimageWitness(key, data, 100_000)
+		calls, err := wit.PlanPreimageOracleInput(DefaultPreimageLoadLimits)
+		require.NoError(t, err)
+		require.Greater(t, len(calls), 3)
+
+		init, err := DecodePreimageOracleInput(calls[0])
+		require.NoError(t, err)
+		require.Equal(t, "initLargeKeccak256Preimage", init.Method)
+		require.Equal(t, common.Hash(key), init.Key)
+		require.Equal(t, uint32(100_000), init.PartOffset)
+		require.Equal(t, data[100_000-8:100_000+24], []byte(init.Part))
+		require.Equal(t, uint64(len(data)), init.Size)
+
+		var loaded []byte
+		for i, call := range calls[1:] {
+			require.LessOrEqual(t, uint64(len(call)), DefaultPreimageLoadLimits.MaxCalldata)
+			chunk, err := DecodePreimageOracleInput(call)
+			require.NoError(t, err)
+			require.Equal(t, common.Hash(key), chunk.Key)
+			if i == len(calls)-2 {
+				require.Equal(t, "finalizeLargeKeccak256Preimage", chunk.Method)
+			} else {
+				require.Equal(t, "absorbLargeKeccak256Preimage", chunk.Method)
+				require.Zero(t, len(chunk.Chunk)%keccakRate, "absorbed chunks are full blocks")
+			}
+			loaded = append(loaded, chunk.Chunk...)
+		}
+		require.True(t, bytes.Equal(data, loaded), "chunks make up the pre-image")
+	})
+	t.Run("large local", func(t *testing.T) {
+		// local pre-image parts are cheated in, regardless of the size of the pre-image
+		wit := preimageWitness(preimage.LocalIndexKey(0).PreimageKey(), make([]byte, 300_000), 0)
+		calls, err := wit.PlanPreimageOracleInput(DefaultPreimageLoadLimits)
+		require.NoError(t, err)
+		require.Len(t, calls, 1)
+	})
+	t.Run("large sha256", func(t *testing.T) {
+		data := make([]byte, 300_000)
+		wit := preimageWitness(preimage.Sha256Key(preimage.Sha256(data)).PreimageKey(), data, 0)
+		_, err := wit.PlanPreimageOracleInput(DefaultPreimageLoadLimits)
+		require.ErrorContains(t, err, "only keccak256 pre-images can be loaded in chunks")
+	})
+}
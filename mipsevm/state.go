@@ -7,6 +7,9 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 )
 
+// StateWitnessSize is the size of the packed state witness.
+const StateWitnessSize = 32 + 32 + 4*6 + 1 + 1 + 8 + 32*4
+
 type State struct {
 	Memory *Memory `json:"memory"`
 
@@ -27,19 +30,27 @@ type State struct {
 	Registers [32]uint32 `json:"registers"`
 
 	// LastHint is optional metadata, and not part of the VM state itself.
-	// It is used to remember the last pre-image hint,
-	// so a VM can start from any state without fetching prior pre-images,
-	// and instead just repeat the last hint on setup,
-	// to make sure pre-image requests can be served.
+	// It buffers the hint data that the program wrote, until it forms a complete hint.
 	// The first 4 bytes are a uin32 length prefix.
 	// Warning: the hint MAY NOT BE COMPLETE. I.e. this is buffered,
-	// and should only be read when len(LastHint) > 4 && uint32(LastHint[:4]) >= len(LastHint[4:])
+	// and should only be read when len(LastHint) > 4 && uint32(LastHint[:4]) <= len(LastHint[4:])
 	LastHint hexutil.Bytes `json:"lastHint,omitempty"`
+
+	// PrevHint is optional metadata, and not part of the VM state itself.
+	// It is the last complete hint that the program sent, without the length prefix,
+	// so a VM can start from any state without fetching prior pre-images,
+	// and instead just repeat the last hint on setup with ReplayHint,
+	// to make sure pre-image requests can be served.
+	PrevHint hexutil.Bytes `json:"prevHint,omitempty"`
 }
 
 func (s *State) EncodeWitness() []byte {
-	out := make([]byte, 0)
-	memRoot := s.Memory.MerkleRoot()
+	return s.encodeWitness(s.Memory.MerkleRoot())
+}
+
+// encodeWitness encodes the state witness with the given memory root, instead of the root of the state memory.
+func (s *State) encodeWitness(memRoot [32]byte) []byte {
+	out := make([]byte, 0, StateWitnessSize)
 	out = append(out, memRoot[:]...)
 	out = append(out, s.PreimageKey[:]...)
 	out = binary.BigEndian.AppendUint32(out, s.PreimageOffset)
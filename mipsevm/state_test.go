@@ -77,7 +77,7 @@ func TestHello(t *testing.T) {
 
 	err = PatchGo(elfProgram, state)
 	require.NoError(t, err, "apply Go runtime patches")
-	require.NoError(t, PatchStack(state), "add initial stack")
+	require.NoError(t, PatchStack(state, nil), "add initial stack")
 
 	var stdOutBuf, stdErrBuf bytes.Buffer
 	us := NewInstrumentedState(state, nil, io.MultiWriter(&stdOutBuf, os.Stdout), io.MultiWriter(&stdErrBuf, os.Stderr))
@@ -175,7 +175,7 @@ func TestClaim(t *testing.T) {
 
 	err = PatchGo(elfProgram, state)
 	require.NoError(t, err, "apply Go runtime patches")
-	require.NoError(t, PatchStack(state), "add initial stack")
+	require.NoError(t, PatchStack(state, nil), "add initial stack")
 
 	oracle, expectedStdOut, expectedStdErr := claimTestOracle(t)
 
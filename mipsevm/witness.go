@@ -10,11 +10,27 @@ import (
 	"github.com/ethereum-optimism/cannon/preimage"
 )
 
+// StepWitness is the witness of a single step, to prove the step onchain.
+//
+// The memory proofs of a step are ordered by access, without an explicit proof index in the step input:
+//   - proof 0 is the proof of the instruction, at the PC.
+//   - each access of a memory word gets the next proof, taken right before the access,
+//     which proves the memory as changed by the earlier accesses of the step.
+//   - an access of the same word as the previous access reuses the proof of the previous access.
+//
+// The MIPS contract accesses memory in the same order as the Go VM, so it knows the proof index of each access
+// from the instruction or syscall, e.g. proof 1 for the word of a load or store, or proof 1+i for iovec i of writev.
+// An explicit index in the step input would have to be checked against this same order, so it is left out.
 type StepWitness struct {
 	// encoded state witness
 	State []byte
 
+	// MemProof is the memory proof of the instruction, followed by the memory proofs of the accesses,
+	// in the order of StepWitness.
 	MemProof []byte
+	// MemAccess are the addresses of the memory words that the step accessed, in order of access.
+	// Memory proof i+1 is the proof of access i, taken right before the access.
+	MemAccess []uint32
 
 	PreimageKey    [32]byte // zeroed when no pre-image is accessed
 	PreimageValue  []byte   // including the 8-byte length prefix
@@ -67,9 +83,13 @@ func (wit *StepWitness) EncodePreimageOracleInput() ([]byte, error) {
 		input = append(input, uint32ToBytes32(uint32(len(wit.PreimageValue))-8)...)
 		// TODO: do we want to pad the end to a multiple of 32 bytes?
 		return input, nil
-	case preimage.Keccak256KeyType:
+	case preimage.Keccak256KeyType, preimage.Sha256KeyType:
 		var input []byte
-		input = append(input, LoadKeccak256PreimagePartBytes4...)
+		if preimage.KeyType(wit.PreimageKey[0]) == preimage.Sha256KeyType {
+			input = append(input, LoadSha256PreimagePartBytes4...)
+		} else {
+			input = append(input, LoadKeccak256PreimagePartBytes4...)
+		}
 		input = append(input, uint32ToBytes32(wit.PreimageOffset)...)
 		input = append(input, uint32ToBytes32(32+32)...) // partOffset, calldata offset
 		input = append(input, uint32ToBytes32(uint32(len(wit.PreimageValue))-8)...)
@@ -1,8 +1,12 @@
 package preimage
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"time"
 )
 
 // FileChannel is a unidirectional channel for file I/O
@@ -40,6 +44,14 @@ func (rw *ReadWritePair) Writer() *os.File {
 	return rw.w
 }
 
+// SetDeadline sets the read and write deadlines of the underlying files.
+func (rw *ReadWritePair) SetDeadline(t time.Time) error {
+	if err := rw.r.SetDeadline(t); err != nil {
+		return err
+	}
+	return rw.w.SetDeadline(t)
+}
+
 func (rw *ReadWritePair) Close() error {
 	if err := rw.r.Close(); err != nil {
 		return err
@@ -47,6 +59,67 @@ func (rw *ReadWritePair) Close() error {
 	return rw.w.Close()
 }
 
+// DeadlineChannel is a channel that supports I/O deadlines, like a ReadWritePair of pipes, or a network connection.
+// The I/O of the context-aware clients can only be interrupted on channels that support deadlines.
+type DeadlineChannel interface {
+	SetDeadline(t time.Time) error
+}
+
+// withContext runs fn, which does I/O on rw, until it completes or the context is done.
+// If the channel supports deadlines, the deadline of the context is applied to it,
+// and the I/O is interrupted when the context is cancelled.
+// Otherwise the I/O is left running when the context is done, and the channel should not be used anymore.
+func withContext(ctx context.Context, rw io.ReadWriter, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ctx.Done() == nil { // the context can never be done
+		return fn()
+	}
+	dc, ok := rw.(DeadlineChannel)
+	if !ok {
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- fn()
+		}()
+		select {
+		case err := <-errCh:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := dc.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("failed to set channel deadline: %w", err)
+		}
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = dc.SetDeadline(time.Unix(1, 0)) // a deadline in the past interrupts any pending I/O
+		case <-stop:
+		}
+	}()
+	err := fn()
+	close(stop)
+	<-stopped
+	_ = dc.SetDeadline(time.Time{})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("%w: %w", ctxErr, err)
+		}
+		// the channel deadline may expire just before the context notices its deadline
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+		}
+	}
+	return err
+}
+
 // CreateBidirectionalChannel creates a pair of FileChannels that are connected to each other.
 func CreateBidirectionalChannel() (FileChannel, FileChannel, error) {
 	ar, bw, err := os.Pipe()
@@ -1,6 +1,11 @@
 package preimage
 
-import "golang.org/x/crypto/sha3"
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"golang.org/x/crypto/sha3"
+)
 
 func Keccak256(v []byte) (out [32]byte) {
 	s := sha3.NewLegacyKeccak256()
@@ -8,3 +13,27 @@ func Keccak256(v []byte) (out [32]byte) {
 	s.Sum(out[:0])
 	return
 }
+
+func Sha256(v []byte) [32]byte {
+	return sha256.Sum256(v)
+}
+
+// VerifyPreimage checks that the pre-image matches the key, for the key types that commit to the pre-image.
+// Local keys are specific to the program instance, and cannot be verified.
+func VerifyPreimage(key [32]byte, preimage []byte) error {
+	switch KeyType(key[0]) {
+	case LocalKeyType:
+		return nil
+	case Keccak256KeyType:
+		if Keccak256Key(Keccak256(preimage)).PreimageKey() != key {
+			return fmt.Errorf("pre-image does not match keccak256 key %x", key)
+		}
+	case Sha256KeyType:
+		if Sha256Key(Sha256(preimage)).PreimageKey() != key {
+			return fmt.Errorf("pre-image does not match sha256 key %x", key)
+		}
+	default:
+		return fmt.Errorf("unsupported pre-image key type %d", key[0])
+	}
+	return nil
+}
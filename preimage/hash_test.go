@@ -0,0 +1,29 @@
+package preimage
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSha256Key(t *testing.T) {
+	data := []byte("hello world")
+	h := sha256.Sum256(data)
+	key := Sha256Key(Sha256(data)).PreimageKey()
+	require.Equal(t, byte(Sha256KeyType), key[0])
+	require.Equal(t, h[1:], key[1:], "the rest of the key is the hash")
+}
+
+func TestVerifyPreimage(t *testing.T) {
+	data := []byte("hello world")
+	require.NoError(t, VerifyPreimage(Keccak256Key(Keccak256(data)).PreimageKey(), data))
+	require.NoError(t, VerifyPreimage(Sha256Key(Sha256(data)).PreimageKey(), data))
+	require.NoError(t, VerifyPreimage(LocalIndexKey(1).PreimageKey(), data), "local keys cannot be verified")
+
+	require.ErrorContains(t, VerifyPreimage(Keccak256Key(Keccak256(data)).PreimageKey(), data[1:]), "keccak256")
+	require.ErrorContains(t, VerifyPreimage(Sha256Key(Sha256(data)).PreimageKey(), data[1:]), "sha256")
+	// a sha256 hash is not a keccak256 key, even if the pre-image is right
+	require.Error(t, VerifyPreimage(Keccak256Key(Sha256(data)).PreimageKey(), data))
+	require.ErrorContains(t, VerifyPreimage([32]byte{4}, data), "unsupported pre-image key type 4")
+}
@@ -1,36 +1,110 @@
 package preimage
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 )
 
+// HintStatus is the status of a processed hint, which the hint reader acknowledges the hint with.
+// Hosts that do not report the status always acknowledge with HintOK.
+type HintStatus byte
+
+const (
+	// HintOK is the status of a hint that is processed, or of which the status is not reported.
+	HintOK HintStatus = 0
+	// HintFailed is the status of a hint that the host failed to prepare the pre-images of.
+	HintFailed HintStatus = 1
+	// HintUnknown is the status of a hint that the host does not know how to process.
+	HintUnknown HintStatus = 2
+)
+
+func (s HintStatus) String() string {
+	switch s {
+	case HintOK:
+		return "ok"
+	case HintFailed:
+		return "failed"
+	case HintUnknown:
+		return "unknown"
+	default:
+		return fmt.Sprintf("status(%d)", byte(s))
+	}
+}
+
+// ErrUnknownHint can be returned by the router of HintReader.NextHint,
+// to acknowledge the hint with the HintUnknown status.
+var ErrUnknownHint = errors.New("unknown hint")
+
 // HintWriter writes hints to an io.Writer (e.g. a special file descriptor, or a debug log),
 // for a pre-image oracle service to prepare specific pre-images.
 type HintWriter struct {
 	rw io.ReadWriter
+	// err is the error that broke the channel: after a failed hint the stream may be out of sync.
+	err error
 }
 
 var _ Hinter = (*HintWriter)(nil)
+var _ ContextHinter = (*HintWriter)(nil)
 
 func NewHintWriter(rw io.ReadWriter) *HintWriter {
 	return &HintWriter{rw: rw}
 }
 
+// Hint writes the hint and waits for it to be processed, and panics on any error.
 func (hw *HintWriter) Hint(v Hint) {
-	hint := v.Hint()
-	var hintBytes []byte
-	hintBytes = binary.BigEndian.AppendUint32(hintBytes, uint32(len(hint)))
-	hintBytes = append(hintBytes, []byte(hint)...)
-	_, err := hw.rw.Write(hintBytes)
+	if err := hw.HintContext(context.Background(), v); err != nil {
+		panic(err)
+	}
+}
+
+// HintContext writes the hint and waits for it to be processed, bound by the context.
+// After an error the channel is broken, and any later hint fails.
+func (hw *HintWriter) HintContext(ctx context.Context, v Hint) error {
+	_, err := hw.HintStatusContext(ctx, v)
+	return err
+}
+
+// HintStatus cannot deliver the status to a program in the VM: there it always returns HintOK,
+// see the hint response read of the VM in mipsevm, and the host of the VM fails on a failed hint instead.
+// Outside of the VM, e.g. in native tests of the program, it writes the hint,
+// and returns the status that the host acknowledged it with. It panics on any error.
+func (hw *HintWriter) HintStatus(v Hint) HintStatus {
+	status, err := hw.HintStatusContext(context.Background(), v)
 	if err != nil {
-		panic(fmt.Errorf("failed to write pre-image hint: %w", err))
+		panic(err)
+	}
+	return status
+}
+
+// HintStatusContext writes the hint, and returns the status that the host acknowledged it with, bound by the context.
+// Like HintStatus, it always returns HintOK to a program in the VM.
+// After an error the channel is broken, and any later hint fails.
+func (hw *HintWriter) HintStatusContext(ctx context.Context, v Hint) (HintStatus, error) {
+	if hw.err != nil {
+		return 0, fmt.Errorf("pre-image hint channel is broken: %w", hw.err)
 	}
-	_, err = hw.rw.Read([]byte{0})
+	var ack [1]byte
+	err := withContext(ctx, hw.rw, func() error {
+		hint := v.Hint()
+		var hintBytes []byte
+		hintBytes = binary.BigEndian.AppendUint32(hintBytes, uint32(len(hint)))
+		hintBytes = append(hintBytes, []byte(hint)...)
+		if _, err := hw.rw.Write(hintBytes); err != nil {
+			return fmt.Errorf("failed to write pre-image hint: %w", err)
+		}
+		if _, err := hw.rw.Read(ack[:]); err != nil {
+			return fmt.Errorf("failed to read pre-image hint ack: %w", err)
+		}
+		return nil
+	})
 	if err != nil {
-		panic(fmt.Errorf("failed to read pre-image hint ack: %w", err))
+		hw.err = err
+		return 0, err
 	}
+	return HintStatus(ack[0]), nil
 }
 
 // HintReader reads the hints of HintWriter and passes them to a router for preparation of the requested pre-images.
@@ -43,7 +117,27 @@ func NewHintReader(rw io.ReadWriter) *HintReader {
 	return &HintReader{rw: rw}
 }
 
+// NextHint reads the next hint, and passes it to the router. A failed hint is acknowledged with the HintFailed status,
+// or HintUnknown if the router returns ErrUnknownHint, and the error of the router is returned.
 func (hr *HintReader) NextHint(router func(hint string) error) error {
+	var routerErr error
+	err := hr.NextHintStatus(func(hint string) HintStatus {
+		routerErr = router(hint)
+		if errors.Is(routerErr, ErrUnknownHint) {
+			return HintUnknown
+		} else if routerErr != nil {
+			return HintFailed
+		}
+		return HintOK
+	})
+	if routerErr != nil {
+		return fmt.Errorf("failed to handle hint: %w", routerErr)
+	}
+	return err
+}
+
+// NextHintStatus reads the next hint, passes it to the router, and acknowledges it with the status of the router.
+func (hr *HintReader) NextHintStatus(router func(hint string) HintStatus) error {
 	var length uint32
 	if err := binary.Read(hr.rw, binary.BigEndian, &length); err != nil {
 		if err == io.EOF {
@@ -57,13 +151,9 @@ func (hr *HintReader) NextHint(router func(hint string) error) error {
 			return fmt.Errorf("failed to read hint payload (length %d): %w", length, err)
 		}
 	}
-	if err := router(string(payload)); err != nil {
-		// write back on error to unblock the HintWriter
-		_, _ = hr.rw.Write([]byte{0})
-		return fmt.Errorf("failed to handle hint: %w", err)
-	}
-	if _, err := hr.rw.Write([]byte{0}); err != nil {
-		return fmt.Errorf("failed to write trailing no-op byte to unblock hint writer: %w", err)
+	status := router(string(payload))
+	if _, err := hr.rw.Write([]byte{byte(status)}); err != nil {
+		return fmt.Errorf("failed to write hint status to unblock hint writer: %w", err)
 	}
 	return nil
 }
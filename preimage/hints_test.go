@@ -2,6 +2,7 @@ package preimage
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"errors"
 	"io"
@@ -133,3 +134,51 @@ func waitTimeout(wg *sync.WaitGroup) bool {
 		return false
 	}
 }
+
+func TestHintContext(t *testing.T) {
+	a, b, err := CreateBidirectionalChannel()
+	require.NoError(t, err)
+	defer a.Close()
+	defer b.Close()
+	hw := NewHintWriter(a)
+
+	go func() {
+		hr := NewHintReader(b)
+		_ = hr.NextHint(func(hint string) error { return nil })
+		// read the next hint, but never acknowledge it
+		_, _ = io.ReadFull(b, make([]byte, 4+len("two")))
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	require.NoError(t, hw.HintContext(ctx, rawHint("one")))
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = hw.HintContext(ctx, rawHint("two"))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.ErrorContains(t, hw.HintContext(context.Background(), rawHint("three")), "channel is broken")
+}
+
+func TestHintStatus(t *testing.T) {
+	a, b := bidirectionalPipe()
+	hw := NewHintWriter(a)
+	hr := NewHintReader(b)
+	cbErr := errors.New("fail")
+	results := make(chan error, 4)
+	go func() {
+		results <- hr.NextHint(func(hint string) error { return nil })
+		results <- hr.NextHint(func(hint string) error { return cbErr })
+		results <- hr.NextHint(func(hint string) error { return ErrUnknownHint })
+		results <- hr.NextHintStatus(func(hint string) HintStatus { return 42 })
+	}()
+	require.Equal(t, HintOK, hw.HintStatus(rawHint("one")))
+	require.NoError(t, <-results)
+	require.Equal(t, HintFailed, hw.HintStatus(rawHint("two")))
+	require.ErrorIs(t, <-results, cbErr, "the hint reader still reports the error")
+	require.Equal(t, HintUnknown, hw.HintStatus(rawHint("three")))
+	require.ErrorIs(t, <-results, ErrUnknownHint)
+	// writers that do not check the status are not affected
+	require.NoError(t, hw.HintContext(context.Background(), rawHint("four")))
+	require.NoError(t, <-results)
+	require.Equal(t, "status(42)", HintStatus(42).String())
+}
@@ -1,6 +1,7 @@
 package preimage
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 )
@@ -18,6 +19,13 @@ type Oracle interface {
 	Get(key Key) []byte
 }
 
+// ContextOracle is the error-returning form of Oracle, for host-side use,
+// where a failing or unresponsive pre-image server must not crash or block the host.
+type ContextOracle interface {
+	// GetContext gets the full pre-image of a given pre-image key, bound by the context.
+	GetContext(ctx context.Context, key Key) ([]byte, error)
+}
+
 type OracleFn func(key Key) []byte
 
 func (fn OracleFn) Get(key Key) []byte {
@@ -34,6 +42,8 @@ const (
 	LocalKeyType KeyType = 1
 	// Keccak256KeyType is for keccak256 pre-images, for any global shared pre-images.
 	Keccak256KeyType KeyType = 2
+	// Sha256KeyType is for sha256 pre-images, for any global shared pre-images committed to with sha256.
+	Sha256KeyType KeyType = 3
 )
 
 // LocalIndexKey is a key local to the program, indexing a special program input.
@@ -62,6 +72,25 @@ func (k Keccak256Key) TerminalString() string {
 	return "0x" + hex.EncodeToString(k[:])
 }
 
+// Sha256Key wraps a sha256 hash to use it as a typed pre-image key.
+// The pre-image oracle verifies the pre-image against the hash,
+// so a program can read sha256-committed data without computing sha256 itself.
+type Sha256Key [32]byte
+
+func (k Sha256Key) PreimageKey() (out [32]byte) {
+	out = k                      // copy the sha256 hash
+	out[0] = byte(Sha256KeyType) // apply prefix
+	return
+}
+
+func (k Sha256Key) String() string {
+	return "0x" + hex.EncodeToString(k[:])
+}
+
+func (k Sha256Key) TerminalString() string {
+	return "0x" + hex.EncodeToString(k[:])
+}
+
 // Hint is an interface to enable any program type to function as a hint,
 // when passed to the Hinter interface, returning a string representation
 // of what data the host should prepare pre-images for.
@@ -77,6 +106,12 @@ type Hinter interface {
 	Hint(v Hint)
 }
 
+// ContextHinter is the error-returning form of Hinter, for host-side use.
+type ContextHinter interface {
+	// HintContext writes the hint, bound by the context.
+	HintContext(ctx context.Context, v Hint) error
+}
+
 type HinterFn func(v Hint)
 
 func (fn HinterFn) Hint(v Hint) {
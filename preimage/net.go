@@ -0,0 +1,225 @@
+package preimage
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Over the network, a session of a VM run consists of two connections, one for hints and one for pre-images,
+// which use the same framing as the channels of a pre-image server process.
+// Each connection starts with a header: the kind of channel, and a random session ID to pair the two connections by.
+const (
+	hintChannelKind     byte = 1
+	preimageChannelKind byte = 2
+
+	sessionIDSize = 16
+)
+
+// handshakeTimeout bounds how long a connection may take to send its header, and to be paired with the other connection of its session.
+const handshakeTimeout = 10 * time.Second
+
+// ParseNetAddr parses a pre-image server address, "unix://<path>" or "tcp://<host>:<port>",
+// into the network and address to dial or listen on.
+func ParseNetAddr(addr string) (network string, address string, err error) {
+	scheme, rest, ok := strings.Cut(addr, "://")
+	if !ok {
+		return "", "", fmt.Errorf("pre-image server address %q has no scheme, expected unix:// or tcp://", addr)
+	}
+	switch scheme {
+	case "unix", "tcp":
+		if rest == "" {
+			return "", "", fmt.Errorf("pre-image server address %q is empty", addr)
+		}
+		return scheme, rest, nil
+	default:
+		return "", "", fmt.Errorf("unsupported pre-image server address scheme %q, expected unix:// or tcp://", scheme)
+	}
+}
+
+// DialChannels connects a new session to the pre-image server at the given address,
+// and returns the hint and pre-image channels of the session.
+func DialChannels(ctx context.Context, addr string) (hintCh net.Conn, preimageCh net.Conn, err error) {
+	network, address, err := ParseNetAddr(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	var id [sessionIDSize]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	var d net.Dialer
+	dial := func(kind byte) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to pre-image server: %w", err)
+		}
+		if _, err := conn.Write(append([]byte{kind}, id[:]...)); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to open session with pre-image server: %w", err)
+		}
+		return conn, nil
+	}
+	if hintCh, err = dial(hintChannelKind); err != nil {
+		return nil, nil, err
+	}
+	if preimageCh, err = dial(preimageChannelKind); err != nil {
+		_ = hintCh.Close()
+		return nil, nil, err
+	}
+	return hintCh, preimageCh, nil
+}
+
+// Listen listens for sessions at the given pre-image server address.
+func Listen(addr string) (net.Listener, error) {
+	network, address, err := ParseNetAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen(network, address)
+}
+
+// SessionServer serves the sessions of VM runs that connect over the network with DialChannels,
+// each with its own Server, such that a long-lived host can serve many VM runs.
+type SessionServer struct {
+	// NewServer creates the server of a new session.
+	NewServer func(hintCh, preimageCh io.ReadWriteCloser) *Server
+	// OnError is called with the error of each session that fails, if not nil.
+	OnError func(err error)
+}
+
+type halfSession struct {
+	kind byte
+	conn net.Conn
+}
+
+func (ss *SessionServer) fail(err error) {
+	if ss.OnError != nil {
+		ss.OnError(err)
+	}
+}
+
+// Serve accepts sessions from the listener, and serves them concurrently, until the context is done,
+// the listener is closed, or accepting fails with an error that is not temporary.
+// The listener is closed, and all sessions are stopped, when Serve returns.
+func (ss *SessionServer) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	var mu sync.Mutex
+	pending := make(map[[sessionIDSize]byte]halfSession)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for id, half := range pending {
+			_ = half.conn.Close()
+			delete(pending, id)
+		}
+	}()
+
+	// pair returns the other connection of the session, or nil if the connection is the first of its session.
+	pair := func(id [sessionIDSize]byte, kind byte, conn net.Conn) net.Conn {
+		mu.Lock()
+		defer mu.Unlock()
+		other, ok := pending[id]
+		if !ok {
+			pending[id] = halfSession{kind: kind, conn: conn}
+			time.AfterFunc(handshakeTimeout, func() {
+				mu.Lock()
+				defer mu.Unlock()
+				if half, ok := pending[id]; ok && half.conn == conn {
+					delete(pending, id)
+					_ = conn.Close()
+				}
+			})
+			return nil
+		}
+		delete(pending, id)
+		if other.kind == kind {
+			_ = other.conn.Close()
+			_ = conn.Close()
+			ss.fail(fmt.Errorf("session %x has two channels of kind %d", id, kind))
+			return nil
+		}
+		return other.conn
+	}
+
+	handle := func(conn net.Conn) {
+		var header [1 + sessionIDSize]byte
+		_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			_ = conn.Close()
+			ss.fail(fmt.Errorf("failed to read session header from %s: %w", conn.RemoteAddr(), err))
+			return
+		}
+		_ = conn.SetDeadline(time.Time{})
+		kind, id := header[0], [sessionIDSize]byte(header[1:])
+		if kind != hintChannelKind && kind != preimageChannelKind {
+			_ = conn.Close()
+			ss.fail(fmt.Errorf("unknown channel kind %d from %s", kind, conn.RemoteAddr()))
+			return
+		}
+		other := pair(id, kind, conn)
+		if other == nil {
+			return // the other connection of the session serves it
+		}
+		hintCh, preimageCh := conn, other
+		if kind == preimageChannelKind {
+			hintCh, preimageCh = other, conn
+		}
+		if err := ss.NewServer(hintCh, preimageCh).Serve(ctx); err != nil && ctx.Err() == nil {
+			ss.fail(fmt.Errorf("session %x failed: %w", id, err))
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer func() {
+		// stop the sessions before waiting for them, or Serve would block until each program is done
+		cancel()
+		wg.Wait()
+	}()
+	var retryDelay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			// like net/http, retry temporary errors such as running out of file descriptors, with backoff
+			if te, ok := err.(interface{ Temporary() bool }); ok && te.Temporary() {
+				if retryDelay == 0 {
+					retryDelay = 5 * time.Millisecond
+				} else if retryDelay *= 2; retryDelay > time.Second {
+					retryDelay = time.Second
+				}
+				ss.fail(fmt.Errorf("failed to accept connection, retrying in %s: %w", retryDelay, err))
+				select {
+				case <-time.After(retryDelay):
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		retryDelay = 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handle(conn)
+		}()
+	}
+}
@@ -0,0 +1,215 @@
+package preimage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNetAddr(t *testing.T) {
+	network, address, err := ParseNetAddr("unix:///tmp/preimage.sock")
+	require.NoError(t, err)
+	require.Equal(t, "unix", network)
+	require.Equal(t, "/tmp/preimage.sock", address)
+
+	network, address, err = ParseNetAddr("tcp://127.0.0.1:1234")
+	require.NoError(t, err)
+	require.Equal(t, "tcp", network)
+	require.Equal(t, "127.0.0.1:1234", address)
+
+	for _, addr := range []string{"/tmp/preimage.sock", "udp://127.0.0.1:1234", "unix://"} {
+		_, _, err := ParseNetAddr(addr)
+		require.Error(t, err, addr)
+	}
+}
+
+func TestSessionServer(t *testing.T) {
+	for _, addr := range []string{"unix://" + filepath.Join(t.TempDir(), "preimage.sock"), "tcp://127.0.0.1:0"} {
+		t.Run(addr, func(t *testing.T) {
+			l, err := Listen(addr)
+			require.NoError(t, err)
+			if network, _, _ := ParseNetAddr(addr); network == "tcp" {
+				addr = "tcp://" + l.Addr().String()
+			}
+
+			var mu sync.Mutex
+			hints := make(map[string]bool)
+			ss := &SessionServer{
+				NewServer: func(hintCh, preimageCh io.ReadWriteCloser) *Server {
+					s := NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+						return key[:], nil
+					})
+					s.HandleHint("", func(ctx context.Context, hint string) error {
+						mu.Lock()
+						defer mu.Unlock()
+						hints[hint] = true
+						return nil
+					})
+					return s
+				},
+				OnError: func(err error) {
+					t.Errorf("session failed: %v", err)
+				},
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			result := make(chan error, 1)
+			go func() {
+				result <- ss.Serve(ctx, l)
+			}()
+
+			// sessions are served concurrently, each with its own channels
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					hintCh, preimageCh, err := DialChannels(ctx, addr)
+					require.NoError(t, err)
+					defer hintCh.Close()
+					defer preimageCh.Close()
+					hint := fmt.Sprintf("session %d", i)
+					require.NoError(t, NewHintWriter(hintCh).HintContext(ctx, rawHint(hint)))
+					key := LocalIndexKey(i)
+					dat, err := NewOracleClient(preimageCh).GetContext(ctx, key)
+					require.NoError(t, err)
+					k := key.PreimageKey()
+					require.Equal(t, k[:], dat)
+				}(i)
+			}
+			wg.Wait()
+			require.Len(t, hints, 4)
+
+			cancel()
+			require.ErrorIs(t, <-result, context.Canceled)
+			_, _, err = DialChannels(context.Background(), addr)
+			require.Error(t, err, "listener is closed")
+		})
+	}
+}
+
+func TestSessionServerHandshake(t *testing.T) {
+	l, err := Listen("tcp://127.0.0.1:0")
+	require.NoError(t, err)
+	errs := make(chan error, 1)
+	ss := &SessionServer{
+		NewServer: func(hintCh, preimageCh io.ReadWriteCloser) *Server {
+			t.Error("unexpected session")
+			return nil
+		},
+		OnError: func(err error) {
+			errs <- err
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		_ = ss.Serve(ctx, l)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(make([]byte, 1+sessionIDSize))
+	require.NoError(t, err)
+	require.ErrorContains(t, <-errs, "unknown channel kind 0")
+}
+
+func TestSessionServerCloseListener(t *testing.T) {
+	l, err := Listen("tcp://127.0.0.1:0")
+	require.NoError(t, err)
+	addr := "tcp://" + l.Addr().String()
+	ss := &SessionServer{
+		NewServer: func(hintCh, preimageCh io.ReadWriteCloser) *Server {
+			return NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				return key[:], nil
+			})
+		},
+	}
+	result := make(chan error, 1)
+	go func() {
+		result <- ss.Serve(context.Background(), l)
+	}()
+
+	hintCh, preimageCh, err := DialChannels(context.Background(), addr)
+	require.NoError(t, err)
+	defer hintCh.Close()
+	defer preimageCh.Close()
+	key := LocalIndexKey(1)
+	_, err = NewOracleClient(preimageCh).GetContext(context.Background(), key)
+	require.NoError(t, err, "session is open")
+
+	require.NoError(t, l.Close())
+	select {
+	case err := <-result:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve blocks on the open session")
+	}
+	_, err = NewOracleClient(preimageCh).GetContext(context.Background(), key)
+	require.Error(t, err, "session is stopped")
+}
+
+// temporaryError is an accept error that the session server retries.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails to accept the first few connections with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if l.failures > 0 {
+		l.failures--
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestSessionServerAcceptRetry(t *testing.T) {
+	l, err := Listen("tcp://127.0.0.1:0")
+	require.NoError(t, err)
+	addr := "tcp://" + l.Addr().String()
+	var mu sync.Mutex
+	var errs []error
+	ss := &SessionServer{
+		NewServer: func(hintCh, preimageCh io.ReadWriteCloser) *Server {
+			return NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				return key[:], nil
+			})
+		},
+		OnError: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- ss.Serve(ctx, &flakyListener{Listener: l, failures: 3})
+	}()
+
+	hintCh, preimageCh, err := DialChannels(ctx, addr)
+	require.NoError(t, err)
+	defer hintCh.Close()
+	defer preimageCh.Close()
+	_, err = NewOracleClient(preimageCh).GetContext(ctx, LocalIndexKey(1))
+	require.NoError(t, err, "served after temporary accept errors")
+
+	cancel()
+	require.ErrorIs(t, <-result, context.Canceled)
+	require.Len(t, errs, 3)
+	require.ErrorContains(t, errs[0], "retrying in 5ms")
+	require.ErrorContains(t, errs[2], "retrying in 20ms")
+}
@@ -1,6 +1,7 @@
 package preimage
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -10,6 +11,8 @@ import (
 // and reading back a length-prefixed value.
 type OracleClient struct {
 	rw io.ReadWriter
+	// err is the error that broke the channel: after a failed request the stream may be out of sync.
+	err error
 }
 
 func NewOracleClient(rw io.ReadWriter) *OracleClient {
@@ -17,23 +20,45 @@ func NewOracleClient(rw io.ReadWriter) *OracleClient {
 }
 
 var _ Oracle = (*OracleClient)(nil)
+var _ ContextOracle = (*OracleClient)(nil)
 
+// Get retrieves the pre-image of the given key, and panics on any error.
 func (o *OracleClient) Get(key Key) []byte {
-	h := key.PreimageKey()
-	if _, err := o.rw.Write(h[:]); err != nil {
-		panic(fmt.Errorf("failed to write key %s (%T) to pre-image oracle: %w", key, key, err))
+	out, err := o.GetContext(context.Background(), key)
+	if err != nil {
+		panic(err)
 	}
+	return out
+}
 
-	var length uint64
-	if err := binary.Read(o.rw, binary.BigEndian, &length); err != nil {
-		panic(fmt.Errorf("failed to read pre-image length of key %s (%T) from pre-image oracle: %w", key, key, err))
-	}
-	payload := make([]byte, length)
-	if _, err := io.ReadFull(o.rw, payload); err != nil {
-		panic(fmt.Errorf("failed to read pre-image payload (length %d) of key %s (%T) from pre-image oracle: %w", length, key, key, err))
+// GetContext retrieves the pre-image of the given key, bound by the context.
+// After an error the channel is broken, and any later request fails.
+func (o *OracleClient) GetContext(ctx context.Context, key Key) ([]byte, error) {
+	if o.err != nil {
+		return nil, fmt.Errorf("pre-image oracle channel is broken: %w", o.err)
 	}
+	var payload []byte
+	err := withContext(ctx, o.rw, func() error {
+		h := key.PreimageKey()
+		if _, err := o.rw.Write(h[:]); err != nil {
+			return fmt.Errorf("failed to write key %s (%T) to pre-image oracle: %w", key, key, err)
+		}
 
-	return payload
+		var length uint64
+		if err := binary.Read(o.rw, binary.BigEndian, &length); err != nil {
+			return fmt.Errorf("failed to read pre-image length of key %s (%T) from pre-image oracle: %w", key, key, err)
+		}
+		payload = make([]byte, length)
+		if _, err := io.ReadFull(o.rw, payload); err != nil {
+			return fmt.Errorf("failed to read pre-image payload (length %d) of key %s (%T) from pre-image oracle: %w", length, key, key, err)
+		}
+		return nil
+	})
+	if err != nil {
+		o.err = err
+		return nil, err
+	}
+	return payload, nil
 }
 
 // OracleServer serves the pre-image requests of the OracleClient, implementing the same protocol as the onchain VM.
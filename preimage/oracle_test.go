@@ -2,11 +2,13 @@ package preimage
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"fmt"
 	"io"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -81,3 +83,69 @@ func TestOracle(t *testing.T) {
 		testPreimage(dat)
 	})
 }
+
+func TestOracleContext(t *testing.T) {
+	key := Keccak256Key(Keccak256([]byte("hello")))
+	channels := map[string]func(t *testing.T) (a, b io.ReadWriter){
+		"pipe": func(t *testing.T) (a, b io.ReadWriter) {
+			return bidirectionalPipe()
+		},
+		"file": func(t *testing.T) (a, b io.ReadWriter) {
+			ca, cb, err := CreateBidirectionalChannel()
+			require.NoError(t, err)
+			t.Cleanup(func() {
+				_ = ca.Close()
+				_ = cb.Close()
+			})
+			return ca, cb
+		},
+	}
+	for name, newChannel := range channels {
+		t.Run(name, func(t *testing.T) {
+			t.Run("timeout", func(t *testing.T) {
+				a, b := newChannel(t)
+				go func() { // read the key, but never respond
+					_, _ = io.ReadFull(b, make([]byte, 32))
+				}()
+				cl := NewOracleClient(a)
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				defer cancel()
+				_, err := cl.GetContext(ctx, key)
+				require.ErrorIs(t, err, context.DeadlineExceeded)
+				_, err = cl.GetContext(context.Background(), key)
+				require.ErrorContains(t, err, "channel is broken")
+			})
+			t.Run("cancel", func(t *testing.T) {
+				a, _ := newChannel(t)
+				cl := NewOracleClient(a)
+				ctx, cancel := context.WithCancel(context.Background())
+				time.AfterFunc(50*time.Millisecond, cancel)
+				_, err := cl.GetContext(ctx, key)
+				require.ErrorIs(t, err, context.Canceled)
+			})
+			t.Run("success", func(t *testing.T) {
+				a, b := newChannel(t)
+				srv := NewOracleServer(b)
+				go func() {
+					_ = srv.NextPreimageRequest(func(key [32]byte) ([]byte, error) {
+						return []byte("hello"), nil
+					})
+				}()
+				cl := NewOracleClient(a)
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				dat, err := cl.GetContext(ctx, key)
+				require.NoError(t, err)
+				require.Equal(t, []byte("hello"), dat)
+			})
+		})
+	}
+	t.Run("closed", func(t *testing.T) {
+		a, b, err := CreateBidirectionalChannel()
+		require.NoError(t, err)
+		defer a.Close()
+		require.NoError(t, b.Close())
+		_, err = NewOracleClient(a).GetContext(context.Background(), key)
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,129 @@
+package preimagetest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// Hinter records the hints of the program, and processes them with the handlers of their prefix,
+// like a preimage.Server, so a test can prepare pre-images only after the program hints them.
+// A hint fails the test if its handler fails, like the host of the VM fails on a failed hint.
+type Hinter struct {
+	t testing.TB
+
+	mu       sync.Mutex
+	handlers map[string]preimage.HintHandler
+	hints    []string
+}
+
+var _ preimage.Hinter = (*Hinter)(nil)
+var _ preimage.ContextHinter = (*Hinter)(nil)
+
+func NewHinter(t testing.TB) *Hinter {
+	return &Hinter{t: t, handlers: make(map[string]preimage.HintHandler)}
+}
+
+// HandleHint registers the handler of hints that start with the given prefix.
+// Hints are processed by the handler of the longest matching prefix. Hints without a handler are only recorded.
+func (h *Hinter) HandleHint(prefix string, handler preimage.HintHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[prefix] = handler
+}
+
+// Hint records and processes the hint, and fails the test if the handler of the hint fails.
+// Like any t.Fatal, it must be called from the goroutine of the test.
+func (h *Hinter) Hint(v preimage.Hint) {
+	h.t.Helper()
+	if err := h.HintContext(context.Background(), v); err != nil {
+		h.t.Fatal(err)
+	}
+}
+
+// HintContext records and processes the hint, and returns the error of its handler.
+func (h *Hinter) HintContext(ctx context.Context, v preimage.Hint) error {
+	hint := v.Hint()
+	handler := h.record(hint)
+	if handler == nil {
+		return nil
+	}
+	if err := handler(ctx, hint); err != nil {
+		return fmt.Errorf("failed to process hint %q: %w", hint, err)
+	}
+	return nil
+}
+
+// HintStatus records and processes the hint, and returns its status like a preimage.Server with ReportHintStatus:
+// HintFailed if the handler fails, and HintUnknown if there is no handler.
+func (h *Hinter) HintStatus(v preimage.Hint) preimage.HintStatus {
+	hint := v.Hint()
+	handler := h.record(hint)
+	if handler == nil {
+		return preimage.HintUnknown
+	}
+	if err := handler(context.Background(), hint); err != nil {
+		return preimage.HintFailed
+	}
+	return preimage.HintOK
+}
+
+// record records the hint, and returns the handler with the longest prefix of the hint, or nil if there is none.
+func (h *Hinter) record(hint string) preimage.HintHandler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hints = append(h.hints, hint)
+	var out preimage.HintHandler
+	best := -1
+	for prefix, handler := range h.handlers {
+		if len(prefix) > best && strings.HasPrefix(hint, prefix) {
+			out, best = handler, len(prefix)
+		}
+	}
+	return out
+}
+
+// Hints returns the hints that were sent so far, in order.
+func (h *Hinter) Hints() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.hints...)
+}
+
+// RequireHints checks that exactly the given hints were sent so far, in order.
+func (h *Hinter) RequireHints(hints ...preimage.Hint) {
+	h.t.Helper()
+	expected := make([]string, 0, len(hints))
+	for _, v := range hints {
+		expected = append(expected, v.Hint())
+	}
+	require.Equal(h.t, expected, h.Hints(), "hints")
+}
+
+// RequireHinted checks that the hint was sent at least once.
+func (h *Hinter) RequireHinted(v preimage.Hint) {
+	h.t.Helper()
+	require.Contains(h.t, h.Hints(), v.Hint(), "hint was not sent")
+}
+
+// RequireTypedHints checks that exactly the given values were hinted with the hint type so far, in order.
+// Hints of other types are ignored.
+func RequireTypedHints[T any](h *Hinter, ht *preimage.HintType[T], values ...T) {
+	h.t.Helper()
+	var got []T
+	for _, hint := range h.Hints() {
+		if !strings.HasPrefix(hint, ht.Prefix()) {
+			continue
+		}
+		v, err := ht.Decode(hint)
+		require.NoError(h.t, err, "invalid hint of type %q", ht.Tag())
+		got = append(got, v)
+	}
+	require.Equal(h.t, append([]T(nil), values...), got, "hints of type %q", ht.Tag())
+}
@@ -0,0 +1,67 @@
+package preimagetest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// Oracle is an in-memory pre-image oracle. It records the keys that the program requests,
+// and fails the test when the program requests a missing pre-image,
+// where the oracle client of the VM would fail the program.
+type Oracle struct {
+	t testing.TB
+
+	mu        sync.Mutex
+	preimages Preimages
+	requests  [][32]byte
+}
+
+var _ preimage.Oracle = (*Oracle)(nil)
+var _ preimage.ContextOracle = (*Oracle)(nil)
+
+// NewOracle returns an oracle with the pre-images of the sets.
+func NewOracle(t testing.TB, sets ...Preimages) *Oracle {
+	return &Oracle{t: t, preimages: make(Preimages).Merge(sets...)}
+}
+
+// Add adds the pre-images of the sets, e.g. from a hint handler, to prepare them after the program hints them.
+func (o *Oracle) Add(sets ...Preimages) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.preimages.Merge(sets...)
+}
+
+// Get returns the pre-image of the key, and fails the test if it is missing.
+// Like any t.Fatal, it must be called from the goroutine of the test.
+func (o *Oracle) Get(key preimage.Key) []byte {
+	o.t.Helper()
+	dat, err := o.GetContext(context.Background(), key)
+	if err != nil {
+		o.t.Fatal(err)
+	}
+	return dat
+}
+
+// GetContext returns the pre-image of the key, or an error if it is missing.
+func (o *Oracle) GetContext(ctx context.Context, key preimage.Key) ([]byte, error) {
+	k := key.PreimageKey()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, k)
+	dat, ok := o.preimages[k]
+	if !ok {
+		return nil, fmt.Errorf("missing pre-image %x", k)
+	}
+	return dat, nil
+}
+
+// Requests returns the pre-image keys that were requested so far, in order, including the missing ones.
+func (o *Oracle) Requests() [][32]byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([][32]byte(nil), o.requests...)
+}
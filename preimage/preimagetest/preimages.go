@@ -0,0 +1,80 @@
+// Package preimagetest runs program logic natively with go test, against an in-memory pre-image oracle
+// and a recording hinter, instead of the pre-image and hint channels of the VM.
+// Both implement the same preimage.Oracle and preimage.Hinter interfaces that the program uses in the VM.
+package preimagetest
+
+import (
+	"encoding/binary"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// Preimages is a set of pre-images by their type-prefixed pre-image key.
+type Preimages map[[32]byte][]byte
+
+// Keccak256Preimages returns the set of the keccak256 pre-images of the data.
+func Keccak256Preimages(data ...[]byte) Preimages {
+	p := make(Preimages)
+	for _, d := range data {
+		p.AddKeccak256(d)
+	}
+	return p
+}
+
+// Set adds the pre-image of the key, without checking it against the key.
+func (p Preimages) Set(key preimage.Key, data []byte) {
+	p[key.PreimageKey()] = data
+}
+
+// AddKeccak256 adds the data as keccak256 pre-image, and returns its key.
+func (p Preimages) AddKeccak256(data []byte) preimage.Keccak256Key {
+	k := preimage.Keccak256Key(preimage.Keccak256(data))
+	p.Set(k, data)
+	return k
+}
+
+// AddSha256 adds the data as sha256 pre-image, and returns its key.
+func (p Preimages) AddSha256(data []byte) preimage.Sha256Key {
+	k := preimage.Sha256Key(preimage.Sha256(data))
+	p.Set(k, data)
+	return k
+}
+
+// Merge adds all pre-images of the other sets.
+func (p Preimages) Merge(sets ...Preimages) Preimages {
+	for _, s := range sets {
+		for k, v := range s {
+			p[k] = v
+		}
+	}
+	return p
+}
+
+// Bootstrap is the set of local-key inputs of a program, e.g. the pre-state hash and the claim.
+// It is usable as the Local declaration of a preimage.Verifier.
+type Bootstrap map[preimage.LocalIndexKey][]byte
+
+// SetBytes sets the input at the local index.
+func (b Bootstrap) SetBytes(index preimage.LocalIndexKey, v []byte) Bootstrap {
+	b[index] = v
+	return b
+}
+
+// SetHash sets the input at the local index to the 32-byte hash.
+func (b Bootstrap) SetHash(index preimage.LocalIndexKey, h [32]byte) Bootstrap {
+	return b.SetBytes(index, h[:])
+}
+
+// SetUint64 sets the input at the local index to the big-endian encoded number.
+func (b Bootstrap) SetUint64(index preimage.LocalIndexKey, v uint64) Bootstrap {
+	return b.SetBytes(index, binary.BigEndian.AppendUint64(nil, v))
+}
+
+// Preimages returns the inputs as local-key pre-images.
+func (b Bootstrap) Preimages() Preimages {
+	p := make(Preimages, len(b))
+	for k, v := range b {
+		p.Set(k, v)
+	}
+	return p
+}
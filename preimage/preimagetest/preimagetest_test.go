@@ -0,0 +1,120 @@
+package preimagetest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+var (
+	fetchStateHint = preimage.HashHintType("fetch-state")
+	fetchDiffHint  = preimage.HashHintType("fetch-diff")
+)
+
+// claim is the program logic of the claim example: it checks the claim s*a+b of the bootstrap inputs.
+func claim(po preimage.Oracle, hinter preimage.Hinter) bool {
+	preHash := *(*[32]byte)(po.Get(preimage.LocalIndexKey(0)))
+	diffHash := *(*[32]byte)(po.Get(preimage.LocalIndexKey(1)))
+	claimData := po.Get(preimage.LocalIndexKey(2))
+
+	hinter.Hint(fetchStateHint.Hint(preHash))
+	pre := po.Get(preimage.Keccak256Key(preHash))
+
+	hinter.Hint(fetchDiffHint.Hint(diffHash))
+	diff := po.Get(preimage.Keccak256Key(diffHash))
+	a := po.Get(preimage.Keccak256Key(*(*[32]byte)(diff[:32])))
+	b := po.Get(preimage.Keccak256Key(*(*[32]byte)(diff[32:])))
+
+	s := binary.BigEndian.Uint64(pre)
+	return s*binary.BigEndian.Uint64(a)+binary.BigEndian.Uint64(b) == binary.BigEndian.Uint64(claimData)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func TestClaim(t *testing.T) {
+	state := make(Preimages)
+	preHash := state.AddKeccak256(u64(3))
+	diff := Keccak256Preimages(u64(4), u64(5))
+	a, b := preimage.Keccak256(u64(4)), preimage.Keccak256(u64(5))
+	diffHash := diff.AddKeccak256(append(a[:], b[:]...))
+
+	for _, tc := range []struct {
+		claim uint64
+		valid bool
+	}{{17, true}, {18, false}} {
+		inputs := make(Bootstrap).SetHash(0, preHash).SetHash(1, diffHash).SetUint64(2, tc.claim)
+		po := NewOracle(t, inputs.Preimages())
+		hinter := NewHinter(t)
+		// only prepare the pre-images once they are hinted
+		hinter.HandleHint(fetchStateHint.Prefix(), fetchStateHint.Handler(func(ctx context.Context, h [32]byte) error {
+			po.Add(state)
+			return nil
+		}))
+		hinter.HandleHint(fetchDiffHint.Prefix(), fetchDiffHint.Handler(func(ctx context.Context, h [32]byte) error {
+			po.Add(diff)
+			return nil
+		}))
+
+		require.Equal(t, tc.valid, claim(po, hinter))
+		hinter.RequireHints(fetchStateHint.Hint(preHash), fetchDiffHint.Hint(diffHash))
+		hinter.RequireHinted(fetchDiffHint.Hint(diffHash))
+		RequireTypedHints(hinter, fetchStateHint, [32]byte(preHash))
+		require.Equal(t, [][32]byte{
+			preimage.LocalIndexKey(0).PreimageKey(),
+			preimage.LocalIndexKey(1).PreimageKey(),
+			preimage.LocalIndexKey(2).PreimageKey(),
+			preHash.PreimageKey(),
+			diffHash.PreimageKey(),
+			preimage.Keccak256Key(a).PreimageKey(),
+			preimage.Keccak256Key(b).PreimageKey(),
+		}, po.Requests())
+
+		// the bootstrap inputs declare the local keys of the pre-image verifier
+		v := &preimage.Verifier{Local: inputs}
+		for k, dat := range make(Preimages).Merge(inputs.Preimages(), state, diff) {
+			require.NoError(t, v.Verify(k, dat))
+		}
+	}
+}
+
+func TestOracle(t *testing.T) {
+	p := make(Preimages)
+	k := p.AddSha256([]byte("hello"))
+	po := NewOracle(t, p)
+	require.Equal(t, []byte("hello"), po.Get(k))
+	require.NoError(t, preimage.VerifyPreimage(k.PreimageKey(), po.Get(k)))
+
+	_, err := po.GetContext(context.Background(), preimage.LocalIndexKey(0))
+	require.ErrorContains(t, err, "missing pre-image")
+	require.Len(t, po.Requests(), 3)
+}
+
+func TestHinterStatus(t *testing.T) {
+	hinter := NewHinter(t)
+	failErr := errors.New("fail")
+	hinter.HandleHint("fail", func(ctx context.Context, hint string) error {
+		return failErr
+	})
+	hinter.HandleHint("fail-not", func(ctx context.Context, hint string) error {
+		return nil
+	})
+	require.Equal(t, preimage.HintFailed, hinter.HintStatus(rawHint("fail 1")))
+	require.Equal(t, preimage.HintOK, hinter.HintStatus(rawHint("fail-not 2")))
+	require.Equal(t, preimage.HintUnknown, hinter.HintStatus(rawHint("other 3")))
+	require.ErrorIs(t, hinter.HintContext(context.Background(), rawHint("fail 4")), failErr)
+	hinter.RequireHints(rawHint("fail 1"), rawHint("fail-not 2"), rawHint("other 3"), rawHint("fail 4"))
+	RequireTypedHints(hinter, fetchStateHint)
+}
+
+type rawHint string
+
+func (rh rawHint) Hint() string {
+	return string(rh)
+}
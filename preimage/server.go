@@ -0,0 +1,237 @@
+package preimage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// HintHandler prepares the pre-images of a hint. The hint is passed in full, including the prefix it was routed by.
+type HintHandler func(ctx context.Context, hint string) error
+
+// PreimageGetter returns the pre-image of the given key, or an error if it is not available.
+type PreimageGetter func(ctx context.Context, key [32]byte) ([]byte, error)
+
+// Server serves the hints and pre-image requests of a program, over the hint and pre-image channels of the program,
+// e.g. ClientHinterChannel and ClientPreimageChannel in a host process started by the VM,
+// or the network connections of a session of a SessionServer.
+// Hints are acknowledged right away, and processed in order by the handler of the longest matching prefix.
+// A pre-image request is only answered after all hints that were received before it are processed,
+// so the handlers can prepare the pre-images that the program requests next.
+// With ReportHintStatus, hints are acknowledged with their status after processing instead.
+type Server struct {
+	hintCh      io.ReadWriteCloser
+	preimageCh  io.ReadWriteCloser
+	getPreimage PreimageGetter
+
+	handlers map[string]HintHandler
+	// if set, hints are processed before acknowledging them with their status, and failures are reported to it
+	onHintError func(hint string, err error)
+
+	mu sync.Mutex
+	// number of hints that are received, but not processed yet
+	pending int
+	// closed when no hints are pending, replaced when a new hint is received
+	idle chan struct{}
+}
+
+func NewServer(hintCh, preimageCh io.ReadWriteCloser, getPreimage PreimageGetter) *Server {
+	idle := make(chan struct{})
+	close(idle)
+	return &Server{
+		hintCh:      hintCh,
+		preimageCh:  preimageCh,
+		getPreimage: getPreimage,
+		handlers:    make(map[string]HintHandler),
+		idle:        idle,
+	}
+}
+
+// HandleHint registers the handler of hints that start with the given prefix.
+// Hints without a handler are ignored. Handlers must be registered before serving.
+func (s *Server) HandleHint(prefix string, h HintHandler) {
+	s.handlers[prefix] = h
+}
+
+// ReportHintStatus makes the server process each hint before acknowledging it, with the HintStatus of the hint,
+// so the program can check it. Failed hints do not stop the server, but are reported to onError instead.
+// Unknown hints, without a handler, get the HintUnknown status. It must be called before serving.
+func (s *Server) ReportHintStatus(onError func(hint string, err error)) {
+	s.onHintError = onError
+}
+
+// route returns the handler with the longest prefix of the hint, or nil if there is none.
+func (s *Server) route(hint string) HintHandler {
+	var out HintHandler
+	best := -1
+	for prefix, h := range s.handlers {
+		if len(prefix) > best && strings.HasPrefix(hint, prefix) {
+			out, best = h, len(prefix)
+		}
+	}
+	return out
+}
+
+func (s *Server) addPending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.pending++
+}
+
+func (s *Server) donePending() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending--
+	if s.pending == 0 {
+		close(s.idle)
+	}
+}
+
+// waitHints waits until all hints that are received so far are processed.
+func (s *Server) waitHints(ctx context.Context) error {
+	s.mu.Lock()
+	idle := s.idle
+	s.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) readHints(ctx context.Context, queue chan<- string) error {
+	hr := NewHintReader(s.hintCh)
+	for {
+		err := hr.NextHint(func(hint string) error {
+			s.addPending()
+			select {
+			case queue <- hint:
+				return nil
+			case <-ctx.Done():
+				s.donePending()
+				return ctx.Err()
+			}
+		})
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// statusHints processes the hints as they are received, and acknowledges them with their status.
+func (s *Server) statusHints(ctx context.Context) error {
+	hr := NewHintReader(s.hintCh)
+	for {
+		err := hr.NextHintStatus(func(hint string) HintStatus {
+			h := s.route(hint)
+			if h == nil {
+				s.onHintError(hint, ErrUnknownHint)
+				return HintUnknown
+			}
+			if err := h(ctx, hint); err != nil {
+				s.onHintError(hint, err)
+				return HintFailed
+			}
+			return HintOK
+		})
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) processHints(ctx context.Context, queue <-chan string) error {
+	for hint := range queue {
+		var err error
+		if h := s.route(hint); h != nil && ctx.Err() == nil {
+			err = h(ctx, hint)
+		}
+		s.donePending()
+		if err != nil {
+			return fmt.Errorf("failed to handle hint %q: %w", hint, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) servePreimages(ctx context.Context) error {
+	srv := NewOracleServer(s.preimageCh)
+	for {
+		err := srv.NextPreimageRequest(func(key [32]byte) ([]byte, error) {
+			if err := s.waitHints(ctx); err != nil {
+				return nil, err
+			}
+			return s.getPreimage(ctx, key)
+		})
+		if errors.Is(err, io.EOF) || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// Serve serves hints and pre-image requests until the program closes the channels, or the context is done.
+// It returns nil when the program closed the channels, and otherwise the error that stopped the server,
+// which is the context error if the context is done. The channels are closed when Serve returns.
+func (s *Server) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// closing the channels interrupts any pending reads, once the server is stopped
+	stop := make(chan struct{})
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		_ = s.hintCh.Close()
+		_ = s.preimageCh.Close()
+	}()
+
+	var wg sync.WaitGroup
+	run := func(fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := fn(); err != nil {
+				cancel(err)
+			}
+		}()
+	}
+	if s.onHintError != nil {
+		run(func() error {
+			return s.statusHints(ctx)
+		})
+	} else {
+		queue := make(chan string, 64)
+		run(func() error {
+			defer close(queue)
+			return s.readHints(ctx, queue)
+		})
+		run(func() error {
+			return s.processHints(ctx, queue)
+		})
+	}
+	run(func() error {
+		return s.servePreimages(ctx)
+	})
+	wg.Wait()
+	err := context.Cause(ctx)
+	close(stop)
+	<-closed
+	return err
+}
@@ -0,0 +1,148 @@
+package preimage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testServer starts a server over new channels, and returns the client ends of the channels,
+// and a channel with the result of Serve.
+func testServer(t *testing.T, ctx context.Context, srv func(hintCh, preimageCh FileChannel) *Server) (hintCh, preimageCh FileChannel, result <-chan error) {
+	hClient, hServer, err := CreateBidirectionalChannel()
+	require.NoError(t, err)
+	pClient, pServer, err := CreateBidirectionalChannel()
+	require.NoError(t, err)
+	s := srv(hServer, pServer)
+	out := make(chan error, 1)
+	go func() {
+		out <- s.Serve(ctx)
+	}()
+	return hClient, pClient, out
+}
+
+func waitResult(t *testing.T, result <-chan error) error {
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatal("server did not stop")
+		return nil
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Run("hints and pre-images", func(t *testing.T) {
+		var mu sync.Mutex
+		preimages := make(map[[32]byte][]byte)
+		var handled []string
+		hintCh, preimageCh, result := testServer(t, context.Background(), func(hintCh, preimageCh FileChannel) *Server {
+			s := NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				dat, ok := preimages[key]
+				if !ok {
+					return nil, fmt.Errorf("missing pre-image %x", key)
+				}
+				return dat, nil
+			})
+			prepare := func(ctx context.Context, hint string) error {
+				time.Sleep(10 * time.Millisecond) // the pre-image request must wait for this
+				mu.Lock()
+				defer mu.Unlock()
+				handled = append(handled, hint)
+				dat := []byte(hint[strings.Index(hint, " ")+1:])
+				preimages[Keccak256Key(Keccak256(dat)).PreimageKey()] = dat
+				return nil
+			}
+			s.HandleHint("fetch", prepare)
+			s.HandleHint("fetch-diff", func(ctx context.Context, hint string) error {
+				return prepare(ctx, hint+"-diff")
+			})
+			return s
+		})
+		hw := NewHintWriter(hintCh)
+		cl := NewOracleClient(preimageCh)
+		hw.Hint(rawHint("unknown hint"))
+		hw.Hint(rawHint("fetch-state hello"))
+		require.Equal(t, []byte("hello"), cl.Get(Keccak256Key(Keccak256([]byte("hello")))))
+		hw.Hint(rawHint("fetch-diff world"))
+		require.Equal(t, []byte("world-diff"), cl.Get(Keccak256Key(Keccak256([]byte("world-diff")))))
+		require.Equal(t, []string{"fetch-state hello", "fetch-diff world-diff"}, handled)
+
+		// the program exiting closes the channels, which stops the server
+		require.NoError(t, hintCh.Close())
+		require.NoError(t, preimageCh.Close())
+		require.NoError(t, waitResult(t, result))
+	})
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		hintCh, preimageCh, result := testServer(t, ctx, func(hintCh, preimageCh FileChannel) *Server {
+			return NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				return nil, errors.New("no pre-images")
+			})
+		})
+		defer hintCh.Close()
+		defer preimageCh.Close()
+		cancel()
+		require.ErrorIs(t, waitResult(t, result), context.Canceled)
+	})
+	t.Run("hint error", func(t *testing.T) {
+		hintErr := errors.New("fetch failed")
+		hintCh, preimageCh, result := testServer(t, context.Background(), func(hintCh, preimageCh FileChannel) *Server {
+			s := NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				return []byte("unreachable"), nil
+			})
+			s.HandleHint("fetch", func(ctx context.Context, hint string) error {
+				return hintErr
+			})
+			return s
+		})
+		defer hintCh.Close()
+		defer preimageCh.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		require.NoError(t, NewHintWriter(hintCh).HintContext(ctx, rawHint("fetch x")), "hints are acknowledged before processing")
+		_, err := NewOracleClient(preimageCh).GetContext(ctx, LocalIndexKey(0))
+		require.Error(t, err, "the server stops without answering")
+		require.ErrorIs(t, waitResult(t, result), hintErr)
+	})
+	t.Run("report hint status", func(t *testing.T) {
+		hintErr := errors.New("fetch failed")
+		var mu sync.Mutex
+		failed := make(map[string]error)
+		hintCh, preimageCh, result := testServer(t, context.Background(), func(hintCh, preimageCh FileChannel) *Server {
+			s := NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+				return []byte("hello"), nil
+			})
+			s.HandleHint("fetch", func(ctx context.Context, hint string) error {
+				if hint == "fetch bad" {
+					return hintErr
+				}
+				return nil
+			})
+			s.ReportHintStatus(func(hint string, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed[hint] = err
+			})
+			return s
+		})
+		hw := NewHintWriter(hintCh)
+		require.Equal(t, HintOK, hw.HintStatus(rawHint("fetch good")))
+		require.Equal(t, HintFailed, hw.HintStatus(rawHint("fetch bad")))
+		require.Equal(t, HintUnknown, hw.HintStatus(rawHint("other")))
+		// failed hints do not stop the server
+		require.Equal(t, []byte("hello"), NewOracleClient(preimageCh).Get(LocalIndexKey(0)))
+		require.NoError(t, hintCh.Close())
+		require.NoError(t, preimageCh.Close())
+		require.NoError(t, waitResult(t, result))
+		require.Equal(t, map[string]error{"fetch bad": hintErr, "other": ErrUnknownHint}, failed)
+	})
+}
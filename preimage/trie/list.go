@@ -0,0 +1,19 @@
+package trie
+
+// WalkList walks a list of pre-images that are linked by hash, starting at the pre-image of the head hash,
+// e.g. a chain of block headers by parent hash. The visit function gets each pre-image,
+// and returns the hash of the next pre-image, or false at the end of the list, or an error to stop the walk.
+func (r *Reader) WalkList(head [32]byte, visit func(preimage []byte) (next [32]byte, ok bool, err error)) error {
+	r.hint(ListHint.Hint(head))
+	hash := head
+	for {
+		next, ok, err := visit(r.get(hash))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		hash = next
+	}
+}
@@ -0,0 +1,143 @@
+package trie
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind is the kind of an RLP item.
+type Kind uint8
+
+const (
+	String Kind = iota
+	List
+)
+
+// readSize reads the big-endian size of a long RLP item, of the given number of bytes.
+func readSize(b []byte, n int) (uint64, error) {
+	if n > len(b) {
+		return 0, fmt.Errorf("RLP size of %d bytes exceeds the %d bytes of data", n, len(b))
+	}
+	if n > 8 {
+		return 0, fmt.Errorf("RLP size of %d bytes does not fit in 64 bits", n)
+	}
+	if b[0] == 0 {
+		return 0, errors.New("non-canonical RLP size with leading zero")
+	}
+	var size uint64
+	for _, c := range b[:n] {
+		size = size<<8 | uint64(c)
+	}
+	if size < 56 {
+		return 0, fmt.Errorf("non-canonical RLP size %d, should use the short form", size)
+	}
+	return size, nil
+}
+
+// Split splits the first RLP item off the data, and returns its kind, its content, and the data after it.
+func Split(b []byte) (kind Kind, content []byte, rest []byte, err error) {
+	if len(b) == 0 {
+		return 0, nil, nil, errors.New("no RLP item in empty data")
+	}
+	prefix := b[0]
+	var offset, size uint64
+	switch {
+	case prefix < 0x80:
+		return String, b[:1], b[1:], nil
+	case prefix < 0xb8:
+		kind, offset, size = String, 1, uint64(prefix-0x80)
+		if size == 1 && len(b) > 1 && b[1] < 0x80 {
+			return 0, nil, nil, errors.New("non-canonical RLP encoding of single byte")
+		}
+	case prefix < 0xc0:
+		n := int(prefix - 0xb7)
+		size, err = readSize(b[1:], n)
+		kind, offset = String, uint64(1+n)
+	case prefix < 0xf8:
+		kind, offset, size = List, 1, uint64(prefix-0xc0)
+	default:
+		n := int(prefix - 0xf7)
+		size, err = readSize(b[1:], n)
+		kind, offset = List, uint64(1+n)
+	}
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if size > uint64(len(b))-offset {
+		return 0, nil, nil, fmt.Errorf("RLP item of %d bytes exceeds the %d bytes of data", size, uint64(len(b))-offset)
+	}
+	return kind, b[offset : offset+size], b[offset+size:], nil
+}
+
+// ListIterator iterates over the items of an RLP list.
+type ListIterator struct {
+	data []byte
+
+	kind  Kind
+	value []byte
+	raw   []byte
+	err   error
+}
+
+// NewListIterator returns an iterator over the items of the RLP-encoded list.
+func NewListIterator(list []byte) (*ListIterator, error) {
+	kind, content, rest, err := Split(list)
+	if err != nil {
+		return nil, err
+	}
+	if kind != List {
+		return nil, errors.New("not an RLP list")
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data after RLP list", len(rest))
+	}
+	return &ListIterator{data: content}, nil
+}
+
+// Next moves to the next item. It returns false at the end of the list, or if the next item is invalid.
+func (it *ListIterator) Next() bool {
+	if it.err != nil || len(it.data) == 0 {
+		return false
+	}
+	kind, value, rest, err := Split(it.data)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.kind, it.value, it.raw = kind, value, it.data[:len(it.data)-len(rest)]
+	it.data = rest
+	return true
+}
+
+// Kind is the kind of the current item.
+func (it *ListIterator) Kind() Kind {
+	return it.kind
+}
+
+// Value is the content of the current item: the bytes of a string, or the encoded items of a list.
+func (it *ListIterator) Value() []byte {
+	return it.value
+}
+
+// Raw is the full RLP encoding of the current item.
+func (it *ListIterator) Raw() []byte {
+	return it.raw
+}
+
+// Err is the error that stopped the iteration, if any.
+func (it *ListIterator) Err() error {
+	return it.err
+}
+
+// listItems returns the raw RLP encoding of each item of the RLP-encoded list.
+func listItems(list []byte) ([][]byte, error) {
+	it, err := NewListIterator(list)
+	if err != nil {
+		return nil, err
+	}
+	var items [][]byte
+	for it.Next() {
+		items = append(items, it.Raw())
+	}
+	return items, it.Err()
+}
@@ -0,0 +1,78 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	long := make([]byte, 60)
+	for _, tc := range []struct {
+		name    string
+		enc     []byte
+		kind    Kind
+		content []byte
+		rest    []byte
+	}{
+		{"single byte", []byte{0x7f, 0xaa}, String, []byte{0x7f}, []byte{0xaa}},
+		{"empty string", []byte{0x80}, String, []byte{}, []byte{}},
+		{"short string", []byte{0x83, 'd', 'o', 'g'}, String, []byte("dog"), []byte{}},
+		{"long string", append([]byte{0xb8, 60}, long...), String, long, []byte{}},
+		{"empty list", []byte{0xc0, 0x01}, List, []byte{}, []byte{0x01}},
+		{"short list", []byte{0xc8, 0x83, 'c', 'a', 't', 0x83, 'd', 'o', 'g'}, List, []byte{0x83, 'c', 'a', 't', 0x83, 'd', 'o', 'g'}, []byte{}},
+		{"long list", append([]byte{0xf8, 60}, long...), List, long, []byte{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			kind, content, rest, err := Split(tc.enc)
+			require.NoError(t, err)
+			require.Equal(t, tc.kind, kind)
+			require.Equal(t, tc.content, content)
+			require.Equal(t, tc.rest, rest)
+		})
+	}
+
+	for name, enc := range map[string][]byte{
+		"empty":                {},
+		"string too long":      {0x83, 'd', 'o'},
+		"list too long":        {0xc2, 0x80},
+		"non-canonical byte":   {0x81, 0x01},
+		"non-canonical size":   {0xb8, 0x01, 0x00},
+		"leading zero size":    {0xb9, 0x00, 0x40},
+		"size exceeds data":    {0xbb, 0x01, 0x00},
+		"size exceeds 64 bits": {0xbf, 0x01, 0, 0, 0, 0, 0, 0, 0},
+	} {
+		_, _, _, err := Split(enc)
+		require.Error(t, err, name)
+	}
+}
+
+func TestListIterator(t *testing.T) {
+	// ["cat", ["dog"], ""]
+	enc := []byte{0xca, 0x83, 'c', 'a', 't', 0xc4, 0x83, 'd', 'o', 'g', 0x80}
+	it, err := NewListIterator(enc)
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	require.Equal(t, String, it.Kind())
+	require.Equal(t, []byte("cat"), it.Value())
+	require.True(t, it.Next())
+	require.Equal(t, List, it.Kind())
+	require.Equal(t, []byte{0x83, 'd', 'o', 'g'}, it.Value())
+	require.Equal(t, []byte{0xc4, 0x83, 'd', 'o', 'g'}, it.Raw())
+	require.True(t, it.Next())
+	require.Equal(t, String, it.Kind())
+	require.Empty(t, it.Value())
+	require.False(t, it.Next())
+	require.NoError(t, it.Err())
+
+	_, err = NewListIterator([]byte{0x83, 'c', 'a', 't'})
+	require.ErrorContains(t, err, "not an RLP list")
+	_, err = NewListIterator([]byte{0xc0, 0x80})
+	require.ErrorContains(t, err, "trailing data")
+
+	it, err = NewListIterator([]byte{0xc3, 0x80, 0x82, 'a'})
+	require.NoError(t, err)
+	require.True(t, it.Next())
+	require.False(t, it.Next())
+	require.ErrorContains(t, it.Err(), "exceeds")
+}
@@ -0,0 +1,184 @@
+// Package trie reads hash-linked data structures, like Merkle-Patricia tries and RLP lists,
+// from the pre-image oracle in a program. Each read hints the host before fetching pre-images,
+// so the host can prepare all pre-images of the read at once.
+package trie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+// EmptyRoot is the root hash of an empty Merkle-Patricia trie, the keccak256 hash of the RLP encoding of an empty string.
+var EmptyRoot = [32]byte{
+	0x56, 0xe8, 0x1f, 0x17, 0x1b, 0xcc, 0x55, 0xa6, 0xff, 0x83, 0x45, 0xe6, 0x92, 0xc0, 0xf8, 0x6e,
+	0x5b, 0x48, 0xe0, 0x1b, 0x99, 0x6c, 0xad, 0xc0, 0x01, 0x62, 0x2f, 0xb5, 0xe3, 0x63, 0xb4, 0x21,
+}
+
+// TrieKey is a key in the trie with the root.
+type TrieKey struct {
+	Root [32]byte
+	Key  []byte
+}
+
+var (
+	// TrieHint asks the host to prepare the trie nodes on the path of the key, in the trie with the root.
+	TrieHint = preimage.NewHintType("trie-get", func(v TrieKey) []byte {
+		return append(append([]byte{}, v.Root[:]...), v.Key...)
+	}, func(payload []byte) (out TrieKey, err error) {
+		if len(payload) < 32 {
+			return out, fmt.Errorf("expected trie root and key, but got %d bytes", len(payload))
+		}
+		copy(out.Root[:], payload[:32])
+		out.Key = payload[32:]
+		return out, nil
+	})
+	// ListHint asks the host to prepare the pre-images of the hash-linked list, that starts at the hash.
+	ListHint = preimage.HashHintType("list-walk")
+)
+
+// Reader reads hash-linked data structures from the oracle, and hints the host with the hinter before fetching.
+type Reader struct {
+	oracle preimage.Oracle
+	hinter preimage.Hinter
+}
+
+// NewReader creates a reader of the oracle. The hinter may be nil, to not hint the host.
+func NewReader(oracle preimage.Oracle, hinter preimage.Hinter) *Reader {
+	return &Reader{oracle: oracle, hinter: hinter}
+}
+
+func (r *Reader) hint(v preimage.Hint) {
+	if r.hinter != nil {
+		r.hinter.Hint(v)
+	}
+}
+
+func (r *Reader) get(hash [32]byte) []byte {
+	return r.oracle.Get(preimage.Keccak256Key(hash))
+}
+
+// Get returns the value of the key in the Merkle-Patricia trie with the given root, or nil if the key is not in the trie.
+// The key is used as is: for secure tries, like the state trie, the key is the keccak256 hash of the account address
+// or storage slot.
+func (r *Reader) Get(root [32]byte, key []byte) ([]byte, error) {
+	if root == EmptyRoot {
+		return nil, nil
+	}
+	r.hint(TrieHint.Hint(TrieKey{Root: root, Key: key}))
+	path := keyNibbles(key)
+	node := r.get(root)
+	for {
+		items, err := listItems(node)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trie node: %w", err)
+		}
+		switch len(items) {
+		case 17: // branch node
+			if len(path) == 0 {
+				return stringValue(items[16])
+			}
+			node, err = r.resolve(items[path[0]])
+			if err != nil || node == nil {
+				return nil, err
+			}
+			path = path[1:]
+		case 2: // extension or leaf node
+			kind, encPath, _, err := Split(items[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid trie node path: %w", err)
+			}
+			if kind != String {
+				return nil, errors.New("invalid trie node path: not a string")
+			}
+			nibbles, leaf, err := decodeHexPrefix(encPath)
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.HasPrefix(path, nibbles) {
+				return nil, nil
+			}
+			path = path[len(nibbles):]
+			if leaf {
+				if len(path) != 0 {
+					return nil, nil
+				}
+				return stringValue(items[1])
+			}
+			node, err = r.resolve(items[1])
+			if err != nil {
+				return nil, err
+			}
+			if node == nil {
+				return nil, errors.New("invalid trie extension node without child")
+			}
+		default:
+			return nil, fmt.Errorf("invalid trie node with %d items", len(items))
+		}
+	}
+}
+
+// resolve returns the child node of the reference: the node itself if it is embedded,
+// or the pre-image of the hash otherwise. It returns nil if there is no child.
+func (r *Reader) resolve(ref []byte) ([]byte, error) {
+	kind, content, _, err := Split(ref)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trie node reference: %w", err)
+	}
+	if kind == List {
+		return ref, nil
+	}
+	switch len(content) {
+	case 0:
+		return nil, nil
+	case 32:
+		return r.get(*(*[32]byte)(content)), nil
+	default:
+		return nil, fmt.Errorf("invalid trie node reference of %d bytes", len(content))
+	}
+}
+
+// stringValue returns the content of the RLP string, or nil if it is empty.
+func stringValue(item []byte) ([]byte, error) {
+	kind, content, _, err := Split(item)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trie value: %w", err)
+	}
+	if kind != String {
+		return nil, errors.New("invalid trie value: not a string")
+	}
+	if len(content) == 0 {
+		return nil, nil
+	}
+	return content, nil
+}
+
+// keyNibbles splits the key into nibbles, high nibble first.
+func keyNibbles(key []byte) []byte {
+	out := make([]byte, 0, len(key)*2)
+	for _, b := range key {
+		out = append(out, b>>4, b&0x0f)
+	}
+	return out
+}
+
+// decodeHexPrefix decodes the hex-prefix encoded path of an extension or leaf node.
+func decodeHexPrefix(enc []byte) (nibbles []byte, leaf bool, err error) {
+	if len(enc) == 0 {
+		return nil, false, errors.New("empty hex-prefix encoded path")
+	}
+	flag := enc[0] >> 4
+	if flag > 3 {
+		return nil, false, fmt.Errorf("invalid hex-prefix flag %d", flag)
+	}
+	leaf = flag&2 != 0
+	nibbles = keyNibbles(enc[1:])
+	if flag&1 != 0 { // odd length: the first nibble is in the flag byte
+		nibbles = append([]byte{enc[0] & 0x0f}, nibbles...)
+	} else if enc[0]&0x0f != 0 {
+		return nil, false, errors.New("invalid hex-prefix padding")
+	}
+	return nibbles, leaf, nil
+}
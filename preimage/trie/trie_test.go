@@ -0,0 +1,141 @@
+package trie
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum-optimism/cannon/preimage"
+)
+
+type testOracle map[[32]byte][]byte
+
+func (o testOracle) Get(key preimage.Key) []byte {
+	dat, ok := o[key.PreimageKey()]
+	if !ok {
+		panic(errors.New("missing pre-image"))
+	}
+	return dat
+}
+
+func (o testOracle) add(dat []byte) [32]byte {
+	h := preimage.Keccak256(dat)
+	o[preimage.Keccak256Key(h).PreimageKey()] = dat
+	return h
+}
+
+type testHinter []string
+
+func (h *testHinter) Hint(v preimage.Hint) {
+	*h = append(*h, v.Hint())
+}
+
+type hexBytes []byte
+
+func (b *hexBytes) UnmarshalText(text []byte) error {
+	dat, err := hex.DecodeString(strings.TrimPrefix(string(text), "0x"))
+	*b = dat
+	return err
+}
+
+// testTrie is a trie with the nodes of the proofs of all entries and missing keys, generated with go-ethereum.
+type testTrie struct {
+	Name    string              `json:"name"`
+	Root    hexBytes            `json:"root"`
+	Nodes   []hexBytes          `json:"nodes"`
+	Entries map[string]hexBytes `json:"entries"`
+	Missing []hexBytes          `json:"missing"`
+}
+
+func TestGet(t *testing.T) {
+	dat, err := os.ReadFile("testdata/tries.json")
+	require.NoError(t, err)
+	var tries []testTrie
+	require.NoError(t, json.Unmarshal(dat, &tries))
+	for _, tr := range tries {
+		t.Run(tr.Name, func(t *testing.T) {
+			oracle := make(testOracle)
+			for _, n := range tr.Nodes {
+				oracle.add(n)
+			}
+			var hints testHinter
+			r := NewReader(oracle, &hints)
+			root := *(*[32]byte)(tr.Root)
+			for k, v := range tr.Entries {
+				var key hexBytes
+				require.NoError(t, key.UnmarshalText([]byte(k)))
+				got, err := r.Get(root, key)
+				require.NoError(t, err)
+				require.Equal(t, []byte(v), got)
+
+				// the reader hints the key before fetching the nodes
+				hint, err := TrieHint.Decode(hints[len(hints)-1])
+				require.NoError(t, err)
+				require.Equal(t, TrieKey{Root: root, Key: key}, hint)
+			}
+			for _, key := range tr.Missing {
+				got, err := r.Get(root, key)
+				require.NoError(t, err)
+				require.Nil(t, got)
+			}
+			require.Len(t, hints, len(tr.Entries)+len(tr.Missing))
+		})
+	}
+}
+
+func TestGetEmpty(t *testing.T) {
+	var hints testHinter
+	got, err := NewReader(make(testOracle), &hints).Get(EmptyRoot, []byte("foo"))
+	require.NoError(t, err)
+	require.Nil(t, got)
+	require.Empty(t, hints, "no need to hint the empty trie")
+	require.Equal(t, EmptyRoot, preimage.Keccak256([]byte{0x80}))
+}
+
+func TestGetInvalid(t *testing.T) {
+	oracle := make(testOracle)
+	r := NewReader(oracle, nil)
+	// a node with 3 items
+	_, err := r.Get(oracle.add([]byte{0xc3, 0x80, 0x80, 0x80}), []byte{1})
+	require.ErrorContains(t, err, "invalid trie node with 3 items")
+	// a leaf with an invalid hex-prefix flag
+	_, err = r.Get(oracle.add([]byte{0xc2, 0x40, 0x01}), []byte{1})
+	require.ErrorContains(t, err, "invalid hex-prefix flag")
+	// an extension with a child reference that is not a hash
+	_, err = r.Get(oracle.add([]byte{0xc4, 0x11, 0x82, 0x01, 0x02}), []byte{0x12})
+	require.ErrorContains(t, err, "invalid trie node reference of 2 bytes")
+}
+
+func TestWalkList(t *testing.T) {
+	// each pre-image is the number of the item, followed by the hash of the next pre-image
+	oracle := make(testOracle)
+	var next [32]byte
+	for i := uint64(0); i < 5; i++ {
+		next = oracle.add(append(binary.BigEndian.AppendUint64(nil, i), next[:]...))
+	}
+	var hints testHinter
+	var got []uint64
+	err := NewReader(oracle, &hints).WalkList(next, func(dat []byte) (next [32]byte, ok bool, err error) {
+		got = append(got, binary.BigEndian.Uint64(dat[:8]))
+		copy(next[:], dat[8:])
+		return next, next != [32]byte{}, nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{4, 3, 2, 1, 0}, got)
+	require.Len(t, hints, 1)
+	head, err := ListHint.Decode(hints[0])
+	require.NoError(t, err)
+	require.Equal(t, next, head)
+
+	stopErr := errors.New("stop")
+	err = NewReader(oracle, nil).WalkList(next, func(dat []byte) ([32]byte, bool, error) {
+		return [32]byte{}, false, stopErr
+	})
+	require.ErrorIs(t, err, stopErr)
+}
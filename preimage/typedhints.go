@@ -0,0 +1,156 @@
+package preimage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// typedHintMarker is the first byte of a typed hint. Free-form text hints do not start with it.
+const typedHintMarker = 0x00
+
+// typedHint is an encoded typed hint: the marker, the length of the tag, the tag, and the binary payload.
+type typedHint string
+
+func (h typedHint) Hint() string {
+	return string(h)
+}
+
+// ParseTypedHint splits a typed hint into the tag of its type, and its payload.
+func ParseTypedHint(hint string) (tag string, payload []byte, err error) {
+	if len(hint) < 2 || hint[0] != typedHintMarker {
+		return "", nil, errors.New("not a typed hint")
+	}
+	tagLen := int(hint[1])
+	if len(hint) < 2+tagLen {
+		return "", nil, fmt.Errorf("typed hint of %d bytes is too short for tag of %d bytes", len(hint), tagLen)
+	}
+	return hint[2 : 2+tagLen], []byte(hint[2+tagLen:]), nil
+}
+
+// HintType defines a typed hint: the tag that identifies the type, and the binary encoding of its payload.
+// The program constructs its hints with it, and the host decodes them with it,
+// so both sides share a single definition of the hint format.
+type HintType[T any] struct {
+	tag    string
+	encode func(v T) []byte
+	decode func(payload []byte) (T, error)
+}
+
+// NewHintType defines a hint type. The tag must be 1 to 255 bytes, and unique among the hint types of the program.
+func NewHintType[T any](tag string, encode func(v T) []byte, decode func(payload []byte) (T, error)) *HintType[T] {
+	if len(tag) == 0 || len(tag) > 255 {
+		panic(fmt.Errorf("invalid hint tag %q: must be 1 to 255 bytes", tag))
+	}
+	return &HintType[T]{tag: tag, encode: encode, decode: decode}
+}
+
+func (ht *HintType[T]) Tag() string {
+	return ht.tag
+}
+
+// Prefix is the prefix of all hints of this type, to route them with Server.HandleHint.
+func (ht *HintType[T]) Prefix() string {
+	return string([]byte{typedHintMarker, byte(len(ht.tag))}) + ht.tag
+}
+
+// Hint returns the hint of the value, to write with a Hinter.
+func (ht *HintType[T]) Hint(v T) Hint {
+	return typedHint(ht.Prefix() + string(ht.encode(v)))
+}
+
+// Decode decodes a hint of this type.
+func (ht *HintType[T]) Decode(hint string) (T, error) {
+	tag, payload, err := ParseTypedHint(hint)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	if tag != ht.tag {
+		var zero T
+		return zero, fmt.Errorf("expected hint of type %q, but got %q", ht.tag, tag)
+	}
+	return ht.decode(payload)
+}
+
+// DecodePayload decodes the payload of a hint of this type.
+func (ht *HintType[T]) DecodePayload(payload []byte) (any, error) {
+	return ht.decode(payload)
+}
+
+// Handler returns a hint handler that decodes the hints of this type, to register with Server.HandleHint at Prefix.
+func (ht *HintType[T]) Handler(h func(ctx context.Context, v T) error) HintHandler {
+	return func(ctx context.Context, hint string) error {
+		v, err := ht.Decode(hint)
+		if err != nil {
+			return err
+		}
+		return h(ctx, v)
+	}
+}
+
+// HintDecoder decodes the payload of the typed hints of a single type. HintType implements it.
+type HintDecoder interface {
+	Tag() string
+	DecodePayload(payload []byte) (any, error)
+}
+
+// HintRegistry is a set of hint types with unique tags, to decode a typed hint of any of the types,
+// e.g. to log the hints of a program.
+type HintRegistry struct {
+	types map[string]HintDecoder
+}
+
+func NewHintRegistry() *HintRegistry {
+	return &HintRegistry{types: make(map[string]HintDecoder)}
+}
+
+// Register adds the hint types to the registry. It fails if any of the tags is registered already.
+func (r *HintRegistry) Register(types ...HintDecoder) error {
+	for _, ht := range types {
+		if _, ok := r.types[ht.Tag()]; ok {
+			return fmt.Errorf("hint type %q is registered already", ht.Tag())
+		}
+		r.types[ht.Tag()] = ht
+	}
+	return nil
+}
+
+// Decode decodes the typed hint, and returns the tag of its type and the decoded value.
+func (r *HintRegistry) Decode(hint string) (tag string, v any, err error) {
+	tag, payload, err := ParseTypedHint(hint)
+	if err != nil {
+		return "", nil, err
+	}
+	ht, ok := r.types[tag]
+	if !ok {
+		return tag, nil, fmt.Errorf("unknown hint type %q", tag)
+	}
+	v, err = ht.DecodePayload(payload)
+	if err != nil {
+		return tag, nil, fmt.Errorf("invalid hint of type %q: %w", tag, err)
+	}
+	return tag, v, nil
+}
+
+// BytesHintType returns a hint type with raw bytes as payload.
+func BytesHintType(tag string) *HintType[[]byte] {
+	return NewHintType(tag, func(v []byte) []byte {
+		return v
+	}, func(payload []byte) ([]byte, error) {
+		return payload, nil
+	})
+}
+
+// HashHintType returns a hint type with a 32-byte hash as payload, e.g. the hash of a pre-image to prepare.
+func HashHintType(tag string) *HintType[[32]byte] {
+	return NewHintType(tag, func(v [32]byte) []byte {
+		return v[:]
+	}, func(payload []byte) (out [32]byte, err error) {
+		if len(payload) != 32 {
+			return out, fmt.Errorf("expected 32-byte hash, but got %d bytes", len(payload))
+		}
+		copy(out[:], payload)
+		return out, nil
+	})
+}
@@ -0,0 +1,100 @@
+package preimage
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testStateHint = HashHintType("fetch-state")
+	testDiffHint  = HashHintType("fetch-state-diff")
+	testRangeHint = NewHintType("fetch-range", func(v [2]uint64) []byte {
+		return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, v[0]), v[1])
+	}, func(payload []byte) (out [2]uint64, err error) {
+		if len(payload) != 16 {
+			return out, fmt.Errorf("expected 16 bytes, got %d", len(payload))
+		}
+		return [2]uint64{binary.BigEndian.Uint64(payload[:8]), binary.BigEndian.Uint64(payload[8:])}, nil
+	})
+)
+
+func TestHintType(t *testing.T) {
+	h := [32]byte{1, 2, 3, 31: 0xff}
+	hint := testStateHint.Hint(h).Hint()
+	got, err := testStateHint.Decode(hint)
+	require.NoError(t, err)
+	require.Equal(t, h, got)
+
+	tag, payload, err := ParseTypedHint(hint)
+	require.NoError(t, err)
+	require.Equal(t, "fetch-state", tag)
+	require.Equal(t, h[:], payload)
+
+	_, err = testDiffHint.Decode(hint)
+	require.ErrorContains(t, err, `expected hint of type "fetch-state-diff"`)
+	_, err = testStateHint.Decode("fetch-state 0x1234")
+	require.ErrorContains(t, err, "not a typed hint")
+	_, err = testStateHint.Decode(testStateHint.Prefix() + "short")
+	require.ErrorContains(t, err, "expected 32-byte hash")
+	_, _, err = ParseTypedHint(testStateHint.Prefix()[:5])
+	require.ErrorContains(t, err, "too short")
+
+	r, err := testRangeHint.Decode(testRangeHint.Hint([2]uint64{10, 20}).Hint())
+	require.NoError(t, err)
+	require.Equal(t, [2]uint64{10, 20}, r)
+}
+
+func TestHintRegistry(t *testing.T) {
+	r := NewHintRegistry()
+	require.NoError(t, r.Register(testStateHint, testDiffHint, testRangeHint))
+	require.ErrorContains(t, r.Register(HashHintType("fetch-state")), "registered already")
+
+	tag, v, err := r.Decode(testRangeHint.Hint([2]uint64{1, 2}).Hint())
+	require.NoError(t, err)
+	require.Equal(t, "fetch-range", tag)
+	require.Equal(t, [2]uint64{1, 2}, v)
+
+	_, _, err = r.Decode(BytesHintType("other").Hint([]byte("x")).Hint())
+	require.ErrorContains(t, err, `unknown hint type "other"`)
+	_, _, err = r.Decode(testRangeHint.Prefix())
+	require.ErrorContains(t, err, `invalid hint of type "fetch-range"`)
+}
+
+func TestServerTypedHints(t *testing.T) {
+	var mu sync.Mutex
+	var states, diffs [][32]byte
+	hintCh, preimageCh, result := testServer(t, context.Background(), func(hintCh, preimageCh FileChannel) *Server {
+		s := NewServer(hintCh, preimageCh, func(ctx context.Context, key [32]byte) ([]byte, error) {
+			return nil, errors.New("no pre-images")
+		})
+		// the tag of one type is a prefix of the tag of the other, but their hint prefixes are distinct
+		s.HandleHint(testStateHint.Prefix(), testStateHint.Handler(func(ctx context.Context, v [32]byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			states = append(states, v)
+			return nil
+		}))
+		s.HandleHint(testDiffHint.Prefix(), testDiffHint.Handler(func(ctx context.Context, v [32]byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			diffs = append(diffs, v)
+			return nil
+		}))
+		return s
+	})
+	hw := NewHintWriter(hintCh)
+	hw.Hint(testStateHint.Hint([32]byte{1}))
+	hw.Hint(testDiffHint.Hint([32]byte{2}))
+	hw.Hint(testStateHint.Hint([32]byte{3}))
+	require.NoError(t, hintCh.Close())
+	require.NoError(t, preimageCh.Close())
+	require.NoError(t, waitResult(t, result))
+	require.Equal(t, [][32]byte{{1}, {3}}, states)
+	require.Equal(t, [][32]byte{{2}}, diffs)
+}
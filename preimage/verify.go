@@ -0,0 +1,88 @@
+package preimage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// MaxPreimageSize is the largest pre-image the VM can read: the VM reads the pre-image with a 32-bit offset,
+// including the 8-byte length prefix.
+const MaxPreimageSize = math.MaxUint32 - 8
+
+// Verifier checks pre-images against their keys, to catch a faulty pre-image source
+// before the pre-image is used, instead of producing a trace that cannot be proven onchain.
+type Verifier struct {
+	// MaxSize is the maximum size of a pre-image, MaxPreimageSize if zero.
+	MaxSize uint64
+	// Local declares the pre-images of the local keys, e.g. the bootstrap inputs of the program.
+	// Local keys are not checked if nil. Otherwise every local key must be declared.
+	Local map[LocalIndexKey][]byte
+}
+
+// Verify checks the size of the pre-image, and that the pre-image matches the key:
+// the hash of the pre-image for hash-typed keys, and the declared pre-image for local keys.
+func (v *Verifier) Verify(key [32]byte, preimage []byte) error {
+	maxSize := v.MaxSize
+	if maxSize == 0 || maxSize > MaxPreimageSize {
+		maxSize = MaxPreimageSize
+	}
+	if uint64(len(preimage)) > maxSize {
+		return fmt.Errorf("pre-image %x of %d bytes exceeds the limit of %d bytes", key, len(preimage), maxSize)
+	}
+	if KeyType(key[0]) != LocalKeyType {
+		return VerifyPreimage(key, preimage)
+	}
+	if v.Local == nil {
+		return nil
+	}
+	k := LocalIndexKey(binary.BigEndian.Uint64(key[24:]))
+	expected, ok := v.Local[k]
+	if !ok || k.PreimageKey() != key {
+		return fmt.Errorf("local key %x is not declared", key)
+	}
+	if !bytes.Equal(expected, preimage) {
+		return fmt.Errorf("pre-image does not match declared local key %x", key)
+	}
+	return nil
+}
+
+// VerifyingOracle checks every pre-image of the oracle with the verifier.
+type VerifyingOracle struct {
+	o ContextOracle
+	v *Verifier
+}
+
+func NewVerifyingOracle(o ContextOracle, v *Verifier) *VerifyingOracle {
+	return &VerifyingOracle{o: o, v: v}
+}
+
+func (o *VerifyingOracle) GetContext(ctx context.Context, key Key) ([]byte, error) {
+	dat, err := o.o.GetContext(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.v.Verify(key.PreimageKey(), dat); err != nil {
+		return nil, err
+	}
+	return dat, nil
+}
+
+var _ ContextOracle = (*VerifyingOracle)(nil)
+
+// VerifyingGetter checks every pre-image of the getter with the verifier,
+// so a Server refuses to serve an invalid pre-image, instead of handing it to the program.
+func VerifyingGetter(get PreimageGetter, v *Verifier) PreimageGetter {
+	return func(ctx context.Context, key [32]byte) ([]byte, error) {
+		dat, err := get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.Verify(key, dat); err != nil {
+			return nil, err
+		}
+		return dat, nil
+	}
+}
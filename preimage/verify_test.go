@@ -0,0 +1,81 @@
+package preimage
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	data := []byte("hello world")
+	keccakKey := Keccak256Key(Keccak256(data)).PreimageKey()
+	sha256Key := Sha256Key(Sha256(data)).PreimageKey()
+
+	t.Run("hash keys", func(t *testing.T) {
+		v := &Verifier{}
+		require.NoError(t, v.Verify(keccakKey, data))
+		require.NoError(t, v.Verify(sha256Key, data))
+		require.ErrorContains(t, v.Verify(keccakKey, data[1:]), "keccak256")
+		require.ErrorContains(t, v.Verify(sha256Key, data[1:]), "sha256")
+		require.NoError(t, v.Verify(LocalIndexKey(0).PreimageKey(), data), "local keys are not checked without declarations")
+	})
+	t.Run("size limit", func(t *testing.T) {
+		v := &Verifier{MaxSize: 4}
+		require.ErrorContains(t, v.Verify(keccakKey, data), "exceeds the limit of 4 bytes")
+		require.NoError(t, v.Verify(Keccak256Key(Keccak256(data[:4])).PreimageKey(), data[:4]))
+	})
+	t.Run("local keys", func(t *testing.T) {
+		v := &Verifier{Local: map[LocalIndexKey][]byte{
+			0: []byte("foo"),
+			1: {},
+		}}
+		require.NoError(t, v.Verify(LocalIndexKey(0).PreimageKey(), []byte("foo")))
+		require.NoError(t, v.Verify(LocalIndexKey(1).PreimageKey(), nil))
+		require.ErrorContains(t, v.Verify(LocalIndexKey(0).PreimageKey(), []byte("bar")), "does not match declared local key")
+		require.ErrorContains(t, v.Verify(LocalIndexKey(2).PreimageKey(), []byte("foo")), "is not declared")
+		// a local key with other bits set is not one of the declared indices
+		key := LocalIndexKey(0).PreimageKey()
+		key[1] = 1
+		require.ErrorContains(t, v.Verify(key, []byte("foo")), "is not declared")
+	})
+}
+
+type contextOracleFn func(ctx context.Context, key Key) ([]byte, error)
+
+func (fn contextOracleFn) GetContext(ctx context.Context, key Key) ([]byte, error) {
+	return fn(ctx, key)
+}
+
+func TestVerifyingOracle(t *testing.T) {
+	data := []byte("hello world")
+	preimages := map[[32]byte][]byte{
+		Keccak256Key(Keccak256(data)).PreimageKey():        data,
+		Keccak256Key(Keccak256([]byte("x"))).PreimageKey(): []byte("y"),
+	}
+	get := func(ctx context.Context, key [32]byte) ([]byte, error) {
+		dat, ok := preimages[key]
+		if !ok {
+			return nil, errors.New("not found")
+		}
+		return dat, nil
+	}
+	o := NewVerifyingOracle(contextOracleFn(func(ctx context.Context, key Key) ([]byte, error) {
+		return get(ctx, key.PreimageKey())
+	}), &Verifier{})
+	dat, err := o.GetContext(context.Background(), Keccak256Key(Keccak256(data)))
+	require.NoError(t, err)
+	require.Equal(t, data, dat)
+	_, err = o.GetContext(context.Background(), Keccak256Key(Keccak256([]byte("x"))))
+	require.ErrorContains(t, err, "does not match keccak256 key")
+	_, err = o.GetContext(context.Background(), Keccak256Key(Keccak256([]byte("z"))))
+	require.ErrorContains(t, err, "not found")
+
+	vget := VerifyingGetter(get, &Verifier{})
+	dat, err = vget(context.Background(), Keccak256Key(Keccak256(data)).PreimageKey())
+	require.NoError(t, err)
+	require.Equal(t, data, dat)
+	_, err = vget(context.Background(), Keccak256Key(Keccak256([]byte("x"))).PreimageKey())
+	require.ErrorContains(t, err, "does not match keccak256 key")
+}